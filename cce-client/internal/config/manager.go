@@ -4,28 +4,281 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
 
 	"gopkg.in/yaml.v3"
 )
 
 // ProxyConfig 代理配置（简化版，与 proxy/internal/models/config.go 对应）
 type ProxyConfig struct {
-	Port             int `yaml:"port"`
-	ReadTimeout      int `yaml:"read_timeout"`
-	WriteTimeout     int `yaml:"write_timeout"`
-	IdleTimeout      int `yaml:"idle_timeout"`
-	RequestTimeout   int `yaml:"request_timeout"`
-	EvaluatorTimeout int `yaml:"evaluator_timeout"`
+	Port             int    `yaml:"port"`
+	ListenAddress    string `yaml:"listen_address,omitempty"`
+	ReadTimeout      int    `yaml:"read_timeout"`
+	WriteTimeout     int    `yaml:"write_timeout"`
+	IdleTimeout      int    `yaml:"idle_timeout"`
+	RequestTimeout   int    `yaml:"request_timeout"`
+	EvaluatorTimeout int    `yaml:"evaluator_timeout"`
+
+	CORSAllowedOrigins []string `yaml:"cors_allowed_origins,omitempty"`
 }
 
 // Service 服务配置
 type Service struct {
-	ID               string `yaml:"id"`
-	Name             string `yaml:"name"`
-	URL              string `yaml:"url"`
-	APIKey           string `yaml:"api_key"`
-	Role             string `yaml:"role"` // "evaluator" or "executor"
-	SupportsThinking *bool  `yaml:"supports_thinking,omitempty"`
+	ID               string            `yaml:"id"`
+	Name             string            `yaml:"name"`
+	URL              string            `yaml:"url"`
+	APIKey           string            `yaml:"api_key"`
+	Role             string            `yaml:"role"` // "evaluator" or "executor"
+	SupportsThinking *bool             `yaml:"supports_thinking,omitempty"`
+	ProbePath        string            `yaml:"probe_path,omitempty"`
+	Pricing          *Pricing          `yaml:"pricing,omitempty"`
+	ModelMap         map[string]string `yaml:"model_map,omitempty"`
+	Transforms       []Transform       `yaml:"transforms,omitempty"`
+	Protocol         string            `yaml:"protocol,omitempty"`    // "anthropic" or "openai"
+	AuthScheme       string            `yaml:"auth_scheme,omitempty"` // bearer, x-api-key, header, passthrough
+	AuthHeader       string            `yaml:"auth_header,omitempty"`
+}
+
+// Transform 转发前的请求体转换步骤
+type Transform struct {
+	Type      string            `yaml:"type"`
+	Fields    []string          `yaml:"fields,omitempty"`
+	Field     string            `yaml:"field,omitempty"`
+	Value     interface{}       `yaml:"value,omitempty"`
+	Max       int               `yaml:"max,omitempty"`
+	ToolTypes []string          `yaml:"tool_types,omitempty"`
+	To        string            `yaml:"to,omitempty"`
+	Map       map[string]string `yaml:"map,omitempty"`
+}
+
+// Pricing 价格表（每百万 token）
+type Pricing struct {
+	InputPerMTok      float64 `yaml:"input_per_mtok,omitempty"`
+	OutputPerMTok     float64 `yaml:"output_per_mtok,omitempty"`
+	CacheReadPerMTok  float64 `yaml:"cache_read_per_mtok,omitempty"`
+	CacheWritePerMTok float64 `yaml:"cache_write_per_mtok,omitempty"`
+}
+
+// StickyRoutingConfig 会话粘滞路由配置
+type StickyRoutingConfig struct {
+	Enabled     bool `yaml:"enabled"`
+	MaxTurns    int  `yaml:"max_turns,omitempty"`
+	IdleSeconds int  `yaml:"idle_seconds,omitempty"`
+}
+
+// EscalationConfig 失败升级配置
+type EscalationConfig struct {
+	Enabled      bool `yaml:"enabled"`
+	MaxFromLevel int  `yaml:"max_from_level,omitempty"`
+	MaxSteps     int  `yaml:"max_steps,omitempty"`
+	BiasTurns    int  `yaml:"bias_turns,omitempty"`
+}
+
+// OverridesConfig 手动路由覆盖配置
+type OverridesConfig struct {
+	Enabled      bool     `yaml:"enabled"`
+	AllowedUsers []string `yaml:"allowed_users,omitempty"`
+	AllowService *bool    `yaml:"allow_service,omitempty"`
+}
+
+// ModelRoutingConfig 按请求模型路由配置
+type ModelRoutingConfig struct {
+	Rules []ModelRoutingRule `yaml:"rules,omitempty"`
+}
+
+// ModelRoutingRule 模型路由规则
+type ModelRoutingRule struct {
+	Pattern string `yaml:"pattern"`
+	Level   int    `yaml:"level"`
+}
+
+// CostConfig 费用统计配置
+type CostConfig struct {
+	Currency string   `yaml:"currency,omitempty"`
+	Baseline *Pricing `yaml:"baseline,omitempty"`
+}
+
+// LedgerConfig 本地请求记录配置
+type LedgerConfig struct {
+	Enabled       bool   `yaml:"enabled"`
+	Path          string `yaml:"path,omitempty"`
+	RetentionDays int    `yaml:"retention_days,omitempty"`
+}
+
+// TracingConfig 链路追踪配置
+type TracingConfig struct {
+	Enabled     bool              `yaml:"enabled"`
+	Exporter    string            `yaml:"exporter,omitempty"`
+	Endpoint    string            `yaml:"endpoint,omitempty"`
+	Insecure    *bool             `yaml:"insecure,omitempty"`
+	Headers     map[string]string `yaml:"headers,omitempty"`
+	ServiceName string            `yaml:"service_name,omitempty"`
+	SampleRatio *float64          `yaml:"sample_ratio,omitempty"`
+}
+
+// BudgetsConfig 预算与限流配置
+type BudgetsConfig struct {
+	Enabled        bool         `yaml:"enabled"`
+	StatePath      string       `yaml:"state_path,omitempty"`
+	DowngradeLevel int          `yaml:"downgrade_level,omitempty"`
+	User           BudgetLimits `yaml:"user,omitempty"`
+	Session        BudgetLimits `yaml:"session,omitempty"`
+	Users          []UserBudget `yaml:"users,omitempty"`
+}
+
+// BudgetLimits 一组限制，0 表示不限制
+type BudgetLimits struct {
+	RPM             int64   `yaml:"rpm,omitempty"`
+	TPM             int64   `yaml:"tpm,omitempty"`
+	DailySoftCost   float64 `yaml:"daily_soft_cost,omitempty"`
+	DailyHardCost   float64 `yaml:"daily_hard_cost,omitempty"`
+	MonthlySoftCost float64 `yaml:"monthly_soft_cost,omitempty"`
+	MonthlyHardCost float64 `yaml:"monthly_hard_cost,omitempty"`
+}
+
+// UserBudget 单独配置的用户限制
+type UserBudget struct {
+	User         string `yaml:"user"`
+	BudgetLimits `yaml:",inline"`
+}
+
+// AdminConfig 管理接口配置
+type AdminConfig struct {
+	Enabled   bool   `yaml:"enabled"`
+	Token     string `yaml:"token,omitempty"`
+	WriteBack *bool  `yaml:"write_back,omitempty"`
+}
+
+// AccessConfig 代理访问令牌配置（令牌由代理的 tokens 子命令签发，这里只保留原值）
+type AccessConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Tokens  []AccessToken `yaml:"tokens,omitempty"`
+}
+
+// AccessToken 访问令牌（只保存哈希）
+type AccessToken struct {
+	User      string   `yaml:"user"`
+	Hash      string   `yaml:"hash"`
+	Levels    []int    `yaml:"levels,omitempty"`
+	Services  []string `yaml:"services,omitempty"`
+	CreatedAt string   `yaml:"created_at,omitempty"`
+}
+
+// ServiceTarget 难度映射的目标服务
+type ServiceTarget struct {
+	ID     string `yaml:"id"`
+	Weight int    `yaml:"weight,omitempty"`
+}
+
+// LevelTargets 单个难度级别映射的服务列表（顺序即切换优先级）
+// YAML 中可写为单个服务 ID、服务 ID 列表或带权重的 {id, weight} 列表
+type LevelTargets []ServiceTarget
+
+// UnmarshalYAML 支持三种写法的解析
+func (t *LevelTargets) UnmarshalYAML(value *yaml.Node) error {
+	switch value.Kind {
+	case yaml.ScalarNode:
+		*t = LevelTargets{{ID: value.Value}}
+		return nil
+	case yaml.SequenceNode:
+		targets := make(LevelTargets, 0, len(value.Content))
+		for _, item := range value.Content {
+			if item.Kind == yaml.ScalarNode {
+				targets = append(targets, ServiceTarget{ID: item.Value})
+				continue
+			}
+			var target ServiceTarget
+			if err := item.Decode(&target); err != nil {
+				return err
+			}
+			var explicit struct {
+				Weight *int `yaml:"weight"`
+			}
+			// 与代理服务解析配置时的校验和错误信息一致，避免保存代理无法加载的配置
+			if item.Decode(&explicit) == nil && explicit.Weight != nil && *explicit.Weight == 0 {
+				return fmt.Errorf("服务 %s 的权重不能为0（权重必须为正整数，不配置时视为1；停用服务请从映射中移除）", target.ID)
+			}
+			targets = append(targets, target)
+		}
+		*t = targets
+		return nil
+	}
+	return fmt.Errorf("无法解析难度映射 (line %d)", value.Line)
+}
+
+// MarshalYAML 尽量输出最简写法，保持配置文件可读
+func (t LevelTargets) MarshalYAML() (interface{}, error) {
+	weighted := false
+	for _, target := range t {
+		if target.Weight != 0 {
+			weighted = true
+			break
+		}
+	}
+	if weighted {
+		return []ServiceTarget(t), nil
+	}
+	if len(t) == 1 {
+		return t[0].ID, nil
+	}
+	return t.IDs(), nil
+}
+
+// IDs 返回服务 ID 列表（保持配置顺序）
+func (t LevelTargets) IDs() []string {
+	ids := make([]string, 0, len(t))
+	for _, target := range t {
+		ids = append(ids, target.ID)
+	}
+	return ids
+}
+
+// Primary 返回首选服务 ID
+func (t LevelTargets) Primary() string {
+	if len(t) == 0 {
+		return ""
+	}
+	return t[0].ID
+}
+
+// WithPrimary 返回替换首选服务后的列表，其余备选服务保持原顺序
+func (t LevelTargets) WithPrimary(id string) LevelTargets {
+	targets := LevelTargets{{ID: id}}
+	for i, target := range t {
+		if i == 0 {
+			targets[0].Weight = target.Weight
+			continue
+		}
+		if target.ID != id {
+			targets = append(targets, target)
+		}
+	}
+	return targets
+}
+
+// LoadBalancingConfig 负载均衡配置
+type LoadBalancingConfig struct {
+	Strategy string            `yaml:"strategy,omitempty"` // failover, round_robin, weighted_random, least_inflight
+	Levels   map[string]string `yaml:"levels,omitempty"`
+}
+
+// CircuitBreakerConfig 熔断配置
+type CircuitBreakerConfig struct {
+	Enabled             *bool `yaml:"enabled,omitempty"`
+	FailureThreshold    int   `yaml:"failure_threshold,omitempty"`
+	SlowCallThresholdMs int   `yaml:"slow_call_threshold_ms,omitempty"`
+	OpenSeconds         int   `yaml:"open_seconds,omitempty"`
+	HalfOpenMaxRequests int   `yaml:"half_open_max_requests,omitempty"`
+}
+
+// HealthCheckConfig 主动健康检查配置
+type HealthCheckConfig struct {
+	Enabled            bool   `yaml:"enabled"`
+	IntervalSeconds    int    `yaml:"interval_seconds,omitempty"`
+	TimeoutSeconds     int    `yaml:"timeout_seconds,omitempty"`
+	UnhealthyThreshold int    `yaml:"unhealthy_threshold,omitempty"`
+	HistorySize        int    `yaml:"history_size,omitempty"`
+	ProbeModel         string `yaml:"probe_model,omitempty"`
 }
 
 // EvaluatorConfig 评估器配置
@@ -35,13 +288,60 @@ type EvaluatorConfig struct {
 	IncludeHistory   bool   `yaml:"include_history"`
 	MaxHistoryRounds int    `yaml:"max_history_rounds"`
 	PromptTemplate   string `yaml:"prompt_template"`
+
+	// 评估策略：llm、rules、heuristic、classifier、fixed 或 chain，留空为 llm
+	Strategy   string                   `yaml:"strategy,omitempty"`
+	Chain      []string                 `yaml:"chain,omitempty"`
+	FixedLevel int                      `yaml:"fixed_level,omitempty"`
+	Rules      RulesEvaluatorConfig     `yaml:"rules,omitempty"`
+	Heuristic  HeuristicEvaluatorConfig `yaml:"heuristic,omitempty"`
+	Classifier ClassifierConfig         `yaml:"classifier,omitempty"`
+	Cache      EvaluatorCacheConfig     `yaml:"cache,omitempty"`
+}
+
+// EvaluatorCacheConfig 评估结果缓存配置
+type EvaluatorCacheConfig struct {
+	Enabled           *bool `yaml:"enabled,omitempty"`
+	MaxEntries        int   `yaml:"max_entries,omitempty"`
+	TTLSeconds        int   `yaml:"ttl_seconds,omitempty"`
+	ReuseOnToolResult bool  `yaml:"reuse_on_tool_result,omitempty"`
+}
+
+// ClassifierConfig 离线分类器配置
+type ClassifierConfig struct {
+	ModelPath     string  `yaml:"model_path,omitempty"`
+	MinConfidence float64 `yaml:"min_confidence,omitempty"`
+}
+
+// RulesEvaluatorConfig 规则评估配置
+type RulesEvaluatorConfig struct {
+	MinScore float64          `yaml:"min_score,omitempty"`
+	Rules    []DifficultyRule `yaml:"rules,omitempty"`
+}
+
+// DifficultyRule 单条难度规则
+type DifficultyRule struct {
+	Name     string   `yaml:"name,omitempty"`
+	Level    int      `yaml:"level"`
+	Keywords []string `yaml:"keywords,omitempty"`
+	Pattern  string   `yaml:"pattern,omitempty"`
+	Weight   float64  `yaml:"weight,omitempty"`
+}
+
+// HeuristicEvaluatorConfig 启发式评估配置
+type HeuristicEvaluatorConfig struct {
+	MessageThresholds []int `yaml:"message_thresholds,omitempty"`
+	ToolThresholds    []int `yaml:"tool_thresholds,omitempty"`
+	TokenThresholds   []int `yaml:"token_thresholds,omitempty"`
+	DecideLevels      []int `yaml:"decide_levels,omitempty"`
 }
 
 // Features 功能开关
 type Features struct {
-	EvaluatorFallback bool `yaml:"evaluator_fallback"`
-	ServiceAutoSwitch bool `yaml:"service_auto_switch"`
-	RequestLogging    bool `yaml:"request_logging"`
+	EvaluatorFallback bool  `yaml:"evaluator_fallback"`
+	ServiceAutoSwitch bool  `yaml:"service_auto_switch"`
+	RequestLogging    bool  `yaml:"request_logging"`
+	ConfigHotReload   *bool `yaml:"config_hot_reload,omitempty"`
 }
 
 // LoggingConfig 日志配置
@@ -52,18 +352,45 @@ type LoggingConfig struct {
 
 // Config 完整配置
 type Config struct {
-	Proxy             ProxyConfig            `yaml:"proxy"`
-	Services          []Service              `yaml:"services"`
-	DifficultyMapping map[string]string      `yaml:"difficulty_mapping"`
-	Evaluator         EvaluatorConfig        `yaml:"evaluator"`
-	Features          Features               `yaml:"features"`
-	Logging           LoggingConfig          `yaml:"logging"`
+	Proxy             ProxyConfig             `yaml:"proxy"`
+	Services          []Service               `yaml:"services"`
+	DifficultyMapping map[string]LevelTargets `yaml:"difficulty_mapping"`
+	LoadBalancing     LoadBalancingConfig     `yaml:"load_balancing,omitempty"`
+	CircuitBreaker    CircuitBreakerConfig    `yaml:"circuit_breaker,omitempty"`
+	HealthCheck       HealthCheckConfig       `yaml:"health_check,omitempty"`
+	StickyRouting     StickyRoutingConfig     `yaml:"sticky_routing,omitempty"`
+	Escalation        EscalationConfig        `yaml:"escalation,omitempty"`
+	Overrides         OverridesConfig         `yaml:"overrides,omitempty"`
+	ModelRouting      ModelRoutingConfig      `yaml:"model_routing,omitempty"`
+	Cost              CostConfig              `yaml:"cost,omitempty"`
+	Ledger            LedgerConfig            `yaml:"ledger,omitempty"`
+	Budgets           BudgetsConfig           `yaml:"budgets,omitempty"`
+	Tracing           TracingConfig           `yaml:"tracing,omitempty"`
+	Admin             AdminConfig             `yaml:"admin,omitempty"`
+	Access            AccessConfig            `yaml:"access,omitempty"`
+	Evaluator         EvaluatorConfig         `yaml:"evaluator"`
+	Features          Features                `yaml:"features"`
+	Logging           LoggingConfig           `yaml:"logging"`
 }
 
 // Manager 配置管理器
 type Manager struct {
-	configPath string
-	config     *Config
+	configPath   string
+	config       *Config
+	applied      restartFields // 上次加载或保存时需要重启才能生效的配置项
+	hasPlaintext bool          // 加载的配置文件中有明文密钥
+}
+
+// restartFields 代理服务不支持热加载、修改后必须重启的配置项（与代理服务的 logRestartOnlyChanges 保持一致）
+type restartFields struct {
+	ListenAddress string
+	Port          int
+	ReadTimeout   int
+	WriteTimeout  int
+	IdleTimeout   int
+	Logging       LoggingConfig
+	Ledger        LedgerConfig
+	Tracing       TracingConfig
 }
 
 // NewManager 创建配置管理器
@@ -89,10 +416,17 @@ func NewManager() (*Manager, error) {
 		if err := manager.Save(); err != nil {
 			return nil, fmt.Errorf("保存默认配置失败: %w", err)
 		}
+		manager.MarkApplied()
 	} else {
 		if err := manager.Load(); err != nil {
 			return nil, fmt.Errorf("加载配置失败: %w", err)
 		}
+		// 旧版本把 API Key 明文写在配置文件中，迁移到密钥目录
+		if manager.hasPlaintext {
+			if err := manager.Save(); err != nil {
+				return nil, fmt.Errorf("迁移 API Key 失败: %w", err)
+			}
+		}
 	}
 
 	return manager, nil
@@ -109,24 +443,75 @@ func (m *Manager) Load() error {
 	if err := yaml.Unmarshal(data, m.config); err != nil {
 		return fmt.Errorf("解析配置文件失败: %w", err)
 	}
+	m.hasPlaintext = m.loadSecrets(m.config)
+	m.applied = m.currentRestartFields()
 
 	return nil
 }
 
 // Save 保存配置
+// API Key 写入密钥目录，配置文件中只保存 file: 引用；配置文件权限为 0600
 func (m *Manager) Save() error {
-	data, err := yaml.Marshal(m.config)
+	stored, err := m.storeSecrets(m.config)
+	if err != nil {
+		return err
+	}
+	data, err := yaml.Marshal(stored)
 	if err != nil {
 		return fmt.Errorf("序列化配置失败: %w", err)
 	}
 
-	if err := os.WriteFile(m.configPath, data, 0644); err != nil {
+	// 先写临时文件再重命名，代理服务监听到变化时不会读到写了一半的文件
+	if err := writePrivateFile(m.configPath, data); err != nil {
 		return fmt.Errorf("写入配置文件失败: %w", err)
 	}
+	m.hasPlaintext = false
 
 	return nil
 }
 
+// NeedsRestart 判断未保存的修改中是否包含需要重启代理服务才能生效的配置项
+// （监听地址、端口、服务器超时、日志、请求记录、链路追踪）；其余配置保存后由代理服务热加载
+func (m *Manager) NeedsRestart() bool {
+	return !reflect.DeepEqual(m.currentRestartFields(), m.applied)
+}
+
+// MarkApplied 记录当前配置已被代理服务采用（保存并热加载或重启之后调用）
+func (m *Manager) MarkApplied() {
+	m.applied = m.currentRestartFields()
+}
+
+// currentRestartFields 提取当前配置中需要重启才能生效的配置项
+func (m *Manager) currentRestartFields() restartFields {
+	// 链路追踪配置包含 map 和指针，复制一份，避免界面原地修改时 applied 跟着变化
+	tracing := m.config.Tracing
+	if tracing.Headers != nil {
+		tracing.Headers = make(map[string]string, len(m.config.Tracing.Headers))
+		for key, value := range m.config.Tracing.Headers {
+			tracing.Headers[key] = value
+		}
+	}
+	if tracing.Insecure != nil {
+		insecure := *tracing.Insecure
+		tracing.Insecure = &insecure
+	}
+	if tracing.SampleRatio != nil {
+		ratio := *tracing.SampleRatio
+		tracing.SampleRatio = &ratio
+	}
+
+	return restartFields{
+		ListenAddress: m.config.Proxy.ListenAddress,
+		Port:          m.config.Proxy.Port,
+		ReadTimeout:   m.config.Proxy.ReadTimeout,
+		WriteTimeout:  m.config.Proxy.WriteTimeout,
+		IdleTimeout:   m.config.Proxy.IdleTimeout,
+		Logging:       m.config.Logging,
+		Ledger:        m.config.Ledger,
+		Tracing:       tracing,
+	}
+}
+
 // GetConfig 获取配置对象
 func (m *Manager) GetConfig() *Config {
 	return m.config
@@ -195,12 +580,17 @@ func (m *Manager) Validate() error {
 	// 检查难度映射
 	for level := 1; level <= 5; level++ {
 		levelStr := fmt.Sprintf("%d", level)
-		serviceID, exists := m.config.DifficultyMapping[levelStr]
-		if !exists {
+		targets, exists := m.config.DifficultyMapping[levelStr]
+		if !exists || len(targets) == 0 {
 			return fmt.Errorf("难度级别 %d 未配置", level)
 		}
-		if !serviceIDs[serviceID] {
-			return fmt.Errorf("难度级别 %d 映射的服务 %s 不存在", level, serviceID)
+		for _, target := range targets {
+			if !serviceIDs[target.ID] {
+				return fmt.Errorf("难度级别 %d 映射的服务 %s 不存在", level, target.ID)
+			}
+			if target.Weight < 0 {
+				return fmt.Errorf("难度级别 %d 中服务 %s 的权重不能为负数", level, target.ID)
+			}
 		}
 	}
 
@@ -248,12 +638,12 @@ func getDefaultConfig() *Config {
 				SupportsThinking: &trueVal,
 			},
 		},
-		DifficultyMapping: map[string]string{
-			"1": "haiku-service",
-			"2": "haiku-service",
-			"3": "haiku-service",
-			"4": "haiku-service",
-			"5": "haiku-service",
+		DifficultyMapping: map[string]LevelTargets{
+			"1": {{ID: "haiku-service"}},
+			"2": {{ID: "haiku-service"}},
+			"3": {{ID: "haiku-service"}},
+			"4": {{ID: "haiku-service"}},
+			"5": {{ID: "haiku-service"}},
 		},
 		Evaluator: EvaluatorConfig{
 			Model:            "claude-3-haiku-20240307",
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// secretsDirName 保存 API Key 等密钥的目录（位于配置目录下），配置文件中只保存指向这里的 file: 引用
+const secretsDirName = "secrets"
+
+// secretRefPrefixes 代理服务支持的密钥引用前缀
+var secretRefPrefixes = []string{"env:", "file:", "cmd:"}
+
+// adminSecretFile 管理令牌的密钥文件名
+const adminSecretFile = "admin_token.key"
+
+// managedSecretFile 客户端写入的密钥文件名（服务密钥按服务 ID 的哈希命名），只清理这些文件
+var managedSecretFile = regexp.MustCompile(`^(service_[0-9a-f]{16}\.key|` + regexp.QuoteMeta(adminSecretFile) + `)$`)
+
+// IsSecretRef 判断值是否为密钥引用（env:、file:、cmd:）
+func IsSecretRef(value string) bool {
+	for _, prefix := range secretRefPrefixes {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// secretsDir 密钥目录
+func (m *Manager) secretsDir() string {
+	return filepath.Join(filepath.Dir(m.configPath), secretsDirName)
+}
+
+// serviceSecretPath 服务 API Key 的密钥文件路径
+// 文件名使用服务 ID 的哈希，不同的服务 ID（如 a/b 和 a_b）不会共用同一个文件
+func (m *Manager) serviceSecretPath(serviceID string) string {
+	sum := sha256.Sum256([]byte(serviceID))
+	return filepath.Join(m.secretsDir(), "service_"+hex.EncodeToString(sum[:8])+".key")
+}
+
+// adminSecretPath 管理令牌的密钥文件路径
+func (m *Manager) adminSecretPath() string {
+	return filepath.Join(m.secretsDir(), adminSecretFile)
+}
+
+// loadSecrets 将指向密钥目录的 file: 引用替换为密钥内容（只在内存中，便于界面编辑）
+// 其他引用（env:、cmd:、其他位置的文件）保持原样；返回配置文件中是否还有明文密钥
+func (m *Manager) loadSecrets(cfg *Config) bool {
+	plaintext := false
+	load := func(value *string) {
+		if *value == "" {
+			return
+		}
+		if !IsSecretRef(*value) {
+			plaintext = true
+			return
+		}
+		path, ok := strings.CutPrefix(*value, "file:")
+		if !ok || filepath.Dir(path) != m.secretsDir() {
+			return
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			// 密钥文件丢失时保留引用，代理服务启动时会报告解析失败
+			return
+		}
+		*value = strings.TrimSpace(string(data))
+	}
+
+	for i := range cfg.Services {
+		load(&cfg.Services[i].APIKey)
+	}
+	load(&cfg.Admin.Token)
+	return plaintext
+}
+
+// storeSecrets 将明文密钥写入密钥目录（0600），返回用 file: 引用替换明文后的配置副本
+// 客户端写入的密钥文件不再使用时会被删除，用户自己放在密钥目录中的文件保持不变
+func (m *Manager) storeSecrets(cfg *Config) (*Config, error) {
+	out := *cfg
+	out.Services = make([]Service, len(cfg.Services))
+	copy(out.Services, cfg.Services)
+
+	if err := os.MkdirAll(m.secretsDir(), 0700); err != nil {
+		return nil, fmt.Errorf("创建密钥目录失败: %w", err)
+	}
+
+	used := make(map[string]bool)
+	store := func(value *string, path string) error {
+		if *value == "" || IsSecretRef(*value) {
+			return nil
+		}
+		if err := writePrivateFile(path, []byte(*value+"\n")); err != nil {
+			return fmt.Errorf("写入密钥文件失败: %w", err)
+		}
+		used[path] = true
+		*value = "file:" + path
+		return nil
+	}
+
+	for i := range out.Services {
+		if err := store(&out.Services[i].APIKey, m.serviceSecretPath(out.Services[i].ID)); err != nil {
+			return nil, err
+		}
+	}
+	if err := store(&out.Admin.Token, m.adminSecretPath()); err != nil {
+		return nil, err
+	}
+
+	entries, err := os.ReadDir(m.secretsDir())
+	if err != nil {
+		return nil, fmt.Errorf("读取密钥目录失败: %w", err)
+	}
+	for _, entry := range entries {
+		path := filepath.Join(m.secretsDir(), entry.Name())
+		if managedSecretFile.MatchString(entry.Name()) && !used[path] {
+			os.Remove(path)
+		}
+	}
+
+	return &out, nil
+}
+
+// writePrivateFile 以 0600 权限写入文件（先写临时文件再重命名）
+func writePrivateFile(path string, data []byte) error {
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0600); err != nil {
+		return err
+	}
+	// 临时文件可能是之前遗留的，WriteFile 不会修改已存在文件的权限
+	if err := os.Chmod(tmpPath, 0600); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// adminReload 通过代理的管理接口重新加载配置文件
+// 与 SIGHUP 不同，配置校验失败时可以直接拿到错误信息
+func (m *Manager) adminReload(token string) error {
+	client := &http.Client{
+		Timeout: 5 * time.Second,
+	}
+
+	port := m.configManager.GetProxyPort()
+	url := fmt.Sprintf("http://127.0.0.1:%d/admin/reload", port)
+
+	req, err := http.NewRequest(http.MethodPost, url, nil)
+	if err != nil {
+		return fmt.Errorf("创建重新加载请求失败: %w", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("请求重新加载配置失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		var result struct {
+			Error string `json:"error"`
+		}
+		if json.Unmarshal(body, &result) == nil && result.Error != "" {
+			return fmt.Errorf("代理服务拒绝了新配置: %s", result.Error)
+		}
+		return fmt.Errorf("重新加载配置失败: status=%d", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ServiceHealth 代理返回的单个服务健康状态（对应 proxy 的 /services/health）
+type ServiceHealth struct {
+	ServiceID           string    `json:"service_id"`
+	Name                string    `json:"name"`
+	Role                string    `json:"role"`
+	Healthy             bool      `json:"healthy"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	Availability        float64   `json:"availability"`
+	AvgLatencyMs        int64     `json:"avg_latency_ms"`
+	LastCheck           time.Time `json:"last_check"`
+}
+
+// ServicesHealthReport /services/health 响应
+type ServicesHealthReport struct {
+	Enabled  bool            `json:"enabled"`
+	Services []ServiceHealth `json:"services"`
+}
+
+// GetServicesHealth 从运行中的代理获取各服务的探测结果
+func (m *Manager) GetServicesHealth() (*ServicesHealthReport, error) {
+	client := &http.Client{
+		Timeout: 2 * time.Second,
+	}
+
+	port := m.configManager.GetProxyPort()
+	url := fmt.Sprintf("http://127.0.0.1:%d/services/health", port)
+
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, fmt.Errorf("请求服务健康状态失败: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("服务健康状态接口返回异常状态码: %d", resp.StatusCode)
+	}
+
+	var report ServicesHealthReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("解析服务健康状态失败: %w", err)
+	}
+
+	return &report, nil
+}
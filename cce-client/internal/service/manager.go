@@ -190,6 +190,36 @@ func (m *Manager) Restart() error {
 	return m.Start()
 }
 
+// Reload 通知代理服务重新加载配置文件，不中断进行中的请求
+// 知道管理接口令牌时通过 /admin/reload 重新加载并同步返回校验错误（客户端密钥目录中的令牌加载时已读出）；
+// 否则发送 SIGHUP（校验失败时代理服务保留旧配置并记录日志）。
+// 令牌为 env:、cmd: 等引用时客户端不解析（只由代理服务解析），同样发送 SIGHUP
+func (m *Manager) Reload() error {
+	if admin := m.configManager.GetConfig().Admin; admin.Enabled && admin.Token != "" {
+		if !config.IsSecretRef(admin.Token) {
+			if err := m.adminReload(admin.Token); err != nil {
+				return err
+			}
+			log.Println("代理服务已重新加载配置")
+			return nil
+		}
+		log.Println("管理令牌为密钥引用，改为发送重新加载信号")
+	}
+
+	m.statusMu.RLock()
+	defer m.statusMu.RUnlock()
+
+	if m.process == nil {
+		return fmt.Errorf("服务未由客户端启动，无法发送重新加载信号")
+	}
+	if err := m.process.Signal(syscall.SIGHUP); err != nil {
+		return fmt.Errorf("发送重新加载信号失败: %w", err)
+	}
+
+	log.Println("已通知代理服务重新加载配置")
+	return nil
+}
+
 // GetStatus 获取当前状态
 func (m *Manager) GetStatus() Status {
 	m.statusMu.RLock()
@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"strings"
 
 	"fyne.io/fyne/v2"
 	"fyne.io/fyne/v2/container"
@@ -39,7 +40,7 @@ func (cv *ConfigView) Build() fyne.CanvasObject {
 	)
 
 	// 保存按钮
-	saveBtn := widget.NewButton("保存配置并重启服务", func() {
+	saveBtn := widget.NewButton("保存并应用配置", func() {
 		cv.saveConfig()
 	})
 	saveBtn.Importance = widget.HighImportance
@@ -135,15 +136,28 @@ func (cv *ConfigView) buildDifficultyTab() fyne.CanvasObject {
 
 	for level := 1; level <= 5; level++ {
 		levelStr := fmt.Sprintf("%d", level)
-		currentValue := cfg.DifficultyMapping[levelStr]
+		currentValue := cfg.DifficultyMapping[levelStr].Primary()
+
+		// 下拉框只修改首选服务，配置文件中的备选服务保持不变
+		fallbackLabel := widget.NewLabel("")
+		updateFallbackLabel := func() {
+			targets := cfg.DifficultyMapping[levelStr]
+			if len(targets) > 1 {
+				fallbackLabel.SetText(fmt.Sprintf("备选: %s", strings.Join(targets[1:].IDs(), " -> ")))
+			} else {
+				fallbackLabel.SetText("")
+			}
+		}
 
 		sel := widget.NewSelect(executors, func(value string) {
-			cfg.DifficultyMapping[levelStr] = value
+			cfg.DifficultyMapping[levelStr] = cfg.DifficultyMapping[levelStr].WithPrimary(value)
+			updateFallbackLabel()
 		})
 		sel.SetSelected(currentValue)
+		updateFallbackLabel()
 
 		selects[levelStr] = sel
-		form.Append(fmt.Sprintf("难度级别 %d", level), sel)
+		form.Append(fmt.Sprintf("难度级别 %d", level), container.NewVBox(sel, fallbackLabel))
 	}
 
 	return container.NewVScroll(form)
@@ -213,16 +227,30 @@ func (cv *ConfigView) saveConfig() {
 		return
 	}
 
-	showInfo(nil, "保存成功", "配置已保存，正在重启服务...")
+	if !cv.serviceManager.IsRunning() {
+		cv.configManager.MarkApplied()
+		showInfo(nil, "保存成功", "配置已保存，将在服务启动时生效")
+		return
+	}
 
-	// 重启服务
-	if cv.serviceManager.IsRunning() {
+	// 监听地址、端口、超时、日志、请求记录、链路追踪的修改需要重启，其余配置由代理服务热加载，不中断进行中的请求
+	if cv.configManager.NeedsRestart() {
+		showInfo(nil, "保存成功", "部分配置需要重启服务才能生效，正在重启服务...")
 		if err := cv.serviceManager.Restart(); err != nil {
 			showError(nil, "重启服务失败", err.Error())
-		} else {
-			showInfo(nil, "重启成功", "服务已重启")
+			return
 		}
+		cv.configManager.MarkApplied()
+		showInfo(nil, "重启成功", "服务已重启")
+		return
 	}
+
+	if err := cv.serviceManager.Reload(); err != nil {
+		showError(nil, "应用配置失败", err.Error())
+		return
+	}
+	cv.configManager.MarkApplied()
+	showInfo(nil, "保存成功", "配置已保存并热加载，进行中的请求不受影响")
 }
 
 // showAddServiceDialog 显示添加服务对话框
@@ -273,6 +301,18 @@ func (cv *ConfigView) showServiceDialog(svc *config.Service, isNew bool) {
 		roleSelect.SetSelected("executor")
 	}
 
+	// 认证方式：自定义请求头（header）需要在配置文件中填写 auth_header，这里只在已配置时显示
+	authSchemes := []string{"bearer", "x-api-key", "passthrough"}
+	if svc.AuthScheme == "header" {
+		authSchemes = append(authSchemes, "header")
+	}
+	authSelect := widget.NewSelect(authSchemes, nil)
+	if svc.AuthScheme != "" {
+		authSelect.SetSelected(svc.AuthScheme)
+	} else {
+		authSelect.SetSelected("bearer")
+	}
+
 	thinkingCheck := widget.NewCheck("", func(checked bool) {
 		svc.SupportsThinking = &checked
 	})
@@ -288,6 +328,7 @@ func (cv *ConfigView) showServiceDialog(svc *config.Service, isNew bool) {
 		{Text: "服务名称", Widget: nameEntry},
 		{Text: "API URL", Widget: urlEntry},
 		{Text: "API Key", Widget: apiKeyEntry},
+		{Text: "认证方式", Widget: authSelect},
 		{Text: "角色", Widget: roleSelect},
 		{Text: "支持 Thinking", Widget: thinkingCheck},
 	}
@@ -299,7 +340,9 @@ func (cv *ConfigView) showServiceDialog(svc *config.Service, isNew bool) {
 		}
 
 		// 验证输入
-		if idEntry.Text == "" || nameEntry.Text == "" || urlEntry.Text == "" || apiKeyEntry.Text == "" {
+		// passthrough 透传客户端凭据，不需要 API Key
+		passthrough := authSelect.Selected == "passthrough"
+		if idEntry.Text == "" || nameEntry.Text == "" || urlEntry.Text == "" || (apiKeyEntry.Text == "" && !passthrough) {
 			showError(nil, "输入错误", "所有字段都必须填写")
 			return
 		}
@@ -310,6 +353,10 @@ func (cv *ConfigView) showServiceDialog(svc *config.Service, isNew bool) {
 		svc.URL = urlEntry.Text
 		svc.APIKey = apiKeyEntry.Text
 		svc.Role = roleSelect.Selected
+		svc.AuthScheme = authSelect.Selected
+		if svc.AuthScheme == "bearer" {
+			svc.AuthScheme = "" // 默认值不写入配置文件
+		}
 
 		cfg := cv.configManager.GetConfig()
 		if isNew {
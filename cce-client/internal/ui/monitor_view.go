@@ -2,6 +2,7 @@ package ui
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"fyne.io/fyne/v2"
@@ -16,6 +17,7 @@ type MonitorView struct {
 	serviceManager *service.Manager
 	configManager  *config.Manager
 	statusLabel    *widget.Label
+	healthLabel    *widget.Label
 	requestsLabel  *widget.Label
 	difficultyInfo *widget.Label
 	ticker         *time.Ticker
@@ -36,6 +38,9 @@ func (mv *MonitorView) Build() fyne.CanvasObject {
 	// 服务状态
 	mv.statusLabel = widget.NewLabel("服务状态: 未知")
 
+	// 各服务健康状态
+	mv.healthLabel = widget.NewLabel("服务健康: -")
+
 	// 请求统计（占位）
 	mv.requestsLabel = widget.NewLabel("总请求数: -")
 
@@ -50,6 +55,7 @@ func (mv *MonitorView) Build() fyne.CanvasObject {
 	// 布局
 	content := container.NewVBox(
 		widget.NewCard("服务状态", "", mv.statusLabel),
+		widget.NewCard("服务健康", "", mv.healthLabel),
 		widget.NewCard("请求统计", "", mv.requestsLabel),
 		widget.NewCard("难度分布", "", mv.difficultyInfo),
 		refreshBtn,
@@ -67,6 +73,7 @@ func (mv *MonitorView) updateStats() {
 	// 获取数据并更新 UI（Fyne v2 UI 更新是线程安全的）
 	status := mv.serviceManager.GetStatus()
 	mv.statusLabel.SetText(fmt.Sprintf("服务状态: %s", status))
+	mv.healthLabel.SetText(mv.formatServicesHealth())
 
 	// TODO: 实现日志解析和统计
 	mv.requestsLabel.SetText("总请求数: - （功能开发中）")
@@ -78,6 +85,35 @@ func (mv *MonitorView) updateStats() {
 		"- 平均响应时间: XX ms")
 }
 
+// formatServicesHealth 格式化各服务的健康探测结果
+func (mv *MonitorView) formatServicesHealth() string {
+	if !mv.serviceManager.IsRunning() {
+		return "服务未运行"
+	}
+
+	report, err := mv.serviceManager.GetServicesHealth()
+	if err != nil {
+		return fmt.Sprintf("获取失败: %v", err)
+	}
+	if !report.Enabled {
+		return "未启用主动健康检查（在配置中设置 health_check.enabled: true）"
+	}
+
+	var lines []string
+	for _, svc := range report.Services {
+		state := "✅ 正常"
+		if !svc.Healthy {
+			state = fmt.Sprintf("❌ 异常（连续失败 %d 次）", svc.ConsecutiveFailures)
+		}
+		if svc.LastCheck.IsZero() {
+			state = "⏳ 等待探测"
+		}
+		lines = append(lines, fmt.Sprintf("%s [%s]: %s, 可用率 %.0f%%, 平均延迟 %d ms",
+			svc.ServiceID, svc.Role, state, svc.Availability*100, svc.AvgLatencyMs))
+	}
+	return strings.Join(lines, "\n")
+}
+
 // startAutoRefresh 启动自动刷新
 func (mv *MonitorView) startAutoRefresh() {
 	mv.ticker = time.NewTicker(10 * time.Second)
@@ -1,13 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"strings"
+	"time"
 	
 	"github.com/ethan/claude-proxy/internal/config"
 	"github.com/ethan/claude-proxy/internal/logger"
 	"github.com/ethan/claude-proxy/internal/proxy"
+	"github.com/ethan/claude-proxy/internal/tracing"
 )
 
 var (
@@ -30,6 +34,22 @@ const (
 )
 
 func main() {
+	// 子命令
+	if len(os.Args) > 1 && os.Args[1] == "train-classifier" {
+		if err := runTrainClassifier(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "训练失败: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "tokens" {
+		if err := runTokens(os.Args[2:]); err != nil {
+			fmt.Fprintf(os.Stderr, "%v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	
 	flag.Parse()
 	
 	if *version {
@@ -47,16 +67,34 @@ func main() {
 		os.Exit(1)
 	}
 	fmt.Println("配置加载成功")
+	cfg := config.Get()
 	
 	// 初始化日志
 	fmt.Println("正在初始化日志...")
-	if err := logger.InitLogger(&config.Cfg.Logging); err != nil {
+	if err := logger.InitLogger(&cfg.Logging); err != nil {
 		fmt.Fprintf(os.Stderr, "日志初始化失败: %v\n", err)
 		os.Exit(1)
 	}
 	defer logger.Close()
 	fmt.Println("日志初始化成功")
 	
+	// 初始化链路追踪
+	shutdownTracing, err := tracing.Init(&cfg.Tracing, VERSION)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "链路追踪初始化失败: %v\n", err)
+		os.Exit(1)
+	}
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.LogError("链路追踪关闭失败", err)
+		}
+	}()
+	if cfg.Tracing.Enabled {
+		fmt.Printf("链路追踪已启用 (%s %s)\n", cfg.Tracing.Exporter, cfg.Tracing.Endpoint)
+	}
+	
 	// 打印配置摘要
 	printConfigSummary()
 	
@@ -70,34 +108,71 @@ func main() {
 
 // printConfigSummary 打印配置摘要
 func printConfigSummary() {
+	cfg := config.Get()
+	
 	fmt.Println("\n=== 配置摘要 ===")
-	fmt.Printf("代理端口: %d\n", config.Cfg.Proxy.Port)
-	fmt.Printf("服务数量: %d\n", len(config.Cfg.Services))
+	fmt.Printf("代理端口: %d\n", cfg.Proxy.Port)
+	fmt.Printf("监听地址: %s\n", cfg.Proxy.ListenAddress)
+	if cfg.Access.Enabled {
+		fmt.Printf("访问令牌: 已启用 (%d 个)\n", len(cfg.Access.Tokens))
+	}
+	fmt.Printf("服务数量: %d\n", len(cfg.Services))
 	
 	// 打印服务列表
 	fmt.Println("\n配置的服务:")
-	for _, svc := range config.Cfg.Services {
+	for _, svc := range cfg.Services {
 		fmt.Printf("  - %s (%s): %s [角色: %s]\n", svc.ID, svc.Name, svc.URL, svc.Role)
 	}
 	
 	// 打印难度映射
 	fmt.Println("\n难度等级映射:")
 	for i := 1; i <= 5; i++ {
-		serviceID, ok := config.Cfg.DifficultyMapping[fmt.Sprintf("%d", i)]
-		if ok {
-			service, _ := config.GetServiceByID(serviceID)
+		targets, ok := cfg.DifficultyMapping[fmt.Sprintf("%d", i)]
+		if ok && len(targets) > 0 {
+			service, _ := config.GetServiceByID(cfg, targets[0].ID)
 			if service != nil {
-				fmt.Printf("  - 等级 %d -> %s (%s)\n", i, service.Name, serviceID)
+				fmt.Printf("  - 等级 %d -> %s (%s)\n", i, service.Name, strings.Join(targets.IDs(), ", "))
 			}
 		}
 	}
 	
+	// 打印负载均衡策略
+	fmt.Printf("\n负载均衡策略: %s\n", cfg.LoadBalancing.Strategy)
+	for level, strategy := range cfg.LoadBalancing.Levels {
+		fmt.Printf("  - 等级 %s: %s\n", level, strategy)
+	}
+	
+	// 打印评估策略
+	fmt.Printf("\n评估策略: %s\n", strings.Join(cfg.Evaluator.StrategyNames(), " -> "))
+	
 	// 打印功能开关
 	fmt.Println("\n功能开关:")
-	fmt.Printf("  - 决策者备选: %v\n", config.Cfg.Features.EvaluatorFallback)
-	fmt.Printf("  - 服务自动切换: %v\n", config.Cfg.Features.ServiceAutoSwitch)
-	fmt.Printf("  - 请求日志记录: %v\n", config.Cfg.Features.RequestLogging)
+	fmt.Printf("  - 决策者备选: %v\n", cfg.Features.EvaluatorFallback)
+	fmt.Printf("  - 服务自动切换: %v\n", cfg.Features.ServiceAutoSwitch)
+	fmt.Printf("  - 请求日志记录: %v\n", cfg.Features.RequestLogging)
+	fmt.Printf("  - 配置热加载: %v\n", cfg.Features.ConfigHotReload)
+	
+	// 打印自动切换候选链
+	if cfg.Features.ServiceAutoSwitch {
+		fmt.Println("\n自动切换候选链:")
+		for i := 1; i <= 5; i++ {
+			pool, err := config.GetLevelTargets(cfg, i)
+			if err != nil {
+				continue
+			}
+			chain, _, err := config.GetServiceChain(cfg, i, pool)
+			if err != nil {
+				continue
+			}
+			ids := make([]string, 0, len(chain))
+			for _, svc := range chain {
+				ids = append(ids, svc.ID)
+			}
+			fmt.Printf("  - 等级 %d: %s\n", i, strings.Join(ids, " -> "))
+		}
+	}
 	
 	fmt.Println("\n按 Ctrl+C 停止服务器")
-	fmt.Println("================\n")
+	fmt.Println("================")
+	fmt.Println()
 }
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// tokensUsage tokens 子命令用法
+const tokensUsage = `用法: claude-proxy tokens <add|list|revoke> [参数]
+
+  add     签发令牌: -user alice [-levels 1,2,3] [-services svc-a,svc-b] [-enable]
+  list    列出令牌
+  revoke  吊销令牌: -id <令牌ID> 或 -user <用户名>（吊销该用户的全部令牌）
+
+所有子命令都支持 -config 指定配置文件；修改写回配置文件，代理服务开启热加载时自动生效`
+
+// runTokens tokens 子命令：管理代理访问令牌（配置文件中只保存哈希）
+func runTokens(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("缺少子命令\n\n%s", tokensUsage)
+	}
+
+	fs := flag.NewFlagSet("tokens "+args[0], flag.ExitOnError)
+	configPath := fs.String("config", "./configs/config.yaml", "配置文件路径")
+	user := fs.String("user", "", "用户名")
+	levels := fs.String("levels", "", "允许的难度等级，多个用逗号分隔，默认全部")
+	services := fs.String("services", "", "允许的服务ID，多个用逗号分隔，默认全部")
+	enable := fs.Bool("enable", false, "同时启用访问令牌校验（access.enabled）")
+	id := fs.String("id", "", "令牌ID（list 输出的 ID 列）")
+	fs.Parse(args[1:])
+
+	if _, err := os.Stat(*configPath); err != nil {
+		return fmt.Errorf("配置文件不存在: %s", *configPath)
+	}
+	if err := config.LoadConfig(*configPath); err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "add":
+		return addToken(*user, *levels, *services, *enable)
+	case "list":
+		listTokens(config.Get())
+		return nil
+	case "revoke":
+		return revokeTokens(*id, *user)
+	}
+	return fmt.Errorf("未知的子命令: %s\n\n%s", args[0], tokensUsage)
+}
+
+// addToken 生成随机令牌并保存其哈希，令牌只在此时显示一次
+func addToken(user, levels, services string, enable bool) error {
+	if user == "" {
+		return fmt.Errorf("未指定用户名 (-user)")
+	}
+	entry := models.AccessToken{
+		User:      user,
+		Services:  splitList(services),
+		CreatedAt: time.Now().Format(time.RFC3339),
+	}
+	for _, item := range splitList(levels) {
+		level, err := strconv.Atoi(item)
+		if err != nil {
+			return fmt.Errorf("难度等级无效: %s", item)
+		}
+		entry.Levels = append(entry.Levels, level)
+	}
+
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return fmt.Errorf("生成令牌失败: %v", err)
+	}
+	token := "cce-" + hex.EncodeToString(buf)
+	entry.Hash = models.HashAccessToken(token)
+
+	cfg, err := config.Update(func(cfg *models.Config) error {
+		cfg.Access.Tokens = append(cfg.Access.Tokens, entry)
+		if enable {
+			cfg.Access.Enabled = true
+		}
+		return nil
+	}, "access")
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("已为用户 %s 签发令牌（ID %s）:\n\n  %s\n\n", user, entry.ID(), token)
+	fmt.Println("令牌只显示这一次，请妥善保存。客户端设置 ANTHROPIC_API_KEY（或 ANTHROPIC_AUTH_TOKEN）为该令牌即可")
+	if !cfg.Access.Enabled {
+		fmt.Println("注意: access.enabled 未开启，令牌暂不生效（可加 -enable 参数开启）")
+	}
+	return nil
+}
+
+// listTokens 列出已签发的令牌
+func listTokens(cfg *models.Config) {
+	fmt.Printf("访问令牌校验: %v\n", cfg.Access.Enabled)
+	if len(cfg.Access.Tokens) == 0 {
+		fmt.Println("没有已签发的令牌")
+		return
+	}
+	fmt.Printf("\n%-10s %-16s %-12s %-30s %s\n", "ID", "用户", "等级", "服务", "签发时间")
+	for _, token := range cfg.Access.Tokens {
+		levels := "全部"
+		if len(token.Levels) > 0 {
+			items := make([]string, 0, len(token.Levels))
+			for _, level := range token.Levels {
+				items = append(items, strconv.Itoa(level))
+			}
+			levels = strings.Join(items, ",")
+		}
+		services := "全部"
+		if len(token.Services) > 0 {
+			services = strings.Join(token.Services, ",")
+		}
+		fmt.Printf("%-10s %-16s %-12s %-30s %s\n", token.ID(), token.User, levels, services, token.CreatedAt)
+	}
+}
+
+// revokeTokens 按令牌ID或用户名吊销令牌
+func revokeTokens(id, user string) error {
+	if id == "" && user == "" {
+		return fmt.Errorf("需要指定 -id 或 -user")
+	}
+	revoked := 0
+	_, err := config.Update(func(cfg *models.Config) error {
+		kept := cfg.Access.Tokens[:0]
+		for _, token := range cfg.Access.Tokens {
+			if (id != "" && token.ID() == id) || (user != "" && token.User == user) {
+				revoked++
+				continue
+			}
+			kept = append(kept, token)
+		}
+		if revoked == 0 {
+			return fmt.Errorf("没有匹配的令牌")
+		}
+		cfg.Access.Tokens = kept
+		return nil
+	}, "access")
+	if err != nil {
+		return err
+	}
+	fmt.Printf("已吊销 %d 个令牌\n", revoked)
+	return nil
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/classifier"
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/ledger"
+)
+
+// runTrainClassifier train-classifier 子命令：从标注样本、请求记录或日志训练离线分类器
+func runTrainClassifier(args []string) error {
+	fs := flag.NewFlagSet("train-classifier", flag.ExitOnError)
+	configPath := fs.String("config", "./configs/config.yaml", "配置文件路径（用于确定默认的记录库和模型路径）")
+	examplesPath := fs.String("examples", "", "标注样本文件（JSONL，每行 {\"text\": \"...\", \"level\": 3}）")
+	ledgerPath := fs.String("ledger", "", "请求记录库路径，默认使用配置中的 ledger.path（需先停止代理服务）")
+	noLedger := fs.Bool("no-ledger", false, "不从请求记录库读取样本")
+	logsPath := fs.String("logs", "", "日志文件或目录，多个用逗号分隔")
+	since := fs.Duration("since", 0, "只使用最近这段时间的记录，例如 720h")
+	strategies := fs.String("strategies", "llm", "作为可信标注来源的评估策略，多个用逗号分隔")
+	ngram := fs.Int("ngram", 2, "n-gram 特征的最大长度")
+	holdout := fs.Float64("holdout", 0.1, "留作验证集的样本比例（0 表示不验证）")
+	outPath := fs.String("out", "", "模型输出路径，默认使用配置中的 evaluator.classifier.model_path")
+	exportPath := fs.String("export", "", "将合并后的样本导出为 JSONL，便于人工校对")
+	fs.Parse(args)
+
+	// 配置仅用于提供默认路径，加载失败时要求显式指定
+	cfgLedgerPath, cfgModelPath := "", ""
+	if _, err := os.Stat(*configPath); err == nil && config.LoadConfig(*configPath) == nil {
+		cfg := config.Get()
+		if cfg.Ledger.Enabled {
+			cfgLedgerPath = cfg.Ledger.Path
+		}
+		cfgModelPath = cfg.Evaluator.Classifier.ModelPath
+	}
+	if *ledgerPath == "" && !*noLedger {
+		*ledgerPath = cfgLedgerPath
+	}
+	if *outPath == "" {
+		*outPath = cfgModelPath
+	}
+	if *outPath == "" {
+		return fmt.Errorf("未指定模型输出路径 (-out)")
+	}
+	trusted := splitList(*strategies)
+
+	var examples []classifier.Example
+	if *examplesPath != "" {
+		loaded, err := classifier.ReadExamples(*examplesPath)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("标注样本文件: %d 条\n", len(loaded))
+		examples = append(examples, loaded...)
+	}
+
+	if *ledgerPath != "" {
+		store, err := ledger.OpenReadOnly(*ledgerPath)
+		if err != nil {
+			return err
+		}
+		filter := ledger.Filter{}
+		if *since > 0 {
+			filter.Since = time.Now().Add(-*since)
+		}
+		loaded, err := classifier.ExamplesFromLedger(store, filter, trusted)
+		store.Close()
+		if err != nil {
+			return err
+		}
+		fmt.Printf("请求记录: %d 条\n", len(loaded))
+		examples = append(examples, loaded...)
+	}
+
+	if *logsPath != "" {
+		files, err := logFiles(splitList(*logsPath))
+		if err != nil {
+			return err
+		}
+		loaded, err := classifier.ExamplesFromLogs(files, trusted)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("日志（%d 个文件）: %d 条\n", len(files), len(loaded))
+		examples = append(examples, loaded...)
+	}
+
+	if len(examples) == 0 {
+		return fmt.Errorf("没有找到训练样本，请通过 -examples、-ledger 或 -logs 指定来源")
+	}
+
+	if *exportPath != "" {
+		if err := classifier.WriteExamples(*exportPath, examples); err != nil {
+			return err
+		}
+		fmt.Printf("样本已导出: %s\n", *exportPath)
+	}
+
+	// 按固定间隔留出验证集
+	train, validate := examples, []classifier.Example(nil)
+	if *holdout > 0 && *holdout < 1 && len(examples) >= 10 {
+		step := int(1 / *holdout)
+		train = nil
+		for i, ex := range examples {
+			if i%step == step-1 {
+				validate = append(validate, ex)
+			} else {
+				train = append(train, ex)
+			}
+		}
+	}
+
+	model, err := classifier.Train(train, *ngram)
+	if err != nil {
+		return err
+	}
+
+	counts := model.ClassCounts()
+	levels := make([]int, 0, len(counts))
+	for level := range counts {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+	fmt.Printf("\n训练样本: %d 条，特征数: %d\n", model.Docs, model.Vocab)
+	for _, level := range levels {
+		fmt.Printf("  - 等级 %d: %d 条\n", level, counts[level])
+	}
+
+	if len(validate) > 0 {
+		correct := 0
+		for _, ex := range validate {
+			if level, _ := model.Predict(ex.Text); level == ex.Level {
+				correct++
+			}
+		}
+		fmt.Printf("验证集准确率: %.1f%% (%d/%d)\n", float64(correct)*100/float64(len(validate)), correct, len(validate))
+	}
+
+	if err := model.Save(*outPath); err != nil {
+		return err
+	}
+	fmt.Printf("\n模型已保存: %s\n", *outPath)
+	fmt.Println("将 evaluator.strategy 设为 classifier（或加入 chain）后重新加载配置即可生效")
+	return nil
+}
+
+// logFiles 展开日志路径，目录取其中的 *.log 文件
+func logFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, fmt.Errorf("读取日志路径失败: %v", err)
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.log"))
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// splitList 解析逗号分隔的列表
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,149 @@
+package balancer
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// 负载均衡策略
+const (
+	StrategyFailover       = "failover"        // 按配置顺序，仅失败时切换（默认）
+	StrategyRoundRobin     = "round_robin"     // 轮询
+	StrategyWeightedRandom = "weighted_random" // 按权重随机
+	StrategyLeastInFlight  = "least_inflight"  // 选择进行中请求最少的服务
+)
+
+// IsValidStrategy 判断策略名是否有效（空字符串视为默认策略）
+func IsValidStrategy(strategy string) bool {
+	switch strategy {
+	case "", StrategyFailover, StrategyRoundRobin, StrategyWeightedRandom, StrategyLeastInFlight:
+		return true
+	}
+	return false
+}
+
+// Balancer 难度等级服务池的负载均衡器
+// 只负责决定服务池内的尝试顺序，排在后面的服务仍作为自动切换的备选
+type Balancer struct {
+	mu       sync.Mutex
+	counters map[string]uint64 // 轮询计数，key: 难度等级
+	rand     *rand.Rand
+
+	inflightMu sync.RWMutex
+	inflight   map[string]*int64 // 进行中的请求数，key: 服务ID
+}
+
+// New 创建负载均衡器
+func New() *Balancer {
+	return &Balancer{
+		counters: make(map[string]uint64),
+		rand:     rand.New(rand.NewSource(time.Now().UnixNano())),
+		inflight: make(map[string]*int64),
+	}
+}
+
+// Order 按策略对难度等级的服务池排序，返回新的列表
+func (b *Balancer) Order(level, strategy string, targets models.LevelTargets) models.LevelTargets {
+	ordered := make(models.LevelTargets, len(targets))
+	copy(ordered, targets)
+	if len(ordered) <= 1 {
+		return ordered
+	}
+
+	switch strategy {
+	case StrategyRoundRobin:
+		b.mu.Lock()
+		start := int(b.counters[level] % uint64(len(ordered)))
+		b.counters[level]++
+		b.mu.Unlock()
+		ordered = append(ordered[start:], ordered[:start]...)
+
+	case StrategyWeightedRandom:
+		b.mu.Lock()
+		picked := pickWeighted(b.rand, ordered)
+		b.mu.Unlock()
+		ordered = moveToFront(ordered, picked)
+
+	case StrategyLeastInFlight:
+		counts := b.InFlight()
+		sort.SliceStable(ordered, func(i, j int) bool {
+			return counts[ordered[i].ID] < counts[ordered[j].ID]
+		})
+	}
+
+	return ordered
+}
+
+// Acquire 标记服务开始处理一个请求，返回的函数用于请求结束时释放（可重复调用）
+func (b *Balancer) Acquire(serviceID string) func() {
+	b.inflightMu.Lock()
+	counter, ok := b.inflight[serviceID]
+	if !ok {
+		counter = new(int64)
+		b.inflight[serviceID] = counter
+	}
+	b.inflightMu.Unlock()
+
+	atomic.AddInt64(counter, 1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			atomic.AddInt64(counter, -1)
+		})
+	}
+}
+
+// InFlight 获取各服务进行中的请求数
+func (b *Balancer) InFlight() map[string]int64 {
+	b.inflightMu.RLock()
+	defer b.inflightMu.RUnlock()
+
+	counts := make(map[string]int64, len(b.inflight))
+	for id, counter := range b.inflight {
+		counts[id] = atomic.LoadInt64(counter)
+	}
+	return counts
+}
+
+// pickWeighted 按权重随机选择一个下标，未配置权重的服务按1计算
+func pickWeighted(r *rand.Rand, targets models.LevelTargets) int {
+	total := 0
+	for _, target := range targets {
+		total += weightOf(target)
+	}
+
+	n := r.Intn(total)
+	for i, target := range targets {
+		n -= weightOf(target)
+		if n < 0 {
+			return i
+		}
+	}
+	return 0
+}
+
+// weightOf 获取服务权重（配置解析时已拒绝显式的0，这里的0表示未配置）
+func weightOf(target models.ServiceTarget) int {
+	if target.Weight <= 0 {
+		return 1
+	}
+	return target.Weight
+}
+
+// moveToFront 将指定下标的服务移到列表最前，其余保持原顺序
+func moveToFront(targets models.LevelTargets, index int) models.LevelTargets {
+	if index == 0 {
+		return targets
+	}
+	ordered := make(models.LevelTargets, 0, len(targets))
+	ordered = append(ordered, targets[index])
+	ordered = append(ordered, targets[:index]...)
+	ordered = append(ordered, targets[index+1:]...)
+	return ordered
+}
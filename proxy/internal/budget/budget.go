@@ -0,0 +1,385 @@
+package budget
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/models"
+	bolt "go.etcd.io/bbolt"
+)
+
+// countersBucket 计数器所在的 bucket
+var countersBucket = []byte("counters")
+
+// flushInterval 计数器写入文件的间隔（进程异常退出时最多丢失这段时间的计数）
+const flushInterval = 5 * time.Second
+
+// Counter 一个时间窗口内的计数
+type Counter struct {
+	Requests int64   `json:"requests"`
+	Tokens   int64   `json:"tokens"`
+	Cost     float64 `json:"cost"`
+}
+
+// Decision 预算检查结果
+type Decision struct {
+	// 超出硬上限或限流时的原因，为空表示允许
+	Rejected string
+	// 建议的重试等待时间（到当前窗口结束）
+	RetryAfter time.Duration
+	// 超过费用软上限，需要降级
+	SoftLimited string
+}
+
+// window 计数窗口
+type window struct {
+	prefix string // 键中的窗口标识前缀
+	layout string // 时间格式
+}
+
+var (
+	minuteWindow = window{prefix: "m", layout: "200601021504"}
+	dayWindow    = window{prefix: "d", layout: "20060102"}
+	monthWindow  = window{prefix: "M", layout: "200601"}
+)
+
+// Tracker 按用户、会话累计请求数、token 数和费用，定期持久化到 bbolt 文件
+// 键为 scope/id/窗口，例如 user/alice/d:20250101；只保留当前窗口的计数
+type Tracker struct {
+	mu       sync.Mutex
+	db       *bolt.DB
+	counters map[string]*Counter
+	dirty    map[string]bool
+	stopChan chan struct{}
+	done     chan struct{}
+}
+
+// Open 打开（或创建）计数器文件并加载当前窗口的计数
+func Open(path string) (*Tracker, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建预算目录失败: %v", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开预算计数文件失败: %v", err)
+	}
+
+	t := &Tracker{
+		db:       db,
+		counters: make(map[string]*Counter),
+		dirty:    make(map[string]bool),
+		stopChan: make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	if err := t.load(time.Now()); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	go t.flushLoop()
+	return t, nil
+}
+
+// load 加载当前窗口的计数，删除已过期窗口的计数
+func (t *Tracker) load(now time.Time) error {
+	current := map[string]bool{
+		windowKey(minuteWindow, now): true,
+		windowKey(dayWindow, now):    true,
+		windowKey(monthWindow, now):  true,
+	}
+
+	return t.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := tx.CreateBucketIfNotExists(countersBucket)
+		if err != nil {
+			return fmt.Errorf("初始化预算计数文件失败: %v", err)
+		}
+
+		var expired [][]byte
+		err = bucket.ForEach(func(k, v []byte) error {
+			key := string(k)
+			if !current[key[strings.LastIndex(key, "/")+1:]] {
+				expired = append(expired, append([]byte(nil), k...))
+				return nil
+			}
+			var counter Counter
+			if err := json.Unmarshal(v, &counter); err != nil {
+				logger.LogWarn("忽略无法解析的预算计数", "key", key, "error", err)
+				return nil
+			}
+			t.counters[key] = &counter
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("读取预算计数失败: %v", err)
+		}
+		for _, k := range expired {
+			if err := bucket.Delete(k); err != nil {
+				return fmt.Errorf("清理预算计数失败: %v", err)
+			}
+		}
+		return nil
+	})
+}
+
+// Close 写入未保存的计数并关闭文件
+func (t *Tracker) Close() error {
+	select {
+	case <-t.stopChan:
+		return nil
+	default:
+		close(t.stopChan)
+	}
+	<-t.done
+	if err := t.flush(); err != nil {
+		logger.LogError("保存预算计数失败", err)
+	}
+	return t.db.Close()
+}
+
+// flushLoop 定期写入计数并清理过期窗口
+func (t *Tracker) flushLoop() {
+	defer close(t.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.stopChan:
+			return
+		case now := <-ticker.C:
+			t.prune(now)
+			if err := t.flush(); err != nil {
+				logger.LogError("保存预算计数失败", err)
+			}
+		}
+	}
+}
+
+// flush 写入有变化的计数
+func (t *Tracker) flush() error {
+	t.mu.Lock()
+	if len(t.dirty) == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	updates := make(map[string][]byte, len(t.dirty))
+	for key := range t.dirty {
+		if counter, ok := t.counters[key]; ok {
+			data, err := json.Marshal(counter)
+			if err != nil {
+				t.mu.Unlock()
+				return fmt.Errorf("序列化预算计数失败: %v", err)
+			}
+			updates[key] = data
+		} else {
+			updates[key] = nil
+		}
+	}
+	t.dirty = make(map[string]bool)
+	t.mu.Unlock()
+
+	return t.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(countersBucket)
+		for key, data := range updates {
+			var err error
+			if data == nil {
+				err = bucket.Delete([]byte(key))
+			} else {
+				err = bucket.Put([]byte(key), data)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// prune 删除已过期窗口的内存计数（文件中的计数在下次写入时删除）
+func (t *Tracker) prune(now time.Time) {
+	current := map[string]bool{
+		windowKey(minuteWindow, now): true,
+		windowKey(dayWindow, now):    true,
+		windowKey(monthWindow, now):  true,
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for key := range t.counters {
+		if !current[key[strings.LastIndex(key, "/")+1:]] {
+			delete(t.counters, key)
+			t.dirty[key] = true
+		}
+	}
+}
+
+// Admit 检查用户和会话是否超出限制；允许时计入一次请求（用于每分钟请求数）
+func (t *Tracker) Admit(cfg *models.BudgetsConfig, userID, sessionID string) Decision {
+	now := time.Now()
+	type limitScope struct {
+		name   string
+		key    string
+		limits models.BudgetLimits
+	}
+	scopes := []limitScope{{"用户", userKey(userID), cfg.UserLimits(userID)}}
+	if sessionID != "" {
+		scopes = append(scopes, limitScope{"会话", sessionKey(userID, sessionID), cfg.Session})
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	var decision Decision
+	for _, scope := range scopes {
+		limits := scope.limits
+		minute := t.get(scope.key, minuteWindow, now)
+		day := t.get(scope.key, dayWindow, now)
+		month := t.get(scope.key, monthWindow, now)
+
+		switch {
+		case limits.RPM > 0 && minute.Requests >= limits.RPM:
+			decision.Rejected = fmt.Sprintf("%s请求数超过每分钟 %d 次的限制", scope.name, limits.RPM)
+			decision.RetryAfter = untilNext(minuteWindow, now)
+		case limits.TPM > 0 && minute.Tokens >= limits.TPM:
+			decision.Rejected = fmt.Sprintf("%s token 用量超过每分钟 %d 的限制", scope.name, limits.TPM)
+			decision.RetryAfter = untilNext(minuteWindow, now)
+		case limits.DailyHardCost > 0 && day.Cost >= limits.DailyHardCost:
+			decision.Rejected = fmt.Sprintf("%s今日费用 %.4f 已达到上限 %.4f", scope.name, day.Cost, limits.DailyHardCost)
+			decision.RetryAfter = untilNext(dayWindow, now)
+		case limits.MonthlyHardCost > 0 && month.Cost >= limits.MonthlyHardCost:
+			decision.Rejected = fmt.Sprintf("%s本月费用 %.4f 已达到上限 %.4f", scope.name, month.Cost, limits.MonthlyHardCost)
+			decision.RetryAfter = untilNext(monthWindow, now)
+		}
+		if decision.Rejected != "" {
+			return decision
+		}
+
+		if decision.SoftLimited == "" {
+			if limits.DailySoftCost > 0 && day.Cost >= limits.DailySoftCost {
+				decision.SoftLimited = fmt.Sprintf("%s今日费用 %.4f 超过软上限 %.4f", scope.name, day.Cost, limits.DailySoftCost)
+			} else if limits.MonthlySoftCost > 0 && month.Cost >= limits.MonthlySoftCost {
+				decision.SoftLimited = fmt.Sprintf("%s本月费用 %.4f 超过软上限 %.4f", scope.name, month.Cost, limits.MonthlySoftCost)
+			}
+		}
+	}
+
+	for _, scope := range scopes {
+		for _, w := range []window{minuteWindow, dayWindow, monthWindow} {
+			t.add(scope.key, w, now, Counter{Requests: 1})
+		}
+	}
+	return decision
+}
+
+// Record 计入一次请求的 token 用量和费用
+func (t *Tracker) Record(userID, sessionID string, tokens int64, cost float64) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	keys := []string{userKey(userID)}
+	if sessionID != "" {
+		keys = append(keys, sessionKey(userID, sessionID))
+	}
+	for _, key := range keys {
+		for _, w := range []window{minuteWindow, dayWindow, monthWindow} {
+			t.add(key, w, now, Counter{Tokens: tokens, Cost: cost})
+		}
+	}
+}
+
+// Snapshot 各用户当日和当月的计数（用于 /status）
+func (t *Tracker) Snapshot() map[string]map[string]Counter {
+	now := time.Now()
+	day, month := windowKey(dayWindow, now), windowKey(monthWindow, now)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	users := make(map[string]map[string]Counter)
+	for key, counter := range t.counters {
+		if !strings.HasPrefix(key, "user/") {
+			continue
+		}
+		idx := strings.LastIndex(key, "/")
+		user, w := key[len("user/"):idx], key[idx+1:]
+		var name string
+		switch w {
+		case day:
+			name = "daily"
+		case month:
+			name = "monthly"
+		default:
+			continue
+		}
+		if users[user] == nil {
+			users[user] = make(map[string]Counter)
+		}
+		users[user][name] = *counter
+	}
+	return users
+}
+
+// get 获取窗口计数（不存在时返回零值）
+func (t *Tracker) get(key string, w window, now time.Time) Counter {
+	if counter, ok := t.counters[key+"/"+windowKey(w, now)]; ok {
+		return *counter
+	}
+	return Counter{}
+}
+
+// add 累加窗口计数
+func (t *Tracker) add(key string, w window, now time.Time, delta Counter) {
+	full := key + "/" + windowKey(w, now)
+	counter, ok := t.counters[full]
+	if !ok {
+		counter = &Counter{}
+		t.counters[full] = counter
+	}
+	counter.Requests += delta.Requests
+	counter.Tokens += delta.Tokens
+	counter.Cost += delta.Cost
+	t.dirty[full] = true
+}
+
+// userKey 用户计数的键；用户ID为空时记为 "-"
+func userKey(userID string) string {
+	if userID == "" {
+		userID = "-"
+	}
+	return "user/" + userID
+}
+
+// sessionKey 会话计数的键
+func sessionKey(userID, sessionID string) string {
+	if userID == "" {
+		userID = "-"
+	}
+	return "session/" + userID + "/" + sessionID
+}
+
+// windowKey 窗口标识，例如 d:20250101
+func windowKey(w window, now time.Time) string {
+	return w.prefix + ":" + now.Format(w.layout)
+}
+
+// untilNext 到下一个窗口开始的时间
+func untilNext(w window, now time.Time) time.Duration {
+	var next time.Time
+	switch w {
+	case minuteWindow:
+		next = now.Truncate(time.Minute).Add(time.Minute)
+	case dayWindow:
+		next = time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, now.Location())
+	default:
+		next = time.Date(now.Year(), now.Month()+1, 1, 0, 0, 0, 0, now.Location())
+	}
+	return next.Sub(now)
+}
@@ -0,0 +1,187 @@
+package classifier
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Example 一条带标注的训练样本
+type Example struct {
+	Text  string `json:"text"`
+	Level int    `json:"level"` // 难度等级 1-5
+}
+
+// classStats 单个难度等级的统计
+type classStats struct {
+	Docs   int            `json:"docs"`   // 样本数
+	Tokens int            `json:"tokens"` // 特征总数
+	Counts map[string]int `json:"counts"` // 各特征出现次数
+}
+
+// Model 基于 n-gram 特征的多项式朴素贝叶斯分类器
+type Model struct {
+	NGram     int                 `json:"ngram"`
+	Vocab     int                 `json:"vocab"`
+	Docs      int                 `json:"docs"`
+	Classes   map[int]*classStats `json:"classes"`
+	TrainedAt time.Time           `json:"trained_at"`
+}
+
+// Train 用标注样本训练模型，ngram 为特征的最大长度
+func Train(examples []Example, ngram int) (*Model, error) {
+	if ngram <= 0 {
+		ngram = 2
+	}
+	m := &Model{
+		NGram:     ngram,
+		Classes:   make(map[int]*classStats),
+		TrainedAt: time.Now(),
+	}
+
+	vocab := make(map[string]bool)
+	for _, ex := range examples {
+		if ex.Level < 1 || ex.Level > 5 {
+			continue
+		}
+		features := Features(ex.Text, ngram)
+		if len(features) == 0 {
+			continue
+		}
+		stats, ok := m.Classes[ex.Level]
+		if !ok {
+			stats = &classStats{Counts: make(map[string]int)}
+			m.Classes[ex.Level] = stats
+		}
+		stats.Docs++
+		m.Docs++
+		for _, f := range features {
+			stats.Counts[f]++
+			stats.Tokens++
+			vocab[f] = true
+		}
+	}
+	m.Vocab = len(vocab)
+
+	if m.Docs == 0 {
+		return nil, fmt.Errorf("没有可用的训练样本")
+	}
+	return m, nil
+}
+
+// Predict 预测难度等级，返回等级和该等级的后验概率；无法提取特征时返回 0
+func (m *Model) Predict(text string) (int, float64) {
+	features := Features(text, m.NGram)
+	if len(features) == 0 || m.Docs == 0 {
+		return 0, 0
+	}
+
+	levels := make([]int, 0, len(m.Classes))
+	for level := range m.Classes {
+		levels = append(levels, level)
+	}
+	sort.Ints(levels)
+
+	// 对数似然，拉普拉斯平滑
+	scores := make([]float64, len(levels))
+	best := 0
+	for i, level := range levels {
+		stats := m.Classes[level]
+		score := math.Log(float64(stats.Docs) / float64(m.Docs))
+		denom := math.Log(float64(stats.Tokens + m.Vocab + 1))
+		for _, f := range features {
+			score += math.Log(float64(stats.Counts[f]+1)) - denom
+		}
+		scores[i] = score
+		if score > scores[best] {
+			best = i
+		}
+	}
+
+	// softmax 得到后验概率
+	var sum float64
+	for _, score := range scores {
+		sum += math.Exp(score - scores[best])
+	}
+	return levels[best], 1 / sum
+}
+
+// ClassCounts 返回各等级的样本数
+func (m *Model) ClassCounts() map[int]int {
+	counts := make(map[int]int, len(m.Classes))
+	for level, stats := range m.Classes {
+		counts[level] = stats.Docs
+	}
+	return counts
+}
+
+// Load 从文件加载模型
+func Load(path string) (*Model, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取分类模型失败: %v", err)
+	}
+	var m Model
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, fmt.Errorf("解析分类模型失败: %v", err)
+	}
+	if m.Docs == 0 || len(m.Classes) == 0 {
+		return nil, fmt.Errorf("分类模型为空: %s", path)
+	}
+	return &m, nil
+}
+
+// Save 将模型写入文件（先写临时文件再替换）
+func (m *Model) Save(path string) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("序列化分类模型失败: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("创建模型目录失败: %v", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("写入分类模型失败: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// Features 提取 n-gram 特征
+// 英文/数字按单词切分，中日韩等无空格文字按单字切分，再组合相邻 token 得到 1..n 元特征
+func Features(text string, n int) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			tokens = append(tokens, word.String())
+			word.Reset()
+		}
+	}
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_'):
+			word.WriteRune(r)
+		case unicode.IsLetter(r):
+			flush()
+			tokens = append(tokens, string(r))
+		default:
+			flush()
+		}
+	}
+	flush()
+
+	features := make([]string, 0, len(tokens)*n)
+	for size := 1; size <= n; size++ {
+		for i := 0; i+size <= len(tokens); i++ {
+			features = append(features, strings.Join(tokens[i:i+size], " "))
+		}
+	}
+	return features
+}
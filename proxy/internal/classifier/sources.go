@@ -0,0 +1,124 @@
+package classifier
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethan/claude-proxy/internal/ledger"
+)
+
+// ReadExamples 读取标注样本文件（JSONL，每行 {"text": "...", "level": 3}）
+func ReadExamples(path string) ([]Example, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("打开样本文件失败: %v", err)
+	}
+	defer file.Close()
+
+	var examples []Example
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		var ex Example
+		if err := json.Unmarshal([]byte(line), &ex); err != nil {
+			return nil, fmt.Errorf("%s 第 %d 行格式错误: %v", path, lineNo, err)
+		}
+		examples = append(examples, ex)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("读取样本文件失败: %v", err)
+	}
+	return examples, nil
+}
+
+// WriteExamples 将样本写为 JSONL 文件，便于人工校对后再训练
+func WriteExamples(path string, examples []Example) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("创建样本文件失败: %v", err)
+	}
+	defer file.Close()
+
+	writer := bufio.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+	encoder.SetEscapeHTML(false)
+	for _, ex := range examples {
+		if err := encoder.Encode(ex); err != nil {
+			return fmt.Errorf("写入样本文件失败: %v", err)
+		}
+	}
+	return writer.Flush()
+}
+
+// ExamplesFromLedger 从请求记录中提取样本（用户意图 + 评估得到的难度等级）
+// strategies 为可信的标注来源，未记录策略的旧记录视为 llm
+func ExamplesFromLedger(store *ledger.Store, filter ledger.Filter, strategies []string) ([]Example, error) {
+	records, err := store.Query(filter)
+	if err != nil {
+		return nil, fmt.Errorf("查询请求记录失败: %v", err)
+	}
+
+	var examples []Example
+	for _, rec := range records {
+		if rec.Warmup || rec.Intent == "" || rec.DifficultyLevel == 0 || !trustedLabel(rec.Strategy, strategies) {
+			continue
+		}
+		examples = append(examples, Example{Text: rec.Intent, Level: rec.DifficultyLevel})
+	}
+	return examples, nil
+}
+
+// ExamplesFromLogs 从 JSON 日志文件的 "Evaluator Decision" 记录中提取样本
+func ExamplesFromLogs(paths []string, strategies []string) ([]Example, error) {
+	var examples []Example
+	for _, path := range paths {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("打开日志文件失败: %v", err)
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var entry struct {
+				Msg             string `json:"msg"`
+				Intent          string `json:"intent"`
+				Strategy        string `json:"strategy"`
+				DifficultyLevel int    `json:"difficulty_level"`
+			}
+			if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+				continue
+			}
+			if entry.Msg != "Evaluator Decision" || entry.Intent == "" || !trustedLabel(entry.Strategy, strategies) {
+				continue
+			}
+			examples = append(examples, Example{Text: entry.Intent, Level: entry.DifficultyLevel})
+		}
+		err = scanner.Err()
+		file.Close()
+		if err != nil {
+			return nil, fmt.Errorf("读取日志文件 %s 失败: %v", path, err)
+		}
+	}
+	return examples, nil
+}
+
+// trustedLabel 判断评估策略给出的等级是否可作为训练标注
+func trustedLabel(strategy string, strategies []string) bool {
+	if strategy == "" {
+		strategy = "llm"
+	}
+	for _, s := range strategies {
+		if s == strategy {
+			return true
+		}
+	}
+	return false
+}
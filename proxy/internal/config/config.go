@@ -1,19 +1,52 @@
 package config
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync/atomic"
 	
+	"github.com/ethan/claude-proxy/internal/balancer"
 	"github.com/ethan/claude-proxy/internal/models"
+	"github.com/ethan/claude-proxy/internal/transform"
+	"github.com/mitchellh/mapstructure"
 	"github.com/spf13/viper"
 )
 
 var (
-	// Cfg 全局配置实例
-	Cfg *models.Config
+	// current 当前生效的配置，热加载时整体原子替换
+	current atomic.Pointer[models.Config]
+
+	// configFile 配置文件路径，供热加载重新读取
+	configFile string
 )
 
+// Get 获取当前生效的配置
+// 返回的配置视为只读：热加载会替换为新的实例，而不会修改旧实例
+func Get() *models.Config {
+	return current.Load()
+}
+
+// configContextKey 请求上下文中配置快照的键
+type configContextKey struct{}
+
+// WithContext 将配置快照绑定到请求上下文，保证一个请求从头到尾使用同一份配置
+func WithContext(ctx context.Context, cfg *models.Config) context.Context {
+	return context.WithValue(ctx, configContextKey{}, cfg)
+}
+
+// FromContext 获取请求上下文绑定的配置快照，未绑定时返回当前配置
+func FromContext(ctx context.Context) *models.Config {
+	if cfg, ok := ctx.Value(configContextKey{}).(*models.Config); ok && cfg != nil {
+		return cfg
+	}
+	return Get()
+}
+
 // LoadConfig 加载配置文件
 func LoadConfig(configPath string) error {
 	if configPath == "" {
@@ -21,89 +54,190 @@ func LoadConfig(configPath string) error {
 		configPath = "./configs/config.yaml"
 	}
 	
+	cfg, usedFile, err := load(configPath)
+	if err != nil {
+		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
+			// 配置文件不存在，创建默认配置
+			return createDefaultConfig(configPath)
+		}
+		return err
+	}
+	
+	configFile = usedFile
+	rememberFileSecrets(cfg)
+	current.Store(cfg)
+	return nil
+}
+
+// load 读取、解析并验证配置文件，返回新的配置实例和实际使用的文件路径
+func load(configPath string) (*models.Config, string, error) {
+	v := viper.New()
+	
 	// 获取配置文件的目录和文件名
 	dir := filepath.Dir(configPath)
 	filename := filepath.Base(configPath)
 	ext := filepath.Ext(filename)
 	name := filename[:len(filename)-len(ext)]
 	
-	viper.AddConfigPath(dir)
-	viper.SetConfigName(name)
-	viper.SetConfigType("yaml")
+	v.AddConfigPath(dir)
+	v.SetConfigName(name)
+	v.SetConfigType("yaml")
 	
 	// 设置环境变量前缀
-	viper.SetEnvPrefix("CLAUDE_PROXY")
-	viper.AutomaticEnv()
+	v.SetEnvPrefix("CLAUDE_PROXY")
+	v.AutomaticEnv()
 	
 	// 设置默认值
-	setDefaults()
+	setDefaults(v)
 	
 	// 读取配置文件
-	if err := viper.ReadInConfig(); err != nil {
+	if err := v.ReadInConfig(); err != nil {
 		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			// 配置文件不存在，创建默认配置
-			return createDefaultConfig(configPath)
+			return nil, "", err
 		}
-		return fmt.Errorf("读取配置文件失败: %v", err)
+		return nil, "", fmt.Errorf("读取配置文件失败: %v", err)
 	}
 	
 	// 解析配置到结构体
-	Cfg = &models.Config{}
-	if err := viper.Unmarshal(Cfg); err != nil {
-		return fmt.Errorf("解析配置失败: %v", err)
+	cfg := &models.Config{}
+	decodeHook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		levelTargetsHook(),
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+	))
+	if err := v.Unmarshal(cfg, decodeHook); err != nil {
+		return nil, "", fmt.Errorf("解析配置失败: %v", err)
 	}
 
 	// 为服务设置默认值
 	// SupportsThinking 默认为true（支持thinking模式）
-	for i := range Cfg.Services {
+	for i := range cfg.Services {
 		// 如果配置中未显式设置，则默认支持thinking
 		// 由于viper.Unmarshal后bool的零值是false，我们需要检查是否被显式设置
 		// 这里采用简单方法：如果是evaluator或官方API，默认支持
-		if Cfg.Services[i].Role == "evaluator" {
+		if cfg.Services[i].Role == "evaluator" {
 			// Evaluator服务默认支持thinking
-			if !viper.IsSet(fmt.Sprintf("services.%d.supports_thinking", i)) {
-				Cfg.Services[i].SupportsThinking = true
+			if !v.IsSet(fmt.Sprintf("services.%d.supports_thinking", i)) {
+				cfg.Services[i].SupportsThinking = true
 			}
-		} else if Cfg.Services[i].Role == "executor" {
+		} else if cfg.Services[i].Role == "executor" {
 			// Executor服务需要显式配置，默认支持thinking以保持兼容性
-			if !viper.IsSet(fmt.Sprintf("services.%d.supports_thinking", i)) {
-				Cfg.Services[i].SupportsThinking = true
+			if !v.IsSet(fmt.Sprintf("services.%d.supports_thinking", i)) {
+				cfg.Services[i].SupportsThinking = true
 			}
 		}
 	}
 
+	// 解析密钥引用（env:、file:、cmd:）
+	if err := resolveSecrets(cfg, nil); err != nil {
+		return nil, "", err
+	}
+
 	// 验证配置
-	if err := validateConfig(Cfg); err != nil {
-		return fmt.Errorf("配置验证失败: %v", err)
+	if err := validateConfig(cfg); err != nil {
+		return nil, "", fmt.Errorf("配置验证失败: %v", err)
 	}
 
-	return nil
+	return cfg, v.ConfigFileUsed(), nil
 }
 
 // setDefaults 设置默认配置值
-func setDefaults() {
+func setDefaults(v *viper.Viper) {
 	// 代理配置
-	viper.SetDefault("proxy.port", 27015)
-	viper.SetDefault("proxy.read_timeout", 1800)      // 30分钟
-	viper.SetDefault("proxy.write_timeout", 1800)     // 30分钟
-	viper.SetDefault("proxy.idle_timeout", 300)       // 5分钟
-	viper.SetDefault("proxy.request_timeout", 1800)   // 30分钟
-	viper.SetDefault("proxy.evaluator_timeout", 30)   // 30秒
+	v.SetDefault("proxy.port", 27015)
+	v.SetDefault("proxy.listen_address", "127.0.0.1")
+	v.SetDefault("proxy.read_timeout", 1800)      // 30分钟
+	v.SetDefault("proxy.write_timeout", 1800)     // 30分钟
+	v.SetDefault("proxy.idle_timeout", 300)       // 5分钟
+	v.SetDefault("proxy.request_timeout", 1800)   // 30分钟
+	v.SetDefault("proxy.evaluator_timeout", 30)   // 30秒
+
+	// 负载均衡
+	v.SetDefault("load_balancing.strategy", balancer.StrategyFailover)
+
+	// 熔断
+	v.SetDefault("circuit_breaker.enabled", true)
+	v.SetDefault("circuit_breaker.failure_threshold", 3)
+	v.SetDefault("circuit_breaker.slow_call_threshold_ms", 0)
+	v.SetDefault("circuit_breaker.open_seconds", 30)
+	v.SetDefault("circuit_breaker.half_open_max_requests", 1)
+
+	// 主动健康检查
+	v.SetDefault("health_check.enabled", false)
+	v.SetDefault("health_check.interval_seconds", 60)
+	v.SetDefault("health_check.timeout_seconds", 10)
+	v.SetDefault("health_check.unhealthy_threshold", 2)
+	v.SetDefault("health_check.history_size", 20)
+	v.SetDefault("health_check.probe_model", "claude-3-haiku-20240307")
+
+	// 费用统计
+	v.SetDefault("cost.currency", "USD")
+
+	// 请求记录
+	v.SetDefault("ledger.enabled", false)
+	v.SetDefault("ledger.path", "./data/ledger.db")
+	v.SetDefault("ledger.retention_days", 30)
+	
+	// 预算与限流
+	v.SetDefault("budgets.enabled", false)
+	v.SetDefault("budgets.state_path", "./data/budgets.db")
+	v.SetDefault("budgets.downgrade_level", 1)
+
+	// 链路追踪
+	v.SetDefault("tracing.enabled", false)
+	v.SetDefault("tracing.exporter", "otlp")
+	v.SetDefault("tracing.endpoint", "localhost:4318")
+	v.SetDefault("tracing.insecure", true)
+	v.SetDefault("tracing.service_name", "claude-proxy")
+	v.SetDefault("tracing.sample_ratio", 1.0)
+
+	// 管理接口
+	v.SetDefault("admin.enabled", false)
+	v.SetDefault("admin.write_back", true)
+	
+	// 访问令牌
+	v.SetDefault("access.enabled", false)
 
 	// 功能开关
-	viper.SetDefault("features.evaluator_fallback", false)
-	viper.SetDefault("features.service_auto_switch", false)
-	viper.SetDefault("features.request_logging", true)
+	v.SetDefault("features.evaluator_fallback", false)
+	v.SetDefault("features.service_auto_switch", false)
+	v.SetDefault("features.request_logging", true)
+	v.SetDefault("features.config_hot_reload", true)
+
+	// 会话粘滞路由默认配置
+	v.SetDefault("sticky_routing.enabled", false)
+	v.SetDefault("sticky_routing.max_turns", 20)
+	v.SetDefault("sticky_routing.idle_seconds", 1800)
+
+	// 失败升级默认配置
+	v.SetDefault("escalation.enabled", false)
+	v.SetDefault("escalation.max_from_level", 2)
+	v.SetDefault("escalation.max_steps", 1)
+	v.SetDefault("escalation.bias_turns", 3)
+
+	// 手动路由覆盖默认配置
+	v.SetDefault("overrides.enabled", false)
+	v.SetDefault("overrides.allow_service", true)
 
 	// 日志配置
-	viper.SetDefault("logging.level", "info")
-	viper.SetDefault("logging.output_path", "./logs")
+	v.SetDefault("logging.level", "info")
+	v.SetDefault("logging.output_path", "./logs")
 
 	// 决策者默认配置
-	viper.SetDefault("evaluator.include_history", true)
-	viper.SetDefault("evaluator.max_history_rounds", 3)
-	viper.SetDefault("evaluator.model", "claude-3-haiku-20240307")
-	viper.SetDefault("evaluator.max_tokens", 100)
+	v.SetDefault("evaluator.include_history", true)
+	v.SetDefault("evaluator.max_history_rounds", 3)
+	v.SetDefault("evaluator.model", "claude-3-haiku-20240307")
+	v.SetDefault("evaluator.max_tokens", 100)
+	v.SetDefault("evaluator.strategy", "llm")
+	v.SetDefault("evaluator.rules.min_score", 1)
+	v.SetDefault("evaluator.heuristic.message_thresholds", []int{4, 16, 40, 80})
+	v.SetDefault("evaluator.heuristic.token_thresholds", []int{2000, 16000, 64000, 150000})
+	v.SetDefault("evaluator.classifier.model_path", "./data/classifier.json")
+	v.SetDefault("evaluator.classifier.min_confidence", 0.6)
+	v.SetDefault("evaluator.cache.enabled", true)
+	v.SetDefault("evaluator.cache.max_entries", 10000)
+	v.SetDefault("evaluator.cache.ttl_seconds", 600)
+	v.SetDefault("evaluator.cache.reuse_on_tool_result", false)
 
 	// 决策者默认Prompt模板
 	defaultPrompt := `你是一个任务复杂度评估专家。请分析以下 Claude API 请求中【当前这一步具体任务】的复杂度，并返回 JSON 格式的结果。
@@ -131,7 +265,7 @@ func setDefaults() {
 }
 
 你的评估：`
-	viper.SetDefault("evaluator.prompt_template", defaultPrompt)
+	v.SetDefault("evaluator.prompt_template", defaultPrompt)
 }
 
 // validateConfig 验证配置的有效性
@@ -156,34 +290,391 @@ func validateConfig(cfg *models.Config) error {
 		if svc.URL == "" {
 			return fmt.Errorf("服务 %s 的URL不能为空", svc.ID)
 		}
-		if svc.APIKey == "" {
+		switch svc.AuthScheme {
+		case "", models.AuthSchemeBearer, models.AuthSchemeAPIKey:
+		case models.AuthSchemeHeader:
+			if strings.TrimSpace(svc.AuthHeader) == "" {
+				return fmt.Errorf("服务 %s 的 auth_scheme 为 header 时必须配置 auth_header", svc.ID)
+			}
+		case models.AuthSchemePassthrough:
+			if svc.Role == "evaluator" {
+				return fmt.Errorf("服务 %s: 决策者服务不支持 passthrough 认证", svc.ID)
+			}
+		default:
+			return fmt.Errorf("服务 %s 的 auth_scheme 无效: %s（可选 bearer、x-api-key、header、passthrough）", svc.ID, svc.AuthScheme)
+		}
+		if svc.APIKey == "" && svc.AuthScheme != models.AuthSchemePassthrough {
 			return fmt.Errorf("服务 %s 的API Key不能为空", svc.ID)
 		}
+		for from, to := range svc.ModelMap {
+			if strings.TrimSpace(to) == "" {
+				return fmt.Errorf("服务 %s 的 model_map[%s] 不能为空", svc.ID, from)
+			}
+		}
+		if err := transform.Validate(svc.Transforms); err != nil {
+			return fmt.Errorf("服务 %s 的 %v", svc.ID, err)
+		}
+		if svc.Protocol != "" && svc.Protocol != models.ProtocolAnthropic && svc.Protocol != models.ProtocolOpenAI {
+			return fmt.Errorf("服务 %s 的 protocol 无效: %s（可选 anthropic、openai）", svc.ID, svc.Protocol)
+		}
+		if svc.Protocol == models.ProtocolOpenAI && svc.Role == "evaluator" {
+			return fmt.Errorf("服务 %s: 决策者服务暂不支持 openai 协议", svc.ID)
+		}
 		
 		if svc.Role == "evaluator" {
 			hasEvaluator = true
 		}
 	}
 	
-	if !hasEvaluator {
+	if !hasEvaluator && cfg.Evaluator.UsesStrategy("llm") {
 		return fmt.Errorf("至少需要配置一个决策者服务 (role=evaluator)")
 	}
 	
+	// 检查评估策略
+	if err := validateEvaluator(&cfg.Evaluator); err != nil {
+		return err
+	}
+	
 	// 检查难度映射
 	if len(cfg.DifficultyMapping) == 0 {
 		return fmt.Errorf("难度映射配置不能为空")
 	}
 	
-	// 检查难度映射中的服务ID是否存在
-	for level, serviceID := range cfg.DifficultyMapping {
-		if !serviceIDs[serviceID] {
-			return fmt.Errorf("难度等级 %s 映射的服务ID %s 不存在", level, serviceID)
+	// 检查难度映射中的每个服务ID是否存在
+	for level, targets := range cfg.DifficultyMapping {
+		if len(targets) == 0 {
+			return fmt.Errorf("难度等级 %s 未配置任何服务", level)
+		}
+		for _, target := range targets {
+			if target.ID == "" {
+				return fmt.Errorf("难度等级 %s 存在空的服务ID", level)
+			}
+			if !serviceIDs[target.ID] {
+				return fmt.Errorf("难度等级 %s 映射的服务ID %s 不存在", level, target.ID)
+			}
+			if target.Weight < 0 {
+				return fmt.Errorf("难度等级 %s 中服务 %s 的权重不能为负数", level, target.ID)
+			}
+		}
+	}
+	
+	// 检查负载均衡策略
+	if !balancer.IsValidStrategy(cfg.LoadBalancing.Strategy) {
+		return fmt.Errorf("不支持的负载均衡策略: %s", cfg.LoadBalancing.Strategy)
+	}
+	for level, strategy := range cfg.LoadBalancing.Levels {
+		if !balancer.IsValidStrategy(strategy) {
+			return fmt.Errorf("难度等级 %s 的负载均衡策略不支持: %s", level, strategy)
+		}
+	}
+	
+	// 检查熔断配置
+	if cfg.CircuitBreaker.Enabled {
+		if cfg.CircuitBreaker.FailureThreshold <= 0 {
+			return fmt.Errorf("circuit_breaker.failure_threshold 必须大于0")
+		}
+		if cfg.CircuitBreaker.HalfOpenMaxRequests <= 0 {
+			return fmt.Errorf("circuit_breaker.half_open_max_requests 必须大于0")
+		}
+	}
+	
+	// 检查健康探测配置
+	if cfg.HealthCheck.Enabled {
+		if cfg.HealthCheck.IntervalSeconds <= 0 || cfg.HealthCheck.TimeoutSeconds <= 0 {
+			return fmt.Errorf("health_check 的探测间隔和超时必须大于0")
+		}
+		if cfg.HealthCheck.UnhealthyThreshold <= 0 || cfg.HealthCheck.HistorySize <= 0 {
+			return fmt.Errorf("health_check 的 unhealthy_threshold 和 history_size 必须大于0")
+		}
+	}
+	
+	// 检查会话粘滞路由配置
+	if cfg.StickyRouting.Enabled {
+		if cfg.StickyRouting.MaxTurns < 0 {
+			return fmt.Errorf("sticky_routing.max_turns 不能小于0")
+		}
+		if cfg.StickyRouting.IdleSeconds <= 0 {
+			return fmt.Errorf("sticky_routing.idle_seconds 必须大于0")
+		}
+	}
+	
+	// 检查失败升级配置
+	if cfg.Escalation.Enabled {
+		if cfg.Escalation.MaxFromLevel < 1 || cfg.Escalation.MaxFromLevel > 4 {
+			return fmt.Errorf("escalation.max_from_level 必须在 1 到 4 之间")
+		}
+		if cfg.Escalation.MaxSteps <= 0 {
+			return fmt.Errorf("escalation.max_steps 必须大于0")
+		}
+		if cfg.Escalation.BiasTurns < 0 {
+			return fmt.Errorf("escalation.bias_turns 不能小于0")
+		}
+	}
+	
+	// 检查手动路由覆盖配置
+	if cfg.Overrides.Enabled {
+		if len(cfg.Overrides.AllowedUsers) == 0 {
+			return fmt.Errorf("启用 overrides 时必须配置 overrides.allowed_users（\"*\" 表示所有用户）")
+		}
+		for i, user := range cfg.Overrides.AllowedUsers {
+			if strings.TrimSpace(user) == "" {
+				return fmt.Errorf("overrides.allowed_users[%d] 不能为空", i)
+			}
+		}
+	}
+	
+	// 检查模型路由规则
+	for i, rule := range cfg.ModelRouting.Rules {
+		if rule.Pattern == "" {
+			return fmt.Errorf("model_routing.rules[%d] 必须配置 pattern", i)
+		}
+		if _, err := regexp.Compile("(?i)" + rule.Pattern); err != nil {
+			return fmt.Errorf("model_routing.rules[%d] 的 pattern 无效: %v", i, err)
+		}
+		if rule.Level < 1 || rule.Level > 5 {
+			return fmt.Errorf("model_routing.rules[%d] 的 level 必须在 1 到 5 之间", i)
+		}
+	}
+	
+	// 检查请求记录配置
+	if cfg.Ledger.Enabled {
+		if cfg.Ledger.Path == "" {
+			return fmt.Errorf("启用 ledger 时必须配置 ledger.path")
+		}
+		if cfg.Ledger.RetentionDays < 0 {
+			return fmt.Errorf("ledger.retention_days 不能小于0")
+		}
+	}
+	
+	// 检查预算配置
+	if err := validateBudgets(&cfg.Budgets); err != nil {
+		return err
+	}
+	
+	// 检查管理接口配置
+	if cfg.Admin.Enabled && cfg.Admin.Token == "" {
+		return fmt.Errorf("启用 admin 管理接口时必须配置 admin.token")
+	}
+	
+	// 检查跨域来源（不接受 *，任意网页都能以用户身份调用代理）
+	for _, origin := range cfg.Proxy.CORSAllowedOrigins {
+		if origin == "*" || !strings.Contains(origin, "://") {
+			return fmt.Errorf("proxy.cors_allowed_origins 必须是完整的来源（如 https://example.com），不支持 *: %s", origin)
+		}
+	}
+	
+	// 检查访问令牌配置
+	if err := validateAccess(cfg); err != nil {
+		return err
+	}
+	
+	// 检查链路追踪配置
+	if cfg.Tracing.Enabled {
+		if cfg.Tracing.Exporter != "otlp" && cfg.Tracing.Exporter != "stdout" {
+			return fmt.Errorf("tracing.exporter 只支持 otlp 或 stdout: %s", cfg.Tracing.Exporter)
+		}
+		if cfg.Tracing.Exporter == "otlp" && cfg.Tracing.Endpoint == "" {
+			return fmt.Errorf("tracing.exporter 为 otlp 时必须配置 tracing.endpoint")
+		}
+		if cfg.Tracing.SampleRatio < 0 || cfg.Tracing.SampleRatio > 1 {
+			return fmt.Errorf("tracing.sample_ratio 必须在 0 到 1 之间")
 		}
 	}
 	
 	return nil
 }
 
+// validateEvaluator 检查评估策略配置
+func validateEvaluator(cfg *models.EvaluatorConfig) error {
+	if cfg.Strategy == "chain" && len(cfg.Chain) == 0 {
+		return fmt.Errorf("evaluator.strategy 为 chain 时必须配置 evaluator.chain")
+	}
+	for _, strategy := range cfg.StrategyNames() {
+		switch strategy {
+		case "llm", "rules", "heuristic", "classifier", "fixed":
+		default:
+			return fmt.Errorf("不支持的评估策略: %s", strategy)
+		}
+	}
+	
+	if cfg.UsesStrategy("fixed") && (cfg.FixedLevel < 1 || cfg.FixedLevel > 5) {
+		return fmt.Errorf("evaluator.fixed_level 必须在 1 到 5 之间")
+	}
+	
+	for i, rule := range cfg.Rules.Rules {
+		if rule.Level < 1 || rule.Level > 5 {
+			return fmt.Errorf("evaluator.rules 第 %d 条规则的等级必须在 1 到 5 之间", i+1)
+		}
+		if len(rule.Keywords) == 0 && rule.Pattern == "" {
+			return fmt.Errorf("evaluator.rules 第 %d 条规则未配置 keywords 或 pattern", i+1)
+		}
+		if rule.Pattern != "" {
+			if _, err := regexp.Compile(rule.Pattern); err != nil {
+				return fmt.Errorf("evaluator.rules 第 %d 条规则的正则无效: %v", i+1, err)
+			}
+		}
+		if rule.Weight < 0 {
+			return fmt.Errorf("evaluator.rules 第 %d 条规则的权重不能为负数", i+1)
+		}
+	}
+	if cfg.UsesStrategy("rules") && len(cfg.Rules.Rules) == 0 {
+		return fmt.Errorf("使用 rules 评估策略时必须配置 evaluator.rules.rules")
+	}
+	
+	if cfg.UsesStrategy("classifier") {
+		if cfg.Classifier.ModelPath == "" {
+			return fmt.Errorf("使用 classifier 评估策略时必须配置 evaluator.classifier.model_path")
+		}
+		if cfg.Classifier.MinConfidence < 0 || cfg.Classifier.MinConfidence > 1 {
+			return fmt.Errorf("evaluator.classifier.min_confidence 必须在 0 到 1 之间")
+		}
+	}
+	
+	if cfg.Cache.Enabled && (cfg.Cache.TTLSeconds <= 0 || cfg.Cache.MaxEntries <= 0) {
+		return fmt.Errorf("evaluator.cache 的 ttl_seconds 和 max_entries 必须大于0")
+	}
+	
+	heuristic := cfg.Heuristic
+	for name, thresholds := range map[string][]int{
+		"message_thresholds": heuristic.MessageThresholds,
+		"tool_thresholds":    heuristic.ToolThresholds,
+		"token_thresholds":   heuristic.TokenThresholds,
+	} {
+		if len(thresholds) > 4 {
+			return fmt.Errorf("evaluator.heuristic.%s 最多配置4个阈值", name)
+		}
+		for i := 1; i < len(thresholds); i++ {
+			if thresholds[i] <= thresholds[i-1] {
+				return fmt.Errorf("evaluator.heuristic.%s 必须严格递增", name)
+			}
+		}
+	}
+	for _, level := range heuristic.DecideLevels {
+		if level < 1 || level > 5 {
+			return fmt.Errorf("evaluator.heuristic.decide_levels 中的等级必须在 1 到 5 之间")
+		}
+	}
+	
+	return nil
+}
+
+// validateBudgets 检查预算与限流配置
+func validateBudgets(cfg *models.BudgetsConfig) error {
+	if cfg.Enabled && cfg.StatePath == "" {
+		return fmt.Errorf("启用 budgets 时必须配置 budgets.state_path")
+	}
+	if cfg.DowngradeLevel < 1 || cfg.DowngradeLevel > 5 {
+		return fmt.Errorf("budgets.downgrade_level 必须在 1 到 5 之间")
+	}
+	
+	check := func(name string, limits models.BudgetLimits) error {
+		if limits.RPM < 0 || limits.TPM < 0 || limits.DailySoftCost < 0 || limits.DailyHardCost < 0 ||
+			limits.MonthlySoftCost < 0 || limits.MonthlyHardCost < 0 {
+			return fmt.Errorf("%s 的限制不能为负数", name)
+		}
+		if limits.DailyHardCost > 0 && limits.DailySoftCost > limits.DailyHardCost {
+			return fmt.Errorf("%s 的 daily_soft_cost 不能大于 daily_hard_cost", name)
+		}
+		if limits.MonthlyHardCost > 0 && limits.MonthlySoftCost > limits.MonthlyHardCost {
+			return fmt.Errorf("%s 的 monthly_soft_cost 不能大于 monthly_hard_cost", name)
+		}
+		return nil
+	}
+	if err := check("budgets.user", cfg.User); err != nil {
+		return err
+	}
+	if err := check("budgets.session", cfg.Session); err != nil {
+		return err
+	}
+	for i, u := range cfg.Users {
+		if strings.TrimSpace(u.User) == "" {
+			return fmt.Errorf("budgets.users 第 %d 项未配置 user", i+1)
+		}
+		if err := check("budgets.users."+u.User, u.BudgetLimits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// accessHashPattern 访问令牌哈希格式
+var accessHashPattern = regexp.MustCompile(`^sha256:[0-9a-fA-F]{64}$`)
+
+// validateAccess 检查访问令牌配置
+func validateAccess(cfg *models.Config) error {
+	if cfg.Access.Enabled && len(cfg.Access.Tokens) == 0 {
+		return fmt.Errorf("启用 access 时至少需要一个令牌（使用 tokens add 子命令签发）")
+	}
+	for i, token := range cfg.Access.Tokens {
+		if strings.TrimSpace(token.User) == "" {
+			return fmt.Errorf("access.tokens 第 %d 个令牌未配置 user", i+1)
+		}
+		if !accessHashPattern.MatchString(token.Hash) {
+			return fmt.Errorf("access.tokens 第 %d 个令牌的 hash 格式无效（应为 sha256:<hex>）", i+1)
+		}
+		for _, level := range token.Levels {
+			if level < 1 || level > 5 {
+				return fmt.Errorf("用户 %s 的令牌允许的等级必须在 1 到 5 之间", token.User)
+			}
+		}
+		for _, id := range token.Services {
+			if _, err := GetServiceByID(cfg, id); err != nil {
+				return fmt.Errorf("用户 %s 的令牌允许的服务不存在: %s", token.User, id)
+			}
+		}
+	}
+	return nil
+}
+
+// levelTargetsHook 解析难度映射的三种写法
+// "1": "svc-a"
+// "1": ["svc-a", "svc-b"]
+// "1": [{id: "svc-a", weight: 3}, {id: "svc-b", weight: 1}]
+func levelTargetsHook() mapstructure.DecodeHookFuncType {
+	return func(from reflect.Type, to reflect.Type, data interface{}) (interface{}, error) {
+		if to != reflect.TypeOf(models.LevelTargets{}) {
+			return data, nil
+		}
+
+		switch v := data.(type) {
+		case string:
+			return []map[string]interface{}{{"id": v}}, nil
+		case []interface{}:
+			items := make([]interface{}, 0, len(v))
+			for _, item := range v {
+				if id, ok := item.(string); ok {
+					items = append(items, map[string]interface{}{"id": id})
+					continue
+				}
+				// 未配置的权重解析后同样为0，只能在这里区分显式配置的0
+				if fields, ok := item.(map[string]interface{}); ok {
+					if weight, ok := fields["weight"]; ok && isZeroNumber(weight) {
+						return nil, models.ZeroWeightError(fields["id"])
+					}
+				}
+				items = append(items, item)
+			}
+			return items, nil
+		}
+
+		return data, nil
+	}
+}
+
+// isZeroNumber 判断 YAML 解析出的值是否为数字0
+func isZeroNumber(value interface{}) bool {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return v.Int() == 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return v.Uint() == 0
+	case reflect.Float32, reflect.Float64:
+		return v.Float() == 0
+	}
+	return false
+}
+
 // createDefaultConfig 创建默认配置文件
 func createDefaultConfig(configPath string) error {
 	defaultConfig := `# Claude 智能代理服务配置
@@ -221,12 +712,13 @@ services:
     supports_thinking: true
 
 # 难度等级映射 (1-5)
+# 每个等级可写单个服务ID，或按优先顺序排列的服务ID列表
 difficulty_mapping:
   "1": "simple-service"
   "2": "simple-service"
   "3": "medium-service"
   "4": "complex-service"
-  "5": "complex-service"
+  "5": ["complex-service", "medium-service"]
 
 # 决策者配置
 evaluator:
@@ -287,12 +779,12 @@ logging:
 }
 
 // GetServiceByID 根据ID获取服务配置
-func GetServiceByID(id string) (*models.Service, error) {
-	if Cfg == nil {
+func GetServiceByID(cfg *models.Config, id string) (*models.Service, error) {
+	if cfg == nil {
 		return nil, fmt.Errorf("配置未加载")
 	}
 	
-	for _, svc := range Cfg.Services {
+	for _, svc := range cfg.Services {
 		if svc.ID == id {
 			return &svc, nil
 		}
@@ -302,12 +794,12 @@ func GetServiceByID(id string) (*models.Service, error) {
 }
 
 // GetEvaluatorService 获取决策者服务
-func GetEvaluatorService() (*models.Service, error) {
-	if Cfg == nil {
+func GetEvaluatorService(cfg *models.Config) (*models.Service, error) {
+	if cfg == nil {
 		return nil, fmt.Errorf("配置未加载")
 	}
 	
-	for _, svc := range Cfg.Services {
+	for _, svc := range cfg.Services {
 		if svc.Role == "evaluator" {
 			return &svc, nil
 		}
@@ -318,15 +810,15 @@ func GetEvaluatorService() (*models.Service, error) {
 
 // GetAllExecutorServices 获取所有执行者服务
 // 返回所有 role="executor" 的服务列表，用于广播式 Warmup 预热
-func GetAllExecutorServices() ([]*models.Service, error) {
-	if Cfg == nil {
+func GetAllExecutorServices(cfg *models.Config) ([]*models.Service, error) {
+	if cfg == nil {
 		return nil, fmt.Errorf("配置未加载")
 	}
 
 	var executors []*models.Service
-	for i := range Cfg.Services {
-		if Cfg.Services[i].Role == "executor" {
-			executors = append(executors, &Cfg.Services[i])
+	for i := range cfg.Services {
+		if cfg.Services[i].Role == "executor" {
+			executors = append(executors, &cfg.Services[i])
 		}
 	}
 
@@ -336,3 +828,67 @@ func GetAllExecutorServices() ([]*models.Service, error) {
 
 	return executors, nil
 }
+
+// GetLevelTargets 获取指定难度等级映射的服务池
+func GetLevelTargets(cfg *models.Config, level int) (models.LevelTargets, error) {
+	if cfg == nil {
+		return nil, fmt.Errorf("配置未加载")
+	}
+
+	targets, ok := cfg.DifficultyMapping[fmt.Sprintf("%d", level)]
+	if !ok || len(targets) == 0 {
+		return nil, fmt.Errorf("未配置难度等级 %d 的服务映射", level)
+	}
+
+	return targets, nil
+}
+
+// GetServiceChain 获取指定难度等级的候选服务链
+// 依次为该等级的服务池（按 pool 给定的顺序，通常已经过负载均衡排序）、
+// 更高等级、更低等级映射的服务（去重），用于目标服务失败时按顺序自动切换
+// 同时返回每个服务在链中对应的难度等级（服务映射在多个等级时取首次出现的等级）
+func GetServiceChain(cfg *models.Config, level int, pool models.LevelTargets) ([]*models.Service, map[string]int, error) {
+	if cfg == nil {
+		return nil, nil, fmt.Errorf("配置未加载")
+	}
+
+	// 候选等级顺序：当前等级 -> 更高等级（升序） -> 更低等级（降序）
+	type entry struct {
+		id    string
+		level int
+	}
+	var entries []entry
+	add := func(ids []string, l int) {
+		for _, id := range ids {
+			entries = append(entries, entry{id, l})
+		}
+	}
+	add(pool.IDs(), level)
+	for l := level + 1; l <= 5; l++ {
+		add(cfg.DifficultyMapping[fmt.Sprintf("%d", l)].IDs(), l)
+	}
+	for l := level - 1; l >= 1; l-- {
+		add(cfg.DifficultyMapping[fmt.Sprintf("%d", l)].IDs(), l)
+	}
+
+	var chain []*models.Service
+	levels := make(map[string]int)
+	for _, e := range entries {
+		if _, seen := levels[e.id]; seen {
+			continue
+		}
+		levels[e.id] = e.level
+
+		svc, err := GetServiceByID(cfg, e.id)
+		if err != nil {
+			return nil, nil, err
+		}
+		chain = append(chain, svc)
+	}
+
+	if len(chain) == 0 {
+		return nil, nil, fmt.Errorf("未配置难度等级 %d 的服务映射", level)
+	}
+
+	return chain, levels, nil
+}
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/models"
+	"github.com/fsnotify/fsnotify"
+)
+
+// reloadDebounce 文件变化后等待的时间，合并编辑器保存时的多次写入
+const reloadDebounce = 500 * time.Millisecond
+
+var (
+	reloadMu        sync.Mutex
+	reloadListeners []func(oldCfg, newCfg *models.Config)
+
+	// watching 是否正在监听配置文件变化
+	watching atomic.Bool
+)
+
+// Watching 是否已启用配置文件监听（启用时任何文件变化都会重新加载，只修改运行时的配置会丢失）
+func Watching() bool {
+	return watching.Load()
+}
+
+// OnReload 注册配置热加载成功后的回调（在新配置生效后调用）
+func OnReload(listener func(oldCfg, newCfg *models.Config)) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	reloadListeners = append(reloadListeners, listener)
+}
+
+// Reload 重新读取配置文件，验证通过后原子替换当前配置
+// 验证失败时保留旧配置并返回错误；已在处理中的请求继续使用各自的配置快照
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	if configFile == "" {
+		return fmt.Errorf("配置未加载")
+	}
+
+	cfg, _, err := load(configFile)
+	if err != nil {
+		logger.LogError("配置热加载失败，继续使用旧配置", err, "file", configFile)
+		return err
+	}
+
+	rememberFileSecrets(cfg)
+	old := current.Swap(cfg)
+	logRestartOnlyChanges(old, cfg)
+	logger.LogInfo("配置已重新加载", "file", configFile)
+
+	for _, listener := range reloadListeners {
+		listener(old, cfg)
+	}
+	return nil
+}
+
+// logRestartOnlyChanges 提示需要重启才能生效的配置项
+func logRestartOnlyChanges(oldCfg, newCfg *models.Config) {
+	if oldCfg == nil {
+		return
+	}
+	if oldCfg.Proxy.ListenAddress != newCfg.Proxy.ListenAddress ||
+		oldCfg.Proxy.Port != newCfg.Proxy.Port ||
+		oldCfg.Proxy.ReadTimeout != newCfg.Proxy.ReadTimeout ||
+		oldCfg.Proxy.WriteTimeout != newCfg.Proxy.WriteTimeout ||
+		oldCfg.Proxy.IdleTimeout != newCfg.Proxy.IdleTimeout {
+		logger.LogWarn("proxy 监听地址、端口和服务器超时的修改需要重启服务才能生效")
+	}
+	if oldCfg.Logging != newCfg.Logging {
+		logger.LogWarn("logging 配置的修改需要重启服务才能生效")
+	}
+	if oldCfg.Ledger != newCfg.Ledger {
+		logger.LogWarn("ledger 配置的修改需要重启服务才能生效")
+	}
+	if !reflect.DeepEqual(oldCfg.Tracing, newCfg.Tracing) {
+		logger.LogWarn("tracing 配置的修改需要重启服务才能生效")
+	}
+}
+
+// Watch 监听配置文件变化并自动热加载，直到 stop 被关闭
+// 监听所在目录而不是文件本身，以兼容编辑器“写临时文件再重命名”的保存方式
+func Watch(stop <-chan struct{}) error {
+	if configFile == "" {
+		return fmt.Errorf("配置未加载")
+	}
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("创建配置文件监听失败: %v", err)
+	}
+
+	target := filepath.Clean(configFile)
+	if err := watcher.Add(filepath.Dir(target)); err != nil {
+		watcher.Close()
+		return fmt.Errorf("监听配置目录失败: %v", err)
+	}
+
+	logger.LogInfo("已启用配置文件热加载", "file", target)
+	watching.Store(true)
+
+	go func() {
+		defer watcher.Close()
+		defer watching.Store(false)
+
+		var timer *time.Timer
+		for {
+			select {
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(event.Name) != target ||
+					event.Op&(fsnotify.Write|fsnotify.Create|fsnotify.Rename) == 0 {
+					continue
+				}
+				if timer != nil {
+					timer.Stop()
+				}
+				timer = time.AfterFunc(reloadDebounce, func() {
+					_ = Reload()
+				})
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				logger.LogError("配置文件监听出错", err)
+			case <-stop:
+				if timer != nil {
+					timer.Stop()
+				}
+				return
+			}
+		}
+	}()
+
+	return nil
+}
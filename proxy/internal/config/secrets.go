@@ -0,0 +1,215 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// SecretProvider 密钥提供者，解析一种前缀的密钥引用（如 env:NAME 中的 NAME）
+type SecretProvider interface {
+	Resolve(ref string) (string, error)
+}
+
+// SecretProviderFunc 函数形式的密钥提供者
+type SecretProviderFunc func(ref string) (string, error)
+
+// Resolve 实现 SecretProvider
+func (f SecretProviderFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+// 执行 cmd: 引用的超时时间
+const secretCommandTimeout = 10 * time.Second
+
+var (
+	secretProvidersMu sync.RWMutex
+	// secretProviders 按引用前缀注册的密钥提供者
+	secretProviders = map[string]SecretProvider{
+		"env":  SecretProviderFunc(resolveEnvSecret),
+		"file": SecretProviderFunc(resolveFileSecret),
+		"cmd":  SecretProviderFunc(resolveCommandSecret),
+	}
+)
+
+// fileOnlySchemes 可以读取任意文件或执行命令的引用前缀，只接受配置文件中写明的引用
+var fileOnlySchemes = map[string]bool{"file": true, "cmd": true}
+
+// fileSecretRefs 最近一次从配置文件加载时出现的 file:、cmd: 引用
+var fileSecretRefs atomic.Pointer[map[string]bool]
+
+// RegisterSecretProvider 注册密钥提供者（如系统钥匙串），已存在的前缀会被替换
+func RegisterSecretProvider(scheme string, provider SecretProvider) {
+	secretProvidersMu.Lock()
+	defer secretProvidersMu.Unlock()
+	secretProviders[scheme] = provider
+}
+
+// secretProvider 查找值对应的密钥提供者，不是密钥引用时返回 nil
+func secretProvider(value string) (SecretProvider, string) {
+	scheme, ref, ok := strings.Cut(value, ":")
+	if !ok {
+		return nil, ""
+	}
+	secretProvidersMu.RLock()
+	defer secretProvidersMu.RUnlock()
+	return secretProviders[scheme], ref
+}
+
+// IsSecretRef 判断值是否为已注册前缀的密钥引用
+func IsSecretRef(value string) bool {
+	provider, _ := secretProvider(value)
+	return provider != nil
+}
+
+// ResolveSecret 解析密钥引用；不是引用时原样返回（兼容明文配置）
+func ResolveSecret(value string) (string, error) {
+	provider, ref := secretProvider(value)
+	if provider == nil {
+		return value, nil
+	}
+	secret, err := provider.Resolve(ref)
+	if err != nil {
+		return "", err
+	}
+	if secret == "" {
+		return "", fmt.Errorf("密钥引用 %s 解析结果为空", value)
+	}
+	return secret, nil
+}
+
+// CheckSubmittedSecret 检查通过管理接口提交的密钥引用
+// file:、cmd: 引用必须与配置文件中已有的引用完全相同，否则持有管理令牌即可在代理主机上执行命令或读取任意文件
+func CheckSubmittedSecret(value string) error {
+	scheme, _, ok := strings.Cut(value, ":")
+	if !ok || !fileOnlySchemes[scheme] {
+		return nil
+	}
+	if refs := fileSecretRefs.Load(); refs != nil && (*refs)[value] {
+		return nil
+	}
+	return fmt.Errorf("管理接口不接受新的 %s: 密钥引用，请在配置文件中设置", scheme)
+}
+
+// rememberFileSecrets 记录从配置文件加载的 file:、cmd: 引用
+func rememberFileSecrets(cfg *models.Config) {
+	refs := make(map[string]bool)
+	values := []string{cfg.Admin.Token}
+	for i := range cfg.Services {
+		values = append(values, cfg.Services[i].APIKey)
+	}
+	for _, value := range values {
+		if scheme, _, ok := strings.Cut(value, ":"); ok && fileOnlySchemes[scheme] {
+			refs[value] = true
+		}
+	}
+	fileSecretRefs.Store(&refs)
+}
+
+// resolveSecrets 解析配置中的所有密钥引用（服务的 api_key、admin.token）
+// previous 不为空时复用其中相同引用的解析结果，避免每次修改配置都重新执行 cmd: 命令
+func resolveSecrets(cfg *models.Config, previous *models.Config) error {
+	cache := make(map[string]string)
+	if previous != nil {
+		for i := range previous.Services {
+			if IsSecretRef(previous.Services[i].APIKey) {
+				cache[previous.Services[i].APIKey] = previous.Services[i].Key()
+			}
+		}
+		if IsSecretRef(previous.Admin.Token) {
+			cache[previous.Admin.Token] = previous.Admin.ResolvedToken()
+		}
+	}
+	resolve := func(value string) (string, error) {
+		if secret, ok := cache[value]; ok {
+			return secret, nil
+		}
+		secret, err := ResolveSecret(value)
+		if err != nil {
+			return "", err
+		}
+		cache[value] = secret
+		return secret, nil
+	}
+
+	for i := range cfg.Services {
+		svc := &cfg.Services[i]
+		if !IsSecretRef(svc.APIKey) {
+			svc.SetResolvedKey("")
+			continue
+		}
+		secret, err := resolve(svc.APIKey)
+		if err != nil {
+			return fmt.Errorf("解析服务 %s 的 api_key 失败: %v", svc.ID, err)
+		}
+		svc.SetResolvedKey(secret)
+	}
+
+	if IsSecretRef(cfg.Admin.Token) {
+		secret, err := resolve(cfg.Admin.Token)
+		if err != nil {
+			return fmt.Errorf("解析 admin.token 失败: %v", err)
+		}
+		cfg.Admin.SetResolvedToken(secret)
+	} else {
+		cfg.Admin.SetResolvedToken("")
+	}
+	return nil
+}
+
+// resolveEnvSecret env:NAME 读取环境变量
+func resolveEnvSecret(name string) (string, error) {
+	value, ok := os.LookupEnv(name)
+	if !ok {
+		return "", fmt.Errorf("环境变量 %s 未设置", name)
+	}
+	return value, nil
+}
+
+// resolveFileSecret file:/path 读取文件内容（去掉首尾空白）
+func resolveFileSecret(path string) (string, error) {
+	if strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("获取用户目录失败: %v", err)
+		}
+		path = home + path[1:]
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取密钥文件失败: %v", err)
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// resolveCommandSecret cmd:... 执行命令并使用其标准输出（去掉首尾空白）
+func resolveCommandSecret(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), secretCommandTimeout)
+	defer cancel()
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", "/C", command)
+	} else {
+		cmd = exec.CommandContext(ctx, "sh", "-c", command)
+	}
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("执行密钥命令失败: %v: %s", err, msg)
+		}
+		return "", fmt.Errorf("执行密钥命令失败: %v", err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
@@ -0,0 +1,259 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/models"
+	"gopkg.in/yaml.v3"
+)
+
+// Update 在当前配置的副本上执行修改，校验通过后原子替换当前配置
+// persistPaths 为需要写回配置文件的 YAML 路径（如 "services"、"features.service_auto_switch"），
+// 为空时只修改运行时配置。校验失败时当前配置保持不变
+func Update(mutate func(cfg *models.Config) error, persistPaths ...string) (*models.Config, error) {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+
+	old := Get()
+	if old == nil {
+		return nil, fmt.Errorf("配置未加载")
+	}
+
+	cfg, err := Clone(old)
+	if err != nil {
+		return nil, err
+	}
+	if err := mutate(cfg); err != nil {
+		return nil, err
+	}
+	// 先校验再解析密钥引用，校验失败的修改不会执行 cmd: 命令
+	if err := validateConfig(cfg); err != nil {
+		return nil, fmt.Errorf("配置验证失败: %v", err)
+	}
+	// api_key 等字段中保存的是密钥引用，写回文件的也是引用；解析结果只保存在内存中
+	if err := resolveSecrets(cfg, old); err != nil {
+		return nil, err
+	}
+
+	// 先写回文件再替换，写回失败时运行时配置也不变
+	if len(persistPaths) > 0 {
+		if err := writeBack(cfg, persistPaths); err != nil {
+			return nil, err
+		}
+	}
+
+	current.Store(cfg)
+	logger.LogInfo("运行时配置已更新", "persisted", strings.Join(persistPaths, ","))
+
+	for _, listener := range reloadListeners {
+		listener(old, cfg)
+	}
+	return cfg, nil
+}
+
+// Clone 深拷贝配置
+func Clone(cfg *models.Config) (*models.Config, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("复制配置失败: %v", err)
+	}
+	clone := &models.Config{}
+	if err := json.Unmarshal(data, clone); err != nil {
+		return nil, fmt.Errorf("复制配置失败: %v", err)
+	}
+	return clone, nil
+}
+
+// writeBack 将配置中指定路径的值写回 YAML 文件，其余内容（包括注释）保持不变
+func writeBack(cfg *models.Config, paths []string) error {
+	if configFile == "" {
+		return fmt.Errorf("配置未加载")
+	}
+
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("读取配置文件失败: %v", err)
+	}
+	// 保持原文件权限（配置中可能有明文密钥，不能放宽为 0644）
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(configFile); err == nil {
+		mode = info.Mode().Perm()
+	}
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return fmt.Errorf("解析配置文件失败: %v", err)
+	}
+	if len(doc.Content) == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode}}}
+	}
+
+	for _, path := range paths {
+		keys := strings.Split(path, ".")
+		value, err := configValue(cfg, keys)
+		if err != nil {
+			return err
+		}
+		node, err := valueNode(value)
+		if err != nil {
+			return err
+		}
+		setPath(doc.Content[0], keys, node)
+	}
+
+	var out strings.Builder
+	encoder := yaml.NewEncoder(&out)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(&doc); err != nil {
+		return fmt.Errorf("序列化配置失败: %v", err)
+	}
+	encoder.Close()
+
+	// 先写临时文件再重命名，文件监听不会读到写了一半的文件
+	tmpFile := configFile + ".tmp"
+	if err := os.WriteFile(tmpFile, []byte(out.String()), mode); err != nil {
+		return fmt.Errorf("写入配置文件失败: %v", err)
+	}
+	if err := os.Rename(tmpFile, configFile); err != nil {
+		os.Remove(tmpFile)
+		return fmt.Errorf("写入配置文件失败: %v", err)
+	}
+	return nil
+}
+
+// configValue 按 YAML 键路径取出配置值的 JSON 表示（保持结构体字段顺序）
+// 难度映射转换为最简写法（单个服务ID / ID列表 / 带权重列表），与手写配置保持一致
+func configValue(cfg *models.Config, keys []string) (json.RawMessage, error) {
+	if keys[0] == "difficulty_mapping" {
+		mapping := make(map[string]interface{}, len(cfg.DifficultyMapping))
+		for level, targets := range cfg.DifficultyMapping {
+			mapping[level] = simplifyTargets(targets)
+		}
+		data, err := json.Marshal(mapping)
+		if err != nil {
+			return nil, fmt.Errorf("序列化配置失败: %v", err)
+		}
+		return lookupPath(data, keys[1:])
+	}
+
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("序列化配置失败: %v", err)
+	}
+	return lookupPath(data, keys)
+}
+
+// simplifyTargets 返回难度映射的最简写法
+func simplifyTargets(targets models.LevelTargets) interface{} {
+	for _, target := range targets {
+		if target.Weight != 0 {
+			return targets
+		}
+	}
+	if len(targets) == 1 {
+		return targets[0].ID
+	}
+	return targets.IDs()
+}
+
+// lookupPath 按键路径查找 JSON 中的值
+func lookupPath(data json.RawMessage, keys []string) (json.RawMessage, error) {
+	for _, key := range keys {
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(data, &fields); err != nil {
+			return nil, fmt.Errorf("配置路径不存在: %s", key)
+		}
+		value, ok := fields[key]
+		if !ok {
+			return nil, fmt.Errorf("配置路径不存在: %s", key)
+		}
+		data = value
+	}
+	return data, nil
+}
+
+// valueNode 将 JSON 值转换为块格式的 YAML 节点
+// JSON 也是合法的 YAML，直接解析可以保留字段顺序
+func valueNode(data json.RawMessage) (*yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("序列化配置失败: %v", err)
+	}
+	node := doc.Content[0]
+	normalizeNode(node)
+	return node, nil
+}
+
+// normalizeNode 将 JSON 的流式风格转换为与配置文件一致的块格式：
+// 字符串值加双引号、多行字符串使用 | 块，并去掉全为零值的子映射（如未配置的价格表）
+func normalizeNode(node *yaml.Node) {
+	node.Style = 0
+	if node.Kind == yaml.ScalarNode && node.Tag == "!!str" {
+		if strings.Contains(node.Value, "\n") {
+			node.Style = yaml.LiteralStyle
+		} else {
+			node.Style = yaml.DoubleQuotedStyle
+		}
+	}
+	if node.Kind == yaml.MappingNode {
+		content := make([]*yaml.Node, 0, len(node.Content))
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			if isZeroMapping(node.Content[i+1]) {
+				continue
+			}
+			content = append(content, node.Content[i], node.Content[i+1])
+		}
+		node.Content = content
+	}
+	for i, child := range node.Content {
+		normalizeNode(child)
+		// 映射的键不加引号（数字形式的键由编码器自动加引号）
+		if node.Kind == yaml.MappingNode && i%2 == 0 {
+			child.Style = 0
+		}
+	}
+}
+
+// isZeroMapping 判断映射节点是否所有值都是零值
+func isZeroMapping(node *yaml.Node) bool {
+	if node.Kind != yaml.MappingNode || len(node.Content) == 0 {
+		return false
+	}
+	for i := 1; i < len(node.Content); i += 2 {
+		v := node.Content[i]
+		if v.Kind != yaml.ScalarNode || (v.Value != "0" && v.Value != "") {
+			return false
+		}
+	}
+	return true
+}
+
+// setPath 在映射节点中按键路径设置值，缺失的中间层级自动创建
+func setPath(mapping *yaml.Node, keys []string, value *yaml.Node) {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value != keys[0] {
+			continue
+		}
+		if len(keys) == 1 {
+			mapping.Content[i+1] = value
+			return
+		}
+		if mapping.Content[i+1].Kind != yaml.MappingNode {
+			mapping.Content[i+1] = &yaml.Node{Kind: yaml.MappingNode}
+		}
+		setPath(mapping.Content[i+1], keys[1:], value)
+		return
+	}
+
+	key := &yaml.Node{Kind: yaml.ScalarNode, Value: keys[0]}
+	if len(keys) == 1 {
+		mapping.Content = append(mapping.Content, key, value)
+		return
+	}
+	child := &yaml.Node{Kind: yaml.MappingNode}
+	mapping.Content = append(mapping.Content, key, child)
+	setPath(child, keys[1:], value)
+}
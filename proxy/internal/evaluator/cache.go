@@ -0,0 +1,181 @@
+package evaluator
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// CacheStats 评估缓存统计
+type CacheStats struct {
+	Enabled       bool    `json:"enabled"`
+	Entries       int     `json:"entries"`
+	Hits          uint64  `json:"hits"`
+	Misses        uint64  `json:"misses"`
+	SessionReuses uint64  `json:"session_reuses"` // 仅含 tool_result 的轮次复用会话上一次决策的次数
+	HitRate       float64 `json:"hit_rate"`
+}
+
+// cacheEntry 缓存项
+type cacheEntry struct {
+	key       string
+	response  models.EvaluatorResponse
+	expiresAt time.Time
+}
+
+// decisionCache 难度评估结果的 LRU/TTL 缓存
+// 同时保存按意图的决策和每个会话的最近一次决策
+type decisionCache struct {
+	mu      sync.Mutex
+	cfg     models.EvaluatorCacheConfig
+	entries map[string]*list.Element
+	order   *list.List // 最近使用的在前
+
+	hits          uint64
+	misses        uint64
+	sessionReuses uint64
+}
+
+// newDecisionCache 创建评估缓存
+func newDecisionCache() *decisionCache {
+	return &decisionCache{
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// reset 按新配置清空缓存（策略或规则变化后旧决策不再可信），统计保留
+func (dc *decisionCache) reset(cfg models.EvaluatorCacheConfig) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	dc.cfg = cfg
+	dc.entries = make(map[string]*list.Element)
+	dc.order.Init()
+}
+
+// intentKey 按会话和归一化后的用户意图生成缓存 key
+func intentKey(userID, sessionID, intent string) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(intent)), " ")
+	sum := sha256.Sum256([]byte(userID + "\x00" + sessionID + "\x00" + normalized))
+	return "intent:" + hex.EncodeToString(sum[:])
+}
+
+// sessionKey 会话最近一次决策的缓存 key
+func sessionKey(userID, sessionID string) string {
+	return "session:" + userID + "_" + sessionID
+}
+
+// lookup 查找未过期的缓存项，并记录命中/未命中
+func (dc *decisionCache) lookup(key string, reuse bool) (*models.EvaluatorResponse, bool) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if !dc.cfg.Enabled {
+		return nil, false
+	}
+
+	elem, ok := dc.entries[key]
+	if ok {
+		entry := elem.Value.(*cacheEntry)
+		if time.Now().Before(entry.expiresAt) {
+			dc.order.MoveToFront(elem)
+			if reuse {
+				dc.sessionReuses++
+			} else {
+				dc.hits++
+			}
+			response := entry.response
+			return &response, true
+		}
+		dc.removeElement(elem)
+	}
+
+	if !reuse {
+		dc.misses++
+	}
+	return nil, false
+}
+
+// store 写入缓存，超出容量时淘汰最久未使用的项
+func (dc *decisionCache) store(key string, response *models.EvaluatorResponse) {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	if !dc.cfg.Enabled {
+		return
+	}
+
+	expiresAt := time.Now().Add(time.Duration(dc.cfg.TTLSeconds) * time.Second)
+	if elem, ok := dc.entries[key]; ok {
+		entry := elem.Value.(*cacheEntry)
+		entry.response = *response
+		entry.expiresAt = expiresAt
+		dc.order.MoveToFront(elem)
+		return
+	}
+
+	dc.entries[key] = dc.order.PushFront(&cacheEntry{
+		key:       key,
+		response:  *response,
+		expiresAt: expiresAt,
+	})
+	for dc.cfg.MaxEntries > 0 && dc.order.Len() > dc.cfg.MaxEntries {
+		dc.removeElement(dc.order.Back())
+	}
+}
+
+// removeElement 删除缓存项（调用方需持有锁）
+func (dc *decisionCache) removeElement(elem *list.Element) {
+	dc.order.Remove(elem)
+	delete(dc.entries, elem.Value.(*cacheEntry).key)
+}
+
+// Stats 获取缓存统计
+func (dc *decisionCache) Stats() CacheStats {
+	dc.mu.Lock()
+	defer dc.mu.Unlock()
+
+	stats := CacheStats{
+		Enabled:       dc.cfg.Enabled,
+		Entries:       dc.order.Len(),
+		Hits:          dc.hits,
+		Misses:        dc.misses,
+		SessionReuses: dc.sessionReuses,
+	}
+	if total := dc.hits + dc.misses; total > 0 {
+		stats.HitRate = float64(dc.hits) / float64(total)
+	}
+	return stats
+}
+
+// isToolResultTurn 判断最新的用户消息是否只包含 tool_result（及 system-reminder 等辅助内容）
+func isToolResultTurn(messages []models.Message) bool {
+	if len(messages) == 0 {
+		return false
+	}
+	last := messages[len(messages)-1]
+	if last.Role != "user" {
+		return false
+	}
+
+	hasToolResult := false
+	for _, content := range last.Content {
+		switch content.Type {
+		case "tool_result":
+			hasToolResult = true
+		case "text":
+			if text := strings.TrimSpace(content.Text); text != "" && !isAuxiliaryContent(text) {
+				return false
+			}
+		default:
+			return false
+		}
+	}
+	return hasToolResult
+}
@@ -0,0 +1,52 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethan/claude-proxy/internal/classifier"
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// classifierEvaluator 使用本地离线分类模型评估，不依赖决策者服务
+type classifierEvaluator struct {
+	model         *classifier.Model
+	loadErr       error // 模型加载失败时每次评估都返回该错误，chain 会继续尝试下一个策略
+	minConfidence float64
+}
+
+// newClassifierEvaluator 加载分类模型
+func newClassifierEvaluator(cfg *models.ClassifierEvaluatorConfig) *classifierEvaluator {
+	e := &classifierEvaluator{minConfidence: cfg.MinConfidence}
+	e.model, e.loadErr = classifier.Load(cfg.ModelPath)
+	if e.loadErr != nil {
+		logger.LogError("加载离线分类模型失败", e.loadErr, "model_path", cfg.ModelPath)
+	} else {
+		logger.LogInfo("已加载离线分类模型",
+			"model_path", cfg.ModelPath,
+			"docs", e.model.Docs,
+			"trained_at", e.model.TrainedAt,
+		)
+	}
+	return e
+}
+
+func (e *classifierEvaluator) Name() string { return "classifier" }
+
+func (e *classifierEvaluator) Evaluate(ctx context.Context, evalReq *models.EvaluatorRequest) (*models.EvaluatorResponse, error) {
+	if e.loadErr != nil {
+		return nil, e.loadErr
+	}
+
+	intent := extractUserIntent(evalReq.OriginalRequest.Messages)
+	level, confidence := e.model.Predict(intent)
+	if level == 0 || confidence < e.minConfidence {
+		return nil, nil
+	}
+
+	return &models.EvaluatorResponse{
+		DifficultyLevel: level,
+		Reasoning:       fmt.Sprintf("离线分类器预测 (置信度 %.2f)", confidence),
+	}, nil
+}
@@ -11,9 +11,14 @@ import (
 	"sync"
 	"time"
 	
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"github.com/ethan/claude-proxy/internal/config"
 	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/metrics"
 	"github.com/ethan/claude-proxy/internal/models"
+	"github.com/ethan/claude-proxy/internal/tracing"
+	"github.com/ethan/claude-proxy/internal/usage"
 )
 
 // ContextManager 管理用户上下文
@@ -74,11 +79,53 @@ func (cm *ContextManager) UpdateContext(userID, sessionID string, summary models
 	}
 }
 
+// RecordEscalation 记录一次因上游失败导致的难度升级，之后 turns 次评估至少为 level
+func (cm *ContextManager) RecordEscalation(userID, sessionID string, level, turns int) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	
+	key := fmt.Sprintf("%s_%s", userID, sessionID)
+	ctx, exists := cm.contexts[key]
+	if !exists {
+		ctx = &models.UserContext{
+			UserID:    userID,
+			SessionID: sessionID,
+			RequestHistory: []models.RequestSummary{},
+		}
+		cm.contexts[key] = ctx
+	}
+	
+	if level > ctx.EscalatedLevel || ctx.EscalationTurns == 0 {
+		ctx.EscalatedLevel = level
+	}
+	ctx.EscalationTurns = turns
+}
+
+// consumeEscalation 获取升级后的最低难度等级并消耗一次，没有生效的升级时返回0
+func (cm *ContextManager) consumeEscalation(userID, sessionID string) int {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	
+	ctx, exists := cm.contexts[fmt.Sprintf("%s_%s", userID, sessionID)]
+	if !exists || ctx.EscalationTurns <= 0 {
+		return 0
+	}
+	ctx.EscalationTurns--
+	return ctx.EscalatedLevel
+}
+
 // Client 决策者服务客户端
 type Client struct {
 	httpClient     *http.Client
 	contextManager *ContextManager
 	maxRetries     int
+	usageRecorder  func(service *models.Service, u usage.Usage) // 记录评估调用本身的 token 用量
+	
+	cache       *decisionCache
+	
+	strategyMu  sync.Mutex
+	strategyCfg *models.Config       // strategy 对应的配置快照
+	strategy    DifficultyEvaluator
 }
 
 // NewClient 创建决策者客户端
@@ -88,15 +135,32 @@ func NewClient() *Client {
 			Timeout: 30 * time.Second,
 		},
 		contextManager: NewContextManager(),
+		cache:          newDecisionCache(),
 		maxRetries:     3, // 默认重试3次
 	}
 }
 
+// SetUsageRecorder 设置评估调用的 token 用量记录函数
+func (c *Client) SetUsageRecorder(recorder func(service *models.Service, u usage.Usage)) {
+	c.usageRecorder = recorder
+}
+
 // EvaluateDifficulty 评估请求难度
-func (c *Client) EvaluateDifficulty(ctx context.Context, request *models.ClaudeRequest) (*models.EvaluatorResponse, error) {
+func (c *Client) EvaluateDifficulty(ctx context.Context, request *models.ClaudeRequest) (result *models.EvaluatorResponse, err error) {
 	// 提取用户信息
 	userID, sessionID := models.ExtractUserInfo(request.Metadata)
 	
+	ctx, span := tracing.Start(ctx, "evaluator.evaluate", tracing.UserAttributes(userID, sessionID)...)
+	defer func() {
+		if result != nil {
+			span.SetAttributes(
+				attribute.Int("cce.difficulty_level", result.DifficultyLevel),
+				attribute.String("cce.evaluator_strategy", result.Strategy),
+			)
+		}
+		tracing.End(span, err)
+	}()
+	
 	// 获取用户上下文
 	userContext := c.contextManager.GetContext(userID, sessionID)
 	
@@ -106,8 +170,117 @@ func (c *Client) EvaluateDifficulty(ctx context.Context, request *models.ClaudeR
 		UserContext:     *userContext,
 	}
 	
-	// 获取决策者服务配置
-	evaluatorService, err := config.GetEvaluatorService()
+	// 按请求开始时的配置快照选择评估策略
+	cfg := config.FromContext(ctx)
+	strategy, err := c.strategyFor(cfg)
+	if err != nil {
+		return nil, err
+	}
+	
+	// 先查缓存：仅含 tool_result 的轮次可复用会话上一次的决策，其余按意图查找
+	cacheKey := intentKey(userID, sessionID, extractUserIntent(request.Messages))
+	var cached *models.EvaluatorResponse
+	ok := false
+	if cfg.Evaluator.Cache.ReuseOnToolResult && isToolResultTurn(request.Messages) {
+		cached, ok = c.cache.lookup(sessionKey(userID, sessionID), true)
+	}
+	if !ok {
+		cached, ok = c.cache.lookup(cacheKey, false)
+	}
+	if ok {
+		cached.Reasoning = "缓存命中: " + cached.Reasoning
+		cached.Strategy = "cache"
+		metrics.EvaluatorDecisions.WithLabelValues(cached.Strategy).Inc()
+		c.applyEscalation(userID, sessionID, cached)
+		c.updateContext(userID, sessionID, request, cached)
+		return cached, nil
+	}
+	
+	response, err := strategy.Evaluate(ctx, evalReq)
+	if err == nil && response == nil {
+		err = fmt.Errorf("评估策略 %s 无法判断难度", strategy.Name())
+	}
+	if err != nil {
+		// 如果启用了备选服务，这里可以实现备选逻辑
+		if cfg.Features.EvaluatorFallback {
+			logger.LogWarn("难度评估失败，使用默认难度等级", "default_level", 3, "error", err)
+			metrics.EvaluatorDecisions.WithLabelValues("fallback").Inc()
+			return &models.EvaluatorResponse{
+				DifficultyLevel: 3,
+				Reasoning:       "难度评估失败，使用默认中等难度",
+				Strategy:        "fallback",
+			}, nil
+		}
+		
+		return nil, err
+	}
+	if response.Strategy == "" {
+		response.Strategy = strategy.Name()
+	}
+	metrics.EvaluatorDecisions.WithLabelValues(response.Strategy).Inc()
+	
+	c.cache.store(cacheKey, response)
+	c.cache.store(sessionKey(userID, sessionID), response)
+	c.applyEscalation(userID, sessionID, response)
+	c.updateContext(userID, sessionID, request, response)
+	
+	return response, nil
+}
+
+// applyEscalation 会话近期因上游失败升级过时，将难度等级提高到升级后的等级
+// 在写入缓存之后调用，缓存中保留策略的原始判定；提高后的等级记为 escalation 策略，不作为分类器的训练标注
+func (c *Client) applyEscalation(userID, sessionID string, response *models.EvaluatorResponse) {
+	level := c.contextManager.consumeEscalation(userID, sessionID)
+	if level <= response.DifficultyLevel {
+		return
+	}
+	response.Reasoning = fmt.Sprintf("%s（%s 策略判定 %d 级，会话近期升级过，提高到 %d 级）", response.Reasoning, response.Strategy, response.DifficultyLevel, level)
+	response.DifficultyLevel = level
+	response.Strategy = "escalation"
+}
+
+// updateContext 将本次决策写入用户上下文
+func (c *Client) updateContext(userID, sessionID string, request *models.ClaudeRequest, response *models.EvaluatorResponse) {
+	c.contextManager.UpdateContext(userID, sessionID, models.RequestSummary{
+		Timestamp:       time.Now(),
+		Model:           request.Model,
+		MessageCount:    len(request.Messages),
+		DifficultyLevel: response.DifficultyLevel,
+	})
+}
+
+// CacheStats 获取评估缓存统计
+func (c *Client) CacheStats() CacheStats {
+	return c.cache.Stats()
+}
+
+// strategyFor 获取配置快照对应的评估策略，配置未变化时复用已构建的策略
+func (c *Client) strategyFor(cfg *models.Config) (DifficultyEvaluator, error) {
+	c.strategyMu.Lock()
+	defer c.strategyMu.Unlock()
+	
+	if c.strategy != nil && c.strategyCfg == cfg {
+		return c.strategy, nil
+	}
+	strategy, err := newStrategy(&cfg.Evaluator, c)
+	if err != nil {
+		return nil, fmt.Errorf("构建评估策略失败: %v", err)
+	}
+	c.strategy = strategy
+	c.strategyCfg = cfg
+	
+	// 配置变化后策略可能不同，清空缓存的决策
+	c.cache.reset(cfg.Evaluator.Cache)
+	return strategy, nil
+}
+
+// evaluateWithLLM 调用决策者服务评估难度（带重试）
+func (c *Client) evaluateWithLLM(ctx context.Context, evalReq *models.EvaluatorRequest) (*models.EvaluatorResponse, error) {
+	userID, sessionID := evalReq.UserContext.UserID, evalReq.UserContext.SessionID
+	span := trace.SpanFromContext(ctx)
+	
+	// 获取决策者服务配置（使用请求开始时的配置快照）
+	evaluatorService, err := config.GetEvaluatorService(config.FromContext(ctx))
 	if err != nil {
 		return nil, fmt.Errorf("获取决策者服务失败: %v", err)
 	}
@@ -117,7 +290,19 @@ func (c *Client) EvaluateDifficulty(ctx context.Context, request *models.ClaudeR
 	var lastErr error
 	
 	for i := 0; i < c.maxRetries; i++ {
-		response, lastErr = c.doRequest(ctx, evaluatorService, evalReq)
+		if i > 0 {
+			metrics.EvaluatorRetries.WithLabelValues(evaluatorService.ID).Inc()
+		}
+		attemptCtx, attemptSpan := tracing.Start(ctx, "evaluator.attempt", append(
+			tracing.UserAttributes(userID, sessionID),
+			attribute.String("cce.service_id", evaluatorService.ID),
+			attribute.Int("cce.attempt", i+1),
+		)...)
+		response, lastErr = c.doRequest(attemptCtx, evaluatorService, evalReq)
+		if response != nil {
+			attemptSpan.SetAttributes(attribute.Int("cce.difficulty_level", response.DifficultyLevel))
+		}
+		tracing.End(attemptSpan, lastErr)
 		if lastErr == nil {
 			break
 		}
@@ -131,6 +316,7 @@ func (c *Client) EvaluateDifficulty(ctx context.Context, request *models.ClaudeR
 		// 指数退避
 		if i < c.maxRetries-1 {
 			backoff := time.Duration(1<<uint(i)) * time.Second
+			span.AddEvent("backoff", trace.WithAttributes(attribute.Int64("backoff_ms", backoff.Milliseconds())))
 			select {
 			case <-time.After(backoff):
 			case <-ctx.Done():
@@ -140,41 +326,25 @@ func (c *Client) EvaluateDifficulty(ctx context.Context, request *models.ClaudeR
 	}
 	
 	if lastErr != nil {
-		// 如果启用了备选服务，这里可以实现备选逻辑
-		if config.Cfg.Features.EvaluatorFallback {
-			logger.LogWarn("决策者服务不可用，使用默认难度等级", "default_level", 3)
-			return &models.EvaluatorResponse{
-				DifficultyLevel: 3,
-				Reasoning:       "决策者服务不可用，使用默认中等难度",
-			}, nil
-		}
-		
 		return nil, fmt.Errorf("决策者服务请求失败: %v", lastErr)
 	}
 	
-	// 更新用户上下文
-	c.contextManager.UpdateContext(userID, sessionID, models.RequestSummary{
-		Timestamp:       time.Now(),
-		Model:           request.Model,
-		MessageCount:    len(request.Messages),
-		DifficultyLevel: response.DifficultyLevel,
-	})
-	
 	return response, nil
 }
 
 // doRequest 执行单次请求
 func (c *Client) doRequest(ctx context.Context, service *models.Service, evalReq *models.EvaluatorRequest) (*models.EvaluatorResponse, error) {
 	// 构建评估 prompt
-	prompt := c.buildEvaluationPrompt(evalReq)
+	cfg := config.FromContext(ctx)
+	prompt := c.buildEvaluationPrompt(&cfg.Evaluator, evalReq)
 	
 	// 从配置中获取evaluator设置
-	evalModel := config.Cfg.Evaluator.Model
+	evalModel := cfg.Evaluator.Model
 	if evalModel == "" {
 		evalModel = "claude-3-haiku-20240307" // 默认使用haiku
 	}
 
-	maxTokens := config.Cfg.Evaluator.MaxTokens
+	maxTokens := cfg.Evaluator.MaxTokens
 	if maxTokens <= 0 {
 		maxTokens = 100 // 默认100 tokens
 	}
@@ -211,7 +381,8 @@ func (c *Client) doRequest(ctx context.Context, service *models.Service, evalReq
 	
 	// 设置请求头
 	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", service.APIKey))
+	name, value := service.Credential()
+	req.Header.Set(name, value)
 	req.Header.Set("anthropic-version", "2023-06-01")
 	
 	// 发送请求
@@ -232,6 +403,11 @@ func (c *Client) doRequest(ctx context.Context, service *models.Service, evalReq
 		return nil, fmt.Errorf("决策者服务返回错误: status=%d, body=%s", resp.StatusCode, string(body))
 	}
 	
+	// 记录评估调用的 token 用量
+	if u, ok := usage.ParseResponse(body); ok && c.usageRecorder != nil {
+		c.usageRecorder(service, u)
+	}
+	
 	// 解析 Claude API 响应
 	var claudeResp struct {
 		Content []struct {
@@ -268,10 +444,7 @@ func (c *Client) GetContextManager() *ContextManager {
 
 // buildEvaluationPrompt 构建评估任务复杂度的 prompt
 // 使用配置文件中的prompt模板，支持变量替换
-func (c *Client) buildEvaluationPrompt(evalReq *models.EvaluatorRequest) string {
-	// 获取配置
-	cfg := config.Cfg.Evaluator
-
+func (c *Client) buildEvaluationPrompt(cfg *models.EvaluatorConfig, evalReq *models.EvaluatorRequest) string {
 	// 构建历史上下文信息
 	var contextInfo string
 	if cfg.IncludeHistory && len(evalReq.UserContext.RequestHistory) > 0 {
@@ -300,7 +473,7 @@ func (c *Client) buildEvaluationPrompt(evalReq *models.EvaluatorRequest) string
 	model := evalReq.OriginalRequest.Model
 
 	// 智能提取最新的用户任务内容
-	currentTask := c.extractUserIntent(evalReq.OriginalRequest.Messages)
+	currentTask := extractUserIntent(evalReq.OriginalRequest.Messages)
 
 	// 如果没有提取到有效内容，尝试提取最近几轮对话的简要摘要
 	if currentTask == "" {
@@ -351,9 +524,30 @@ func (c *Client) renderTemplate(template string, data map[string]interface{}) st
 	return result
 }
 
+// ExtractUserIntent 提取最新的真实用户输入，供请求记录等其他模块使用
+func ExtractUserIntent(messages []models.Message) string {
+	return extractUserIntent(messages)
+}
+
+// IsNewUserPrompt 判断最新的用户消息是否包含真实的用户输入（而非仅有 tool_result 等辅助内容）
+func IsNewUserPrompt(messages []models.Message) bool {
+	if len(messages) == 0 || messages[len(messages)-1].Role != "user" {
+		return false
+	}
+	for _, content := range messages[len(messages)-1].Content {
+		if content.Type != "text" {
+			continue
+		}
+		if text := strings.TrimSpace(content.Text); text != "" && !isAuxiliaryContent(text) {
+			return true
+		}
+	}
+	return false
+}
+
 // extractUserIntent 智能提取用户的真实意图
 // 过滤掉system-reminder、tool_result、命令输出等辅助内容
-func (c *Client) extractUserIntent(messages []models.Message) string {
+func extractUserIntent(messages []models.Message) string {
 	// 从最后一条user消息开始，向前查找
 	for i := len(messages) - 1; i >= 0; i-- {
 		if messages[i].Role != "user" {
@@ -369,7 +563,7 @@ func (c *Client) extractUserIntent(messages []models.Message) string {
 			text := strings.TrimSpace(content.Text)
 
 			// 过滤掉辅助内容
-			if c.isAuxiliaryContent(text) {
+			if isAuxiliaryContent(text) {
 				continue
 			}
 
@@ -389,7 +583,7 @@ func (c *Client) extractUserIntent(messages []models.Message) string {
 }
 
 // isAuxiliaryContent 判断文本是否为辅助内容（system-reminder、tool_result等）
-func (c *Client) isAuxiliaryContent(text string) bool {
+func isAuxiliaryContent(text string) bool {
 	// 检查是否为system-reminder
 	if strings.Contains(text, "<system-reminder>") {
 		return true
@@ -435,7 +629,7 @@ func (c *Client) extractRecentContext(messages []models.Message, recentCount int
 
 		// 提取该user消息的有效文本
 		for _, content := range messages[i].Content {
-			if content.Type == "text" && !c.isAuxiliaryContent(content.Text) {
+			if content.Type == "text" && !isAuxiliaryContent(content.Text) {
 				text := strings.TrimSpace(content.Text)
 				if text != "" && len(text) < 200 { // 只取较短的文本
 					contextParts = append([]string{text}, contextParts...) // 保持时间顺序
@@ -0,0 +1,74 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// heuristicEvaluator 按消息数、工具数和估算 token 数分级，不调用任何外部服务
+type heuristicEvaluator struct {
+	cfg models.HeuristicEvaluatorConfig
+}
+
+func (e *heuristicEvaluator) Name() string { return "heuristic" }
+
+func (e *heuristicEvaluator) Evaluate(ctx context.Context, evalReq *models.EvaluatorRequest) (*models.EvaluatorResponse, error) {
+	request := &evalReq.OriginalRequest
+	messages := len(request.Messages)
+	tools := len(request.Tools)
+	tokens := estimateTokens(request)
+
+	level := thresholdLevel(messages, e.cfg.MessageThresholds)
+	if l := thresholdLevel(tools, e.cfg.ToolThresholds); l > level {
+		level = l
+	}
+	if l := thresholdLevel(tokens, e.cfg.TokenThresholds); l > level {
+		level = l
+	}
+
+	if len(e.cfg.DecideLevels) > 0 && !containsLevel(e.cfg.DecideLevels, level) {
+		return nil, nil
+	}
+
+	return &models.EvaluatorResponse{
+		DifficultyLevel: level,
+		Reasoning:       fmt.Sprintf("消息数 %d, 工具数 %d, 估算 token %d", messages, tools, tokens),
+	}, nil
+}
+
+// thresholdLevel 超过第 N 个阈值即为 N+1 级，未配置阈值时为1级
+func thresholdLevel(value int, thresholds []int) int {
+	level := 1
+	for _, threshold := range thresholds {
+		if value > threshold {
+			level++
+		}
+	}
+	return level
+}
+
+// containsLevel 判断等级是否在列表中
+func containsLevel(levels []int, level int) bool {
+	for _, l := range levels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// estimateTokens 粗略估算请求的 token 数（约4字节一个 token）
+func estimateTokens(request *models.ClaudeRequest) int {
+	size := 0
+	for _, system := range request.System {
+		size += len(system.Text)
+	}
+	for _, message := range request.Messages {
+		for _, content := range message.Content {
+			size += len(content.Text)
+		}
+	}
+	return size / 4
+}
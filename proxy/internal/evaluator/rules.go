@@ -0,0 +1,103 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// compiledRule 预编译后的难度规则
+type compiledRule struct {
+	name     string
+	level    int
+	keywords []string
+	pattern  *regexp.Regexp
+	weight   float64
+}
+
+// rulesEvaluator 对用户意图做关键词/正则匹配，按等级累计得分，取得分最高的等级
+type rulesEvaluator struct {
+	rules    []compiledRule
+	minScore float64
+}
+
+// newRulesEvaluator 编译规则配置
+func newRulesEvaluator(cfg *models.RulesEvaluatorConfig) (*rulesEvaluator, error) {
+	e := &rulesEvaluator{minScore: cfg.MinScore}
+	for i, rule := range cfg.Rules {
+		compiled := compiledRule{
+			name:   rule.Name,
+			level:  rule.Level,
+			weight: rule.Weight,
+		}
+		if compiled.name == "" {
+			compiled.name = fmt.Sprintf("rule-%d", i+1)
+		}
+		if compiled.weight == 0 {
+			compiled.weight = 1
+		}
+		for _, keyword := range rule.Keywords {
+			if keyword = strings.ToLower(strings.TrimSpace(keyword)); keyword != "" {
+				compiled.keywords = append(compiled.keywords, keyword)
+			}
+		}
+		if rule.Pattern != "" {
+			pattern, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("规则 %s 的正则无效: %v", compiled.name, err)
+			}
+			compiled.pattern = pattern
+		}
+		e.rules = append(e.rules, compiled)
+	}
+	return e, nil
+}
+
+func (e *rulesEvaluator) Name() string { return "rules" }
+
+func (e *rulesEvaluator) Evaluate(ctx context.Context, evalReq *models.EvaluatorRequest) (*models.EvaluatorResponse, error) {
+	intent := extractUserIntent(evalReq.OriginalRequest.Messages)
+	if intent == "" {
+		return nil, nil
+	}
+	lowerIntent := strings.ToLower(intent)
+
+	var scores [6]float64
+	var matched [6][]string
+	for _, rule := range e.rules {
+		if !rule.matches(intent, lowerIntent) {
+			continue
+		}
+		scores[rule.level] += rule.weight
+		matched[rule.level] = append(matched[rule.level], rule.name)
+	}
+
+	// 同分时取较高等级，宁可多花一点也不降低回答质量
+	best := 0
+	for level := 1; level <= 5; level++ {
+		if scores[level] > 0 && scores[level] >= scores[best] {
+			best = level
+		}
+	}
+	if best == 0 || scores[best] < e.minScore {
+		return nil, nil
+	}
+
+	return &models.EvaluatorResponse{
+		DifficultyLevel: best,
+		Reasoning:       fmt.Sprintf("命中规则: %s (得分 %.1f)", strings.Join(matched[best], ", "), scores[best]),
+	}, nil
+}
+
+// matches 判断规则是否命中用户意图
+func (r compiledRule) matches(intent, lowerIntent string) bool {
+	for _, keyword := range r.keywords {
+		if strings.Contains(lowerIntent, keyword) {
+			return true
+		}
+	}
+	return r.pattern != nil && r.pattern.MatchString(intent)
+}
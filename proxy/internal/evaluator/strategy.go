@@ -0,0 +1,109 @@
+package evaluator
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// DifficultyEvaluator 难度评估策略
+// Evaluate 返回 (nil, nil) 表示该策略无法判断，由 chain 中的下一个策略继续评估
+type DifficultyEvaluator interface {
+	Name() string
+	Evaluate(ctx context.Context, evalReq *models.EvaluatorRequest) (*models.EvaluatorResponse, error)
+}
+
+// newStrategy 根据配置构建评估策略
+func newStrategy(cfg *models.EvaluatorConfig, client *Client) (DifficultyEvaluator, error) {
+	if cfg.Strategy != "chain" {
+		return newSingleStrategy(cfg.Strategy, cfg, client)
+	}
+
+	chain := &chainEvaluator{}
+	for _, name := range cfg.Chain {
+		strategy, err := newSingleStrategy(name, cfg, client)
+		if err != nil {
+			return nil, err
+		}
+		chain.strategies = append(chain.strategies, strategy)
+	}
+	return chain, nil
+}
+
+// newSingleStrategy 构建单个（非 chain）评估策略
+func newSingleStrategy(name string, cfg *models.EvaluatorConfig, client *Client) (DifficultyEvaluator, error) {
+	switch name {
+	case "", "llm":
+		return &llmEvaluator{client: client}, nil
+	case "rules":
+		return newRulesEvaluator(&cfg.Rules)
+	case "heuristic":
+		return &heuristicEvaluator{cfg: cfg.Heuristic}, nil
+	case "classifier":
+		return newClassifierEvaluator(&cfg.Classifier), nil
+	case "fixed":
+		return &fixedEvaluator{level: cfg.FixedLevel}, nil
+	}
+	return nil, fmt.Errorf("不支持的评估策略: %s", name)
+}
+
+// llmEvaluator 调用决策者服务（LLM）评估
+type llmEvaluator struct {
+	client *Client
+}
+
+func (e *llmEvaluator) Name() string { return "llm" }
+
+func (e *llmEvaluator) Evaluate(ctx context.Context, evalReq *models.EvaluatorRequest) (*models.EvaluatorResponse, error) {
+	return e.client.evaluateWithLLM(ctx, evalReq)
+}
+
+// fixedEvaluator 始终返回固定难度等级，用于测试
+type fixedEvaluator struct {
+	level int
+}
+
+func (e *fixedEvaluator) Name() string { return "fixed" }
+
+func (e *fixedEvaluator) Evaluate(ctx context.Context, evalReq *models.EvaluatorRequest) (*models.EvaluatorResponse, error) {
+	return &models.EvaluatorResponse{
+		DifficultyLevel: e.level,
+		Reasoning:       "固定难度等级",
+	}, nil
+}
+
+// chainEvaluator 依次尝试多个策略，直到某个策略给出判定
+// 单个策略出错时记录日志并继续，全部未判定时返回最后一个错误
+type chainEvaluator struct {
+	strategies []DifficultyEvaluator
+}
+
+func (e *chainEvaluator) Name() string {
+	names := make([]string, 0, len(e.strategies))
+	for _, strategy := range e.strategies {
+		names = append(names, strategy.Name())
+	}
+	return "chain(" + strings.Join(names, ",") + ")"
+}
+
+func (e *chainEvaluator) Evaluate(ctx context.Context, evalReq *models.EvaluatorRequest) (*models.EvaluatorResponse, error) {
+	var lastErr error
+	for _, strategy := range e.strategies {
+		response, err := strategy.Evaluate(ctx, evalReq)
+		if err != nil {
+			logger.LogWarn("评估策略失败，尝试下一个策略", "strategy", strategy.Name(), "error", err)
+			lastErr = err
+			continue
+		}
+		if response != nil {
+			if response.Strategy == "" {
+				response.Strategy = strategy.Name()
+			}
+			return response, nil
+		}
+	}
+	return nil, lastErr
+}
@@ -0,0 +1,185 @@
+package health
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/logger"
+)
+
+// State 熔断器状态
+type State string
+
+const (
+	StateClosed   State = "closed"    // 正常，请求全部放行
+	StateOpen     State = "open"      // 熔断，请求全部跳过
+	StateHalfOpen State = "half_open" // 冷却结束，放行少量试探请求
+)
+
+// BreakerStatus 熔断器状态快照（用于 /status）
+type BreakerStatus struct {
+	State               State     `json:"state"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	TotalSuccesses      int64     `json:"total_successes"`
+	TotalFailures       int64     `json:"total_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+	LastLatencyMs       int64     `json:"last_latency_ms"`
+	OpenedAt            time.Time `json:"opened_at,omitempty"`
+}
+
+// breaker 单个服务的熔断器
+type breaker struct {
+	status        BreakerStatus
+	halfOpenCalls int // 半开状态下已放行的试探请求数
+}
+
+// Breakers 按服务ID管理熔断器（被动健康跟踪）
+// 连续失败或连续慢请求达到阈值后熔断，冷却时间结束后进入半开状态试探
+type Breakers struct {
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+// NewBreakers 创建熔断器集合
+func NewBreakers() *Breakers {
+	return &Breakers{
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// get 获取（或创建）服务的熔断器，调用方需持有锁
+func (b *Breakers) get(serviceID string) *breaker {
+	br, ok := b.breakers[serviceID]
+	if !ok {
+		br = &breaker{status: BreakerStatus{State: StateClosed}}
+		b.breakers[serviceID] = br
+	}
+	return br
+}
+
+// IsAvailable 判断服务当前是否可被路由（不占用半开试探名额）
+func (b *Breakers) IsAvailable(serviceID string) bool {
+	cfg := config.Get().CircuitBreaker
+	if !cfg.Enabled {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(serviceID)
+	switch br.status.State {
+	case StateOpen:
+		return time.Since(br.status.OpenedAt) >= time.Duration(cfg.OpenSeconds)*time.Second
+	case StateHalfOpen:
+		return br.halfOpenCalls < cfg.HalfOpenMaxRequests
+	}
+	return true
+}
+
+// Allow 在实际发送请求前调用，判断是否放行
+// 冷却结束的熔断器在此转为半开状态并占用一个试探名额
+func (b *Breakers) Allow(serviceID string) bool {
+	cfg := config.Get().CircuitBreaker
+	if !cfg.Enabled {
+		return true
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(serviceID)
+	switch br.status.State {
+	case StateOpen:
+		if time.Since(br.status.OpenedAt) < time.Duration(cfg.OpenSeconds)*time.Second {
+			return false
+		}
+		b.transition(serviceID, br, StateHalfOpen)
+		br.halfOpenCalls = 1
+		return true
+	case StateHalfOpen:
+		if br.halfOpenCalls >= cfg.HalfOpenMaxRequests {
+			return false
+		}
+		br.halfOpenCalls++
+		return true
+	}
+	return true
+}
+
+// RecordSuccess 记录一次成功请求，latency 为收到响应头的耗时
+// 超过慢请求阈值的成功请求按失败处理
+func (b *Breakers) RecordSuccess(serviceID string, latency time.Duration) {
+	cfg := config.Get().CircuitBreaker
+	if !cfg.Enabled {
+		return
+	}
+
+	if cfg.SlowCallThresholdMs > 0 && latency > time.Duration(cfg.SlowCallThresholdMs)*time.Millisecond {
+		b.RecordFailure(serviceID, "slow call: "+latency.String())
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(serviceID)
+	br.status.TotalSuccesses++
+	br.status.ConsecutiveFailures = 0
+	br.status.LastLatencyMs = latency.Milliseconds()
+	if br.status.State != StateClosed {
+		b.transition(serviceID, br, StateClosed)
+	}
+}
+
+// RecordFailure 记录一次失败请求（连接错误、5xx、429、慢请求等）
+func (b *Breakers) RecordFailure(serviceID, reason string) {
+	cfg := config.Get().CircuitBreaker
+	if !cfg.Enabled {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	br := b.get(serviceID)
+	br.status.TotalFailures++
+	br.status.ConsecutiveFailures++
+	br.status.LastError = reason
+
+	// 半开试探失败立即重新熔断；关闭状态下连续失败达到阈值时熔断
+	if br.status.State == StateHalfOpen ||
+		(br.status.State == StateClosed && br.status.ConsecutiveFailures >= cfg.FailureThreshold) {
+		b.transition(serviceID, br, StateOpen)
+	}
+}
+
+// transition 切换熔断器状态，调用方需持有锁
+func (b *Breakers) transition(serviceID string, br *breaker, state State) {
+	logger.LogWarn("熔断器状态变化",
+		"service_id", serviceID,
+		"from", br.status.State,
+		"to", state,
+		"consecutive_failures", br.status.ConsecutiveFailures,
+		"last_error", br.status.LastError,
+	)
+
+	br.status.State = state
+	br.halfOpenCalls = 0
+	if state == StateOpen {
+		br.status.OpenedAt = time.Now()
+	}
+}
+
+// Snapshot 获取所有熔断器状态
+func (b *Breakers) Snapshot() map[string]BreakerStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	snapshot := make(map[string]BreakerStatus, len(b.breakers))
+	for id, br := range b.breakers {
+		snapshot[id] = br.status
+	}
+	return snapshot
+}
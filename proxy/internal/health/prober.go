@@ -0,0 +1,346 @@
+package health
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/models"
+	"github.com/ethan/claude-proxy/internal/openai"
+	"github.com/ethan/claude-proxy/internal/transform"
+)
+
+// ProbeResult 单次探测结果
+type ProbeResult struct {
+	Time       time.Time `json:"time"`
+	Success    bool      `json:"success"`
+	LatencyMs  int64     `json:"latency_ms"`
+	StatusCode int       `json:"status_code,omitempty"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// ServiceHealth 服务健康状态（用于 /services/health）
+type ServiceHealth struct {
+	ServiceID           string        `json:"service_id"`
+	Name                string        `json:"name"`
+	Role                string        `json:"role"`
+	Healthy             bool          `json:"healthy"`
+	ConsecutiveFailures int           `json:"consecutive_failures"`
+	Availability        float64       `json:"availability"` // 历史窗口内成功率（0-1）
+	AvgLatencyMs        int64         `json:"avg_latency_ms"`
+	LastCheck           time.Time     `json:"last_check"`
+	History             []ProbeResult `json:"history"`
+}
+
+// serviceHistory 单个服务的探测历史
+type serviceHistory struct {
+	results             []ProbeResult
+	consecutiveFailures int
+}
+
+// Prober 后台主动健康探测
+// 定期探测当前配置中的每个服务，记录延迟和可用性历史，
+// 连续失败达到阈值的服务在路由时会被跳过
+type Prober struct {
+	mu       sync.RWMutex
+	services map[string]*serviceHistory
+
+	runMu    sync.Mutex
+	stopChan chan struct{} // 后台探测运行中时非 nil
+}
+
+// NewProber 创建健康探测器
+func NewProber() *Prober {
+	return &Prober{
+		services: make(map[string]*serviceHistory),
+	}
+}
+
+// Start 启动后台探测（未启用或已在运行时直接返回）
+func (p *Prober) Start() {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+
+	cfg := config.Get().HealthCheck
+	if !cfg.Enabled || p.stopChan != nil {
+		return
+	}
+	stopChan := make(chan struct{})
+	p.stopChan = stopChan
+
+	logger.LogInfo("启动服务健康探测",
+		"interval_seconds", cfg.IntervalSeconds,
+		"timeout_seconds", cfg.TimeoutSeconds,
+	)
+
+	go func() {
+		ticker := time.NewTicker(time.Duration(cfg.IntervalSeconds) * time.Second)
+		defer ticker.Stop()
+
+		// 启动时立即探测一次
+		p.ProbeAll()
+
+		for {
+			select {
+			case <-ticker.C:
+				p.ProbeAll()
+			case <-stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// Stop 停止后台探测
+func (p *Prober) Stop() {
+	p.runMu.Lock()
+	defer p.runMu.Unlock()
+
+	if p.stopChan != nil {
+		close(p.stopChan)
+		p.stopChan = nil
+	}
+}
+
+// Restart 按当前配置重新启动后台探测（配置热加载后调用）
+func (p *Prober) Restart() {
+	p.Stop()
+	p.Start()
+}
+
+// ProbeAll 并发探测所有服务
+func (p *Prober) ProbeAll() {
+	services := config.Get().Services
+
+	var wg sync.WaitGroup
+	for i := range services {
+		wg.Add(1)
+		go func(svc models.Service) {
+			defer wg.Done()
+			p.record(svc.ID, p.probe(&svc))
+		}(services[i])
+	}
+	wg.Wait()
+}
+
+// probe 探测单个服务
+// 配置了 probe_path 时发送 GET 请求，否则发送 max_tokens=1 的最小 Messages 请求（会按 token 计费）
+func (p *Prober) probe(svc *models.Service) ProbeResult {
+	cfg := config.Get().HealthCheck
+	client := &http.Client{Timeout: time.Duration(cfg.TimeoutSeconds) * time.Second}
+
+	req, err := p.buildProbeRequest(svc)
+	if err != nil {
+		return ProbeResult{Time: time.Now(), Error: err.Error()}
+	}
+
+	start := time.Now()
+	resp, err := client.Do(req)
+	latency := time.Since(start)
+	if err != nil {
+		return ProbeResult{Time: start, LatencyMs: latency.Milliseconds(), Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	result := ProbeResult{
+		Time:       start,
+		LatencyMs:  latency.Milliseconds(),
+		StatusCode: resp.StatusCode,
+	}
+
+	// 5xx、429 以及认证失败视为不可用；400/404/422 说明探测请求被拒绝（如模型名不被支持、地址错误），
+	// 同样的请求转发过去也会失败，视为不可用
+	switch {
+	case resp.StatusCode >= 500, resp.StatusCode == http.StatusTooManyRequests:
+		result.Error = fmt.Sprintf("status=%d", resp.StatusCode)
+	case resp.StatusCode == http.StatusBadRequest, resp.StatusCode == http.StatusNotFound, resp.StatusCode == http.StatusUnprocessableEntity:
+		result.Error = fmt.Sprintf("探测请求被拒绝: status=%d", resp.StatusCode)
+	case (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && svc.AuthScheme != models.AuthSchemePassthrough:
+		// passthrough 的服务探测时不带凭据，认证失败说明服务可达
+		result.Error = fmt.Sprintf("认证失败: status=%d", resp.StatusCode)
+	default:
+		result.Success = true
+	}
+
+	return result
+}
+
+// buildProbeRequest 构建探测请求
+func (p *Prober) buildProbeRequest(svc *models.Service) (*http.Request, error) {
+	if svc.ProbePath != "" {
+		probeURL, err := resolveProbeURL(svc.URL, svc.ProbePath)
+		if err != nil {
+			return nil, err
+		}
+		req, err := http.NewRequest("GET", probeURL, nil)
+		if err != nil {
+			return nil, fmt.Errorf("创建探测请求失败: %v", err)
+		}
+		setProbeCredential(req, svc)
+		return req, nil
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"model":      config.Get().HealthCheck.ProbeModel,
+		"max_tokens": 1,
+		"messages": []map[string]string{
+			{"role": "user", "content": "ping"},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("序列化探测请求失败: %v", err)
+	}
+	// 与转发的请求一样经过服务的转换（model_map 等）和协议转换，否则 openai 协议的服务总是返回 400
+	body, err = transform.Apply(body, transform.ForService(svc))
+	if err != nil {
+		return nil, fmt.Errorf("转换探测请求失败: %v", err)
+	}
+	if svc.Protocol == models.ProtocolOpenAI {
+		if body, err = openai.TranslateRequest(body); err != nil {
+			return nil, fmt.Errorf("转换探测请求失败: %v", err)
+		}
+	}
+
+	req, err := http.NewRequest("POST", svc.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("创建探测请求失败: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	setProbeCredential(req, svc)
+	if svc.Protocol != models.ProtocolOpenAI {
+		req.Header.Set("anthropic-version", "2023-06-01")
+	}
+	return req, nil
+}
+
+// setProbeCredential 按 auth_scheme 设置探测请求的认证头（passthrough 的服务没有可用的凭据）
+func setProbeCredential(req *http.Request, svc *models.Service) {
+	if name, value := svc.Credential(); name != "" {
+		req.Header.Set(name, value)
+	}
+}
+
+// resolveProbeURL 解析探测地址：完整URL直接使用，否则替换服务URL的路径部分
+func resolveProbeURL(serviceURL, probePath string) (string, error) {
+	if strings.HasPrefix(probePath, "http://") || strings.HasPrefix(probePath, "https://") {
+		return probePath, nil
+	}
+
+	u, err := url.Parse(serviceURL)
+	if err != nil {
+		return "", fmt.Errorf("解析服务URL失败: %v", err)
+	}
+	ref, err := url.Parse(probePath)
+	if err != nil {
+		return "", fmt.Errorf("解析探测路径失败: %v", err)
+	}
+	u.Path = ref.Path
+	u.RawQuery = ref.RawQuery
+	return u.String(), nil
+}
+
+// record 记录探测结果
+func (p *Prober) record(serviceID string, result ProbeResult) {
+	cfg := config.Get().HealthCheck
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	history, ok := p.services[serviceID]
+	if !ok {
+		history = &serviceHistory{}
+		p.services[serviceID] = history
+	}
+
+	wasHealthy := history.consecutiveFailures < cfg.UnhealthyThreshold
+	if result.Success {
+		history.consecutiveFailures = 0
+	} else {
+		history.consecutiveFailures++
+	}
+	isHealthy := history.consecutiveFailures < cfg.UnhealthyThreshold
+
+	history.results = append(history.results, result)
+	if len(history.results) > cfg.HistorySize {
+		history.results = history.results[len(history.results)-cfg.HistorySize:]
+	}
+
+	if wasHealthy != isHealthy {
+		logger.LogWarn("服务健康状态变化",
+			"service_id", serviceID,
+			"healthy", isHealthy,
+			"consecutive_failures", history.consecutiveFailures,
+			"error", result.Error,
+		)
+	}
+}
+
+// IsHealthy 判断服务是否健康（未启用探测或尚无探测结果时视为健康）
+func (p *Prober) IsHealthy(serviceID string) bool {
+	cfg := config.Get().HealthCheck
+	if !cfg.Enabled {
+		return true
+	}
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	history, ok := p.services[serviceID]
+	if !ok {
+		return true
+	}
+	return history.consecutiveFailures < cfg.UnhealthyThreshold
+}
+
+// Snapshot 获取所有服务的健康状态（按配置顺序）
+func (p *Prober) Snapshot() []ServiceHealth {
+	current := config.Get()
+	cfg := current.HealthCheck
+
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+
+	snapshot := make([]ServiceHealth, 0, len(current.Services))
+	for _, svc := range current.Services {
+		status := ServiceHealth{
+			ServiceID: svc.ID,
+			Name:      svc.Name,
+			Role:      svc.Role,
+			Healthy:   true,
+			History:   []ProbeResult{},
+		}
+
+		if history, ok := p.services[svc.ID]; ok && len(history.results) > 0 {
+			var successCount int
+			var totalLatency int64
+			for _, r := range history.results {
+				if r.Success {
+					successCount++
+					totalLatency += r.LatencyMs
+				}
+			}
+
+			status.Healthy = history.consecutiveFailures < cfg.UnhealthyThreshold
+			status.ConsecutiveFailures = history.consecutiveFailures
+			status.Availability = float64(successCount) / float64(len(history.results))
+			if successCount > 0 {
+				status.AvgLatencyMs = totalLatency / int64(successCount)
+			}
+			status.LastCheck = history.results[len(history.results)-1].Time
+			status.History = append(status.History, history.results...)
+		}
+
+		snapshot = append(snapshot, status)
+	}
+
+	return snapshot
+}
@@ -0,0 +1,371 @@
+package ledger
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/usage"
+	bolt "go.etcd.io/bbolt"
+)
+
+// requestsBucket 请求记录所在的 bucket
+var requestsBucket = []byte("requests")
+
+// Record 一次代理请求的完整记录
+type Record struct {
+	ID              string      `json:"id"`
+	Timestamp       time.Time   `json:"timestamp"`
+	UserID          string      `json:"user_id"`
+	SessionID       string      `json:"session_id"`
+	Model           string      `json:"model"` // 客户端请求的模型
+	Stream          bool        `json:"stream"`
+	Warmup          bool        `json:"warmup,omitempty"`
+	DifficultyLevel int         `json:"difficulty_level"` // 0 表示未经过评估（如 Warmup）
+	Reasoning       string      `json:"reasoning,omitempty"`
+	Strategy        string      `json:"strategy,omitempty"`       // 给出判定的评估策略
+	EscalatedFrom   int         `json:"escalated_from,omitempty"` // 因上游失败升级前的难度等级
+	Intent          string      `json:"intent,omitempty"`         // 提取出的用户意图（截断）
+	ServiceID       string      `json:"service_id"`               // 最终处理请求的服务
+	StatusCode      int         `json:"status_code"`
+	Error           string      `json:"error,omitempty"`
+	LatencyMs       int64       `json:"latency_ms"`
+	Usage           usage.Usage `json:"usage"`
+	Cost            float64     `json:"cost"`
+}
+
+// Filter 查询条件，零值字段表示不限制
+type Filter struct {
+	Since     time.Time
+	Until     time.Time
+	UserID    string
+	SessionID string
+	ServiceID string
+	Limit     int // 最多返回条数，0 表示不限制
+}
+
+// match 判断记录是否满足除时间范围以外的条件
+func (f Filter) match(rec *Record) bool {
+	if f.UserID != "" && rec.UserID != f.UserID {
+		return false
+	}
+	if f.SessionID != "" && rec.SessionID != f.SessionID {
+		return false
+	}
+	if f.ServiceID != "" && rec.ServiceID != f.ServiceID {
+		return false
+	}
+	return true
+}
+
+// Summary 聚合统计
+type Summary struct {
+	Requests     int64            `json:"requests"`
+	Errors       int64            `json:"errors"`
+	Usage        usage.Usage      `json:"usage"`
+	Cost         float64          `json:"cost"`
+	ByLevel      map[int]int64    `json:"by_level"`
+	ByService    map[string]int64 `json:"by_service"`
+	AvgLatencyMs int64            `json:"avg_latency_ms"`
+}
+
+// Store 基于 bbolt 的本地请求记录存储
+// key 为 8 字节大端纳秒时间戳 + 8 字节序号，按时间有序，便于范围查询和清理
+type Store struct {
+	db       *bolt.DB
+	seq      uint64
+	stopChan chan struct{}
+}
+
+// Open 打开（或创建）记录数据库
+func Open(path string) (*Store, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("创建记录目录失败: %v", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("打开请求记录数据库失败: %v", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(requestsBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("初始化请求记录数据库失败: %v", err)
+	}
+
+	return &Store{
+		db:       db,
+		stopChan: make(chan struct{}),
+	}, nil
+}
+
+// OpenReadOnly 以只读方式打开已有的记录数据库（供离线工具使用）
+// bbolt 使用文件锁，代理服务运行期间会等待超时
+func OpenReadOnly(path string) (*Store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second, ReadOnly: true})
+	if err != nil {
+		return nil, fmt.Errorf("打开请求记录数据库失败（代理服务运行时数据库被锁定）: %v", err)
+	}
+
+	err = db.View(func(tx *bolt.Tx) error {
+		if tx.Bucket(requestsBucket) == nil {
+			return fmt.Errorf("数据库中没有请求记录: %s", path)
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &Store{
+		db:       db,
+		stopChan: make(chan struct{}),
+	}, nil
+}
+
+// Close 关闭数据库
+func (s *Store) Close() error {
+	select {
+	case <-s.stopChan:
+	default:
+		close(s.stopChan)
+	}
+	return s.db.Close()
+}
+
+// makeKey 生成有序 key
+func (s *Store) makeKey(t time.Time) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], atomic.AddUint64(&s.seq, 1))
+	return key
+}
+
+// timeKey 生成某一时刻的起始 key（用于范围查询）
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	return key
+}
+
+// Append 追加一条记录，ID 为空时自动生成
+func (s *Store) Append(rec *Record) error {
+	if rec.Timestamp.IsZero() {
+		rec.Timestamp = time.Now()
+	}
+	key := s.makeKey(rec.Timestamp)
+	if rec.ID == "" {
+		rec.ID = hex.EncodeToString(key)
+	}
+
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("序列化请求记录失败: %v", err)
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(requestsBucket).Put(key, data)
+	})
+}
+
+// Get 按 ID 获取单条记录
+func (s *Store) Get(id string) (*Record, error) {
+	key, err := hex.DecodeString(id)
+	if err != nil || len(key) != 16 {
+		return nil, fmt.Errorf("无效的记录ID: %s", id)
+	}
+
+	var rec *Record
+	err = s.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(requestsBucket).Get(key)
+		if data == nil {
+			return fmt.Errorf("记录不存在: %s", id)
+		}
+		rec = &Record{}
+		return json.Unmarshal(data, rec)
+	})
+	return rec, err
+}
+
+// Query 按条件查询记录，按时间倒序（最新的在前）
+func (s *Store) Query(f Filter) ([]Record, error) {
+	records := []Record{}
+	err := s.each(f, func(rec *Record) bool {
+		records = append(records, *rec)
+		return f.Limit <= 0 || len(records) < f.Limit
+	})
+	return records, err
+}
+
+// Summarize 按条件汇总统计
+func (s *Store) Summarize(f Filter) (*Summary, error) {
+	summary := &Summary{
+		ByLevel:   make(map[int]int64),
+		ByService: make(map[string]int64),
+	}
+	var totalLatency int64
+
+	f.Limit = 0
+	err := s.each(f, func(rec *Record) bool {
+		summary.Requests++
+		if rec.Error != "" || rec.StatusCode >= 400 {
+			summary.Errors++
+		}
+		summary.Usage.Add(rec.Usage)
+		summary.Cost += rec.Cost
+		summary.ByLevel[rec.DifficultyLevel]++
+		if rec.ServiceID != "" {
+			summary.ByService[rec.ServiceID]++
+		}
+		totalLatency += rec.LatencyMs
+		return true
+	})
+	if summary.Requests > 0 {
+		summary.AvgLatencyMs = totalLatency / summary.Requests
+	}
+	return summary, err
+}
+
+// each 按时间倒序遍历满足条件的记录，fn 返回 false 时停止
+func (s *Store) each(f Filter, fn func(rec *Record) bool) error {
+	return s.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(requestsBucket).Cursor()
+
+		// 定位到时间上限之后的第一条，再向前遍历
+		var k, v []byte
+		if f.Until.IsZero() {
+			k, v = c.Last()
+		} else {
+			k, v = c.Seek(timeKey(f.Until))
+			if k == nil {
+				k, v = c.Last()
+			} else {
+				k, v = c.Prev()
+			}
+		}
+
+		sinceNano := uint64(0)
+		if !f.Since.IsZero() {
+			sinceNano = uint64(f.Since.UnixNano())
+		}
+
+		for ; k != nil; k, v = c.Prev() {
+			if binary.BigEndian.Uint64(k[:8]) < sinceNano {
+				break
+			}
+
+			var rec Record
+			if err := json.Unmarshal(v, &rec); err != nil {
+				continue
+			}
+			if !f.match(&rec) {
+				continue
+			}
+			if !fn(&rec) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// Prune 删除指定时间之前的记录，返回删除条数
+func (s *Store) Prune(before time.Time) (int, error) {
+	deleted := 0
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(requestsBucket).Cursor()
+		limit := timeKey(before)
+		for k, _ := c.First(); k != nil && string(k) < string(limit); k, _ = c.Next() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	return deleted, err
+}
+
+// StartRetention 启动后台清理，每小时删除超过保留天数的记录
+func (s *Store) StartRetention(retentionDays int) {
+	if retentionDays <= 0 {
+		return
+	}
+
+	prune := func() {
+		before := time.Now().AddDate(0, 0, -retentionDays)
+		deleted, err := s.Prune(before)
+		if err != nil {
+			logger.LogError("清理过期请求记录失败", err)
+			return
+		}
+		if deleted > 0 {
+			logger.LogInfo("已清理过期请求记录", "deleted", deleted, "retention_days", retentionDays)
+		}
+	}
+
+	go func() {
+		prune()
+
+		ticker := time.NewTicker(time.Hour)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				prune()
+			case <-s.stopChan:
+				return
+			}
+		}
+	}()
+}
+
+// ParseFilter 从查询参数构造 Filter（时间支持 RFC3339 或 Unix 秒）
+func ParseFilter(get func(key string) string) (Filter, error) {
+	f := Filter{
+		UserID:    get("user_id"),
+		SessionID: get("session_id"),
+		ServiceID: get("service_id"),
+	}
+
+	parseTime := func(value string) (time.Time, error) {
+		if value == "" {
+			return time.Time{}, nil
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			return t, nil
+		}
+		sec, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("无效的时间: %s", value)
+		}
+		return time.Unix(sec, 0), nil
+	}
+
+	var err error
+	if f.Since, err = parseTime(get("since")); err != nil {
+		return f, err
+	}
+	if f.Until, err = parseTime(get("until")); err != nil {
+		return f, err
+	}
+	if limit := get("limit"); limit != "" {
+		if f.Limit, err = strconv.Atoi(limit); err != nil {
+			return f, fmt.Errorf("无效的 limit: %s", limit)
+		}
+	}
+
+	return f, nil
+}
@@ -98,7 +98,8 @@ func LogRequest(userID, sessionID, method, path string, requestBody interface{},
 }
 
 // LogEvaluatorRequest 记录决策请求
-func LogEvaluatorRequest(userID, sessionID string, difficultyLevel int, reasoning string, duration time.Duration) {
+// strategy 与 intent 同时写入，日志可作为离线分类器的训练样本
+func LogEvaluatorRequest(userID, sessionID string, difficultyLevel int, reasoning, strategy, intent string, duration time.Duration) {
 	if SugarLogger == nil {
 		return
 	}
@@ -108,10 +109,47 @@ func LogEvaluatorRequest(userID, sessionID string, difficultyLevel int, reasonin
 		"session_id", sessionID,
 		"difficulty_level", difficultyLevel,
 		"reasoning", reasoning,
+		"strategy", strategy,
+		"intent", intent,
 		"duration_ms", duration.Milliseconds(),
 	)
 }
 
+// LogServiceSwitch 记录目标服务自动切换
+func LogServiceSwitch(userID, sessionID string, difficultyLevel int, fromService, toService, reason string) {
+	if SugarLogger == nil {
+		return
+	}
+
+	SugarLogger.Warnw("Service Switch",
+		"user_id", userID,
+		"session_id", sessionID,
+		"difficulty_level", difficultyLevel,
+		"from_service", fromService,
+		"to_service", toService,
+		"reason", reason,
+	)
+}
+
+// LogUsage 记录请求的 token 用量和费用
+func LogUsage(userID, sessionID, serviceID string, difficultyLevel int, inputTokens, outputTokens, cacheReadTokens, cacheCreationTokens int64, cost float64) {
+	if SugarLogger == nil {
+		return
+	}
+
+	SugarLogger.Infow("Token Usage",
+		"user_id", userID,
+		"session_id", sessionID,
+		"service_id", serviceID,
+		"difficulty_level", difficultyLevel,
+		"input_tokens", inputTokens,
+		"output_tokens", outputTokens,
+		"cache_read_input_tokens", cacheReadTokens,
+		"cache_creation_input_tokens", cacheCreationTokens,
+		"cost", cost,
+	)
+}
+
 // LogError 记录错误
 func LogError(message string, err error, fields ...interface{}) {
 	if SugarLogger == nil {
@@ -0,0 +1,140 @@
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "cce"
+
+// 延迟直方图的桶（秒），覆盖从几十毫秒到一分钟
+var latencyBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2, 5, 10, 20, 30, 60}
+
+var (
+	registry = prometheus.NewRegistry()
+
+	// RequestsTotal 代理请求数，按难度等级、最终服务和状态码统计
+	RequestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Proxied requests by difficulty level, service and status code.",
+	}, []string{"level", "service", "status"})
+
+	// EvaluatorDuration 决策者评估耗时（含重试）
+	EvaluatorDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "evaluator_duration_seconds",
+		Help:      "Time spent evaluating request difficulty, including retries.",
+		Buckets:   latencyBuckets,
+	}, []string{"result"})
+
+	// EvaluatorDecisions 按评估策略统计的难度判定次数
+	EvaluatorDecisions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evaluator_decisions_total",
+		Help:      "Difficulty decisions by the evaluator strategy that made them.",
+	}, []string{"strategy"})
+
+	// Escalations 因上游失败升级难度等级的次数
+	Escalations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "escalations_total",
+		Help:      "Requests retried at a higher difficulty level after an upstream failure.",
+	}, []string{"from_level", "to_level"})
+
+	// EvaluatorRetries 决策者请求重试次数
+	EvaluatorRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "evaluator_retries_total",
+		Help:      "Evaluator request retries.",
+	}, []string{"service"})
+
+	// UpstreamTTFB 上游首字节耗时：非流式为响应头到达，流式为首个 SSE 事件到达
+	UpstreamTTFB = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "upstream_ttfb_seconds",
+		Help:      "Upstream time to first byte (first SSE event for streams).",
+		Buckets:   latencyBuckets,
+	}, []string{"service", "stream"})
+
+	// WarmupBroadcasts Warmup 广播结果：success 全部成功，partial 部分成功，failed 全部失败
+	WarmupBroadcasts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "warmup_broadcasts_total",
+		Help:      "Warmup broadcast outcomes (success, partial, failed).",
+	}, []string{"outcome"})
+
+	// WarmupServiceResults Warmup 广播中各服务的结果
+	WarmupServiceResults = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "warmup_service_results_total",
+		Help:      "Per-service warmup results (success, failed).",
+	}, []string{"service", "outcome"})
+
+	// InFlightStreams 正在转发的流式响应数
+	InFlightStreams = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "inflight_streams",
+		Help:      "Streaming responses currently being forwarded.",
+	})
+)
+
+func init() {
+	registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		RequestsTotal,
+		EvaluatorDuration,
+		EvaluatorDecisions,
+		EvaluatorRetries,
+		Escalations,
+		UpstreamTTFB,
+		WarmupBroadcasts,
+		WarmupServiceResults,
+		InFlightStreams,
+	)
+}
+
+// Handler 返回 Prometheus 文本格式的 /metrics 处理器
+func Handler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+// ObserveRequest 记录一次代理请求
+func ObserveRequest(level int, serviceID string, statusCode int) {
+	if serviceID == "" {
+		serviceID = "none"
+	}
+	RequestsTotal.WithLabelValues(strconv.Itoa(level), serviceID, strconv.Itoa(statusCode)).Inc()
+}
+
+// ObserveEvaluator 记录一次难度评估耗时
+func ObserveEvaluator(duration time.Duration, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	EvaluatorDuration.WithLabelValues(result).Observe(duration.Seconds())
+}
+
+// ObserveTTFB 记录上游首字节耗时
+func ObserveTTFB(serviceID string, stream bool, duration time.Duration) {
+	UpstreamTTFB.WithLabelValues(serviceID, strconv.FormatBool(stream)).Observe(duration.Seconds())
+}
+
+// ObserveWarmup 记录一次 Warmup 广播结果
+func ObserveWarmup(successCount, failCount int) {
+	outcome := "success"
+	switch {
+	case successCount == 0:
+		outcome = "failed"
+	case failCount > 0:
+		outcome = "partial"
+	}
+	WarmupBroadcasts.WithLabelValues(outcome).Inc()
+}
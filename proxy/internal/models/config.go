@@ -1,5 +1,14 @@
 package models
 
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
 // Config 代理服务的配置结构
 type Config struct {
 	// 代理监听配置
@@ -9,7 +18,46 @@ type Config struct {
 	Services []Service `json:"services" mapstructure:"services"`
 
 	// 难度等级映射
-	DifficultyMapping map[string]string `json:"difficulty_mapping" mapstructure:"difficulty_mapping"`
+	DifficultyMapping map[string]LevelTargets `json:"difficulty_mapping" mapstructure:"difficulty_mapping"`
+
+	// 负载均衡配置
+	LoadBalancing LoadBalancingConfig `json:"load_balancing" mapstructure:"load_balancing"`
+
+	// 熔断配置
+	CircuitBreaker CircuitBreakerConfig `json:"circuit_breaker" mapstructure:"circuit_breaker"`
+
+	// 主动健康检查配置
+	HealthCheck HealthCheckConfig `json:"health_check" mapstructure:"health_check"`
+
+	// 会话粘滞路由配置
+	StickyRouting StickyRoutingConfig `json:"sticky_routing" mapstructure:"sticky_routing"`
+
+	// 失败升级配置
+	Escalation EscalationConfig `json:"escalation" mapstructure:"escalation"`
+
+	// 手动路由覆盖配置
+	Overrides OverridesConfig `json:"overrides" mapstructure:"overrides"`
+
+	// 按请求模型路由配置
+	ModelRouting ModelRoutingConfig `json:"model_routing" mapstructure:"model_routing"`
+
+	// 费用统计配置
+	Cost CostConfig `json:"cost" mapstructure:"cost"`
+
+	// 请求记录配置
+	Ledger LedgerConfig `json:"ledger" mapstructure:"ledger"`
+
+	// 预算与限流配置
+	Budgets BudgetsConfig `json:"budgets" mapstructure:"budgets"`
+
+	// 链路追踪配置
+	Tracing TracingConfig `json:"tracing" mapstructure:"tracing"`
+
+	// 管理接口配置
+	Admin AdminConfig `json:"admin" mapstructure:"admin"`
+
+	// 代理访问令牌配置
+	Access AccessConfig `json:"access" mapstructure:"access"`
 
 	// 决策者配置
 	Evaluator EvaluatorConfig `json:"evaluator" mapstructure:"evaluator"`
@@ -25,12 +73,18 @@ type Config struct {
 type ProxyConfig struct {
 	Port int `json:"port" mapstructure:"port" default:"27015"`
 
+	// 监听地址，默认只监听本机；需要局域网共享时改为 0.0.0.0 并启用 access 访问令牌
+	ListenAddress string `json:"listen_address" mapstructure:"listen_address" default:"127.0.0.1"`
+
 	// 超时配置（单位：秒）
 	ReadTimeout       int `json:"read_timeout" mapstructure:"read_timeout" default:"1800"`           // 读取超时，默认30分钟
 	WriteTimeout      int `json:"write_timeout" mapstructure:"write_timeout" default:"1800"`         // 写入超时，默认30分钟
 	IdleTimeout       int `json:"idle_timeout" mapstructure:"idle_timeout" default:"300"`            // 空闲超时，默认5分钟
 	RequestTimeout    int `json:"request_timeout" mapstructure:"request_timeout" default:"1800"`     // 转发请求超时，默认30分钟
 	EvaluatorTimeout  int `json:"evaluator_timeout" mapstructure:"evaluator_timeout" default:"30"`   // 评估器超时，默认30秒
+
+	// 允许跨域访问的来源（如 "https://console.example.com"），默认不允许任何跨域请求
+	CORSAllowedOrigins []string `json:"cors_allowed_origins,omitempty" mapstructure:"cors_allowed_origins"`
 }
 
 // Service 服务配置
@@ -38,9 +92,515 @@ type Service struct {
 	ID              string `json:"id" mapstructure:"id"`
 	Name            string `json:"name" mapstructure:"name"`
 	URL             string `json:"url" mapstructure:"url"`                             // 包含域名/IP和路径
-	APIKey          string `json:"api_key" mapstructure:"api_key"`                     // Bearer token
+	APIKey          string `json:"api_key" mapstructure:"api_key"`                     // 上游 API Key，按 auth_scheme 发送；可写为密钥引用（env:NAME、file:/path、cmd:...）
 	Role            string `json:"role" mapstructure:"role"`                           // "evaluator" 或 "executor"
 	SupportsThinking bool   `json:"supports_thinking" mapstructure:"supports_thinking"` // 是否支持thinking模式（默认true）
+	ProbePath       string `json:"probe_path,omitempty" mapstructure:"probe_path"`     // 健康探测路径（GET），为空时发送最小 Messages 请求（按 token 计费）
+	Pricing         Pricing `json:"pricing" mapstructure:"pricing"`                     // 价格表，用于计算每个请求的费用
+	ModelMap        map[string]string `json:"model_map,omitempty" mapstructure:"model_map"` // 转发时的模型名映射：请求的模型 -> 该服务的模型
+	Transforms      []Transform       `json:"transforms,omitempty" mapstructure:"transforms"` // 转发前的请求体转换，在 supports_thinking 和 model_map 之后执行
+	Protocol        string            `json:"protocol,omitempty" mapstructure:"protocol"`     // 上游协议："anthropic"（默认）或 "openai"（Chat Completions，请求和响应自动转换）
+	AuthScheme      string            `json:"auth_scheme,omitempty" mapstructure:"auth_scheme"` // 认证方式，见 AuthScheme* 常量，默认 bearer
+	AuthHeader      string            `json:"auth_header,omitempty" mapstructure:"auth_header"` // auth_scheme 为 header 时携带 api_key 的请求头名称
+
+	resolvedAPIKey string // api_key 为密钥引用时解析后的值，不参与序列化（写回配置文件时保留引用）
+}
+
+// Key 获取实际使用的 API Key（密钥引用已解析）
+func (s *Service) Key() string {
+	if s.resolvedAPIKey != "" {
+		return s.resolvedAPIKey
+	}
+	return s.APIKey
+}
+
+// SetResolvedKey 设置 api_key 解析后的值（由配置加载时调用）
+func (s *Service) SetResolvedKey(key string) {
+	s.resolvedAPIKey = key
+}
+
+// 上游认证方式
+const (
+	AuthSchemeBearer      = "bearer"      // Authorization: Bearer <api_key>
+	AuthSchemeAPIKey      = "x-api-key"   // x-api-key: <api_key>（Anthropic 官方API）
+	AuthSchemeHeader      = "header"      // <auth_header>: <api_key>
+	AuthSchemePassthrough = "passthrough" // 透传客户端自己的 Authorization / x-api-key，不使用 api_key
+)
+
+// Credential 获取发送给该服务的认证头名称和值，passthrough 时返回空字符串
+func (s *Service) Credential() (string, string) {
+	switch s.AuthScheme {
+	case AuthSchemeAPIKey:
+		return "x-api-key", s.Key()
+	case AuthSchemeHeader:
+		return s.AuthHeader, s.Key()
+	case AuthSchemePassthrough:
+		return "", ""
+	}
+	return "Authorization", "Bearer " + s.Key()
+}
+
+// 上游协议
+const (
+	ProtocolAnthropic = "anthropic"
+	ProtocolOpenAI    = "openai"
+)
+
+// MapModel 按 model_map 获取转发给该服务的模型名，未匹配时返回原模型名
+func (s *Service) MapModel(model string) string {
+	return MapModelName(s.ModelMap, model)
+}
+
+// MapModelName 按模型名映射表获取映射后的模型名，未匹配时返回原模型名
+// key 可以是完整模型名、以 * 结尾的前缀（取最长匹配），或单独的 * 表示其余所有模型；匹配不区分大小写
+func MapModelName(modelMap map[string]string, model string) string {
+	if len(modelMap) == 0 {
+		return model
+	}
+
+	lower := strings.ToLower(model)
+	matched, matchedLen := "", -1
+	for key, target := range modelMap {
+		key = strings.ToLower(key)
+		switch {
+		case key == lower:
+			return target
+		case strings.HasSuffix(key, "*") && strings.HasPrefix(lower, strings.TrimSuffix(key, "*")):
+			if len(key) > matchedLen {
+				matched, matchedLen = target, len(key)
+			}
+		}
+	}
+	if matchedLen >= 0 {
+		return matched
+	}
+	return model
+}
+
+// 请求体转换类型
+const (
+	TransformDropFields        = "drop_fields"         // 删除字段
+	TransformSetDefault        = "set_default"         // 字段不存在时设置默认值
+	TransformClampMaxTokens    = "clamp_max_tokens"    // 限制 max_tokens 上限
+	TransformStripCacheControl = "strip_cache_control" // 移除所有 cache_control
+	TransformRemoveToolTypes   = "remove_tool_types"   // 移除指定类型的工具（如 web_search_*）
+	TransformRenameModel       = "rename_model"        // 改写模型名
+)
+
+// Transform 转发前对请求体的一步转换，按配置顺序执行
+type Transform struct {
+	// 转换类型，见 Transform* 常量
+	Type string `json:"type" mapstructure:"type"`
+
+	// drop_fields: 要删除的字段，支持 a.b 形式的嵌套路径
+	Fields []string `json:"fields,omitempty" mapstructure:"fields"`
+
+	// set_default: 字段路径和默认值
+	Field string      `json:"field,omitempty" mapstructure:"field"`
+	Value interface{} `json:"value,omitempty" mapstructure:"value"`
+
+	// clamp_max_tokens: max_tokens 上限
+	Max int `json:"max,omitempty" mapstructure:"max"`
+
+	// remove_tool_types: 要移除的工具类型，支持以 * 结尾的前缀
+	ToolTypes []string `json:"tool_types,omitempty" mapstructure:"tool_types"`
+
+	// rename_model: 固定改写为 to，或按 map 映射（写法同 model_map）
+	To  string            `json:"to,omitempty" mapstructure:"to"`
+	Map map[string]string `json:"map,omitempty" mapstructure:"map"`
+}
+
+// Pricing 价格表（单位：每百万 token 的价格）
+type Pricing struct {
+	InputPerMTok      float64 `json:"input_per_mtok" mapstructure:"input_per_mtok"`
+	OutputPerMTok     float64 `json:"output_per_mtok" mapstructure:"output_per_mtok"`
+	CacheReadPerMTok  float64 `json:"cache_read_per_mtok" mapstructure:"cache_read_per_mtok"`
+	CacheWritePerMTok float64 `json:"cache_write_per_mtok" mapstructure:"cache_write_per_mtok"`
+}
+
+// ServiceTarget 难度等级映射的目标服务
+type ServiceTarget struct {
+	ID     string `json:"id" mapstructure:"id"`
+	Weight int    `json:"weight,omitempty" mapstructure:"weight"` // 权重，未配置时视为1；不允许显式配置为0
+}
+
+// LevelTargets 单个难度等级映射的目标服务列表，顺序即自动切换的优先顺序
+// 配置中可写为单个服务ID、服务ID列表，或带权重的 {id, weight} 列表
+type LevelTargets []ServiceTarget
+
+// UnmarshalJSON 与配置文件一致，支持单个服务ID、服务ID列表和 {id, weight} 列表三种写法
+func (t *LevelTargets) UnmarshalJSON(data []byte) error {
+	var id string
+	if err := json.Unmarshal(data, &id); err == nil {
+		*t = LevelTargets{{ID: id}}
+		return nil
+	}
+
+	var items []json.RawMessage
+	if err := json.Unmarshal(data, &items); err != nil {
+		return fmt.Errorf("无法解析难度映射: %s", string(data))
+	}
+	targets := make(LevelTargets, 0, len(items))
+	for _, item := range items {
+		var target ServiceTarget
+		if err := json.Unmarshal(item, &target.ID); err != nil {
+			if err := json.Unmarshal(item, &target); err != nil {
+				return fmt.Errorf("无法解析难度映射: %s", string(item))
+			}
+			var explicit struct {
+				Weight *int `json:"weight"`
+			}
+			if json.Unmarshal(item, &explicit) == nil && explicit.Weight != nil && *explicit.Weight == 0 {
+				return ZeroWeightError(target.ID)
+			}
+		}
+		targets = append(targets, target)
+	}
+	*t = targets
+	return nil
+}
+
+// ZeroWeightError 显式配置权重为0的错误
+// 未配置的权重解析后同样为0，只能在解析配置时区分，配置文件和管理接口的解析共用该错误
+func ZeroWeightError(serviceID interface{}) error {
+	return fmt.Errorf("服务 %v 的权重不能为0（权重必须为正整数，不配置时视为1；停用服务请从映射中移除）", serviceID)
+}
+
+// IDs 返回目标服务ID列表（保持配置顺序）
+func (t LevelTargets) IDs() []string {
+	ids := make([]string, 0, len(t))
+	for _, target := range t {
+		ids = append(ids, target.ID)
+	}
+	return ids
+}
+
+// LoadBalancingConfig 负载均衡配置
+// 决定同一难度等级服务池内的选择顺序，未选中的服务仍作为自动切换的备选
+type LoadBalancingConfig struct {
+	// 默认策略: failover（按顺序）、round_robin、weighted_random、least_inflight
+	Strategy string `json:"strategy" mapstructure:"strategy" default:"failover"`
+
+	// 按难度等级覆盖默认策略，key 为难度等级 "1"-"5"
+	Levels map[string]string `json:"levels,omitempty" mapstructure:"levels"`
+}
+
+// StrategyFor 获取指定难度等级使用的策略
+func (c LoadBalancingConfig) StrategyFor(level string) string {
+	if strategy, ok := c.Levels[level]; ok && strategy != "" {
+		return strategy
+	}
+	return c.Strategy
+}
+
+// CircuitBreakerConfig 熔断配置（按服务被动跟踪健康状态）
+type CircuitBreakerConfig struct {
+	// 是否启用熔断
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"true"`
+
+	// 连续失败多少次后熔断
+	FailureThreshold int `json:"failure_threshold" mapstructure:"failure_threshold" default:"3"`
+
+	// 慢请求阈值（毫秒），收到响应头的耗时超过该值视为失败，0 表示不检查
+	SlowCallThresholdMs int `json:"slow_call_threshold_ms" mapstructure:"slow_call_threshold_ms" default:"0"`
+
+	// 熔断后的冷却时间（秒），之后进入半开状态
+	OpenSeconds int `json:"open_seconds" mapstructure:"open_seconds" default:"30"`
+
+	// 半开状态下允许的试探请求数
+	HalfOpenMaxRequests int `json:"half_open_max_requests" mapstructure:"half_open_max_requests" default:"1"`
+}
+
+// HealthCheckConfig 主动健康检查配置
+type HealthCheckConfig struct {
+	// 是否启用后台探测
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"false"`
+
+	// 探测间隔（秒）
+	IntervalSeconds int `json:"interval_seconds" mapstructure:"interval_seconds" default:"60"`
+
+	// 单次探测超时（秒）
+	TimeoutSeconds int `json:"timeout_seconds" mapstructure:"timeout_seconds" default:"10"`
+
+	// 连续失败多少次后视为不健康，路由时跳过
+	UnhealthyThreshold int `json:"unhealthy_threshold" mapstructure:"unhealthy_threshold" default:"2"`
+
+	// 每个服务保留的探测历史条数
+	HistorySize int `json:"history_size" mapstructure:"history_size" default:"20"`
+
+	// 未配置 probe_path 时，最小 Messages 探测请求使用的模型
+	ProbeModel string `json:"probe_model" mapstructure:"probe_model" default:"claude-3-haiku-20240307"`
+}
+
+// StickyRoutingConfig 会话粘滞路由配置
+// 任务进行中（工具调用轮次）固定使用任务开始时选中的服务，避免中途换模型导致提示缓存失效和代码风格不一致
+type StickyRoutingConfig struct {
+	// 是否启用
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"false"`
+
+	// 固定后最多沿用多少轮即重新评估，0 表示只在出现新的用户输入时重新评估
+	MaxTurns int `json:"max_turns" mapstructure:"max_turns" default:"20"`
+
+	// 会话空闲超过该时间（秒）后解除固定
+	IdleSeconds int `json:"idle_seconds" mapstructure:"idle_seconds" default:"1800"`
+}
+
+// EscalationConfig 失败升级配置
+// 低等级服务出错、超时或返回疑似失败的响应时，自动在更高一级的服务上重试
+type EscalationConfig struct {
+	// 是否启用
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"false"`
+
+	// 只对不高于该等级的请求升级
+	MaxFromLevel int `json:"max_from_level" mapstructure:"max_from_level" default:"2"`
+
+	// 单个请求最多升级几级
+	MaxSteps int `json:"max_steps" mapstructure:"max_steps" default:"1"`
+
+	// 升级后该会话接下来多少次评估至少为升级后的等级
+	BiasTurns int `json:"bias_turns" mapstructure:"bias_turns" default:"3"`
+}
+
+// OverridesConfig 手动路由覆盖配置
+// 请求头 X-CCE-Level / X-CCE-Service，或提示中的 #cce:5、/model-hint 5 标记可跳过难度评估，
+// 标记在转发前会被移除
+type OverridesConfig struct {
+	// 是否启用
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"false"`
+
+	// 允许使用覆盖的用户ID（取自 metadata.user_id），"*" 表示所有用户
+	AllowedUsers []string `json:"allowed_users,omitempty" mapstructure:"allowed_users"`
+
+	// 是否允许直接指定服务（X-CCE-Service 或标记值为服务ID），关闭时只能指定难度等级
+	AllowService bool `json:"allow_service" mapstructure:"allow_service" default:"true"`
+}
+
+// Allows 判断用户是否允许使用覆盖
+func (c OverridesConfig) Allows(userID string) bool {
+	for _, allowed := range c.AllowedUsers {
+		if allowed == "*" || allowed == userID {
+			return true
+		}
+	}
+	return false
+}
+
+// ModelRoutingConfig 按客户端请求的模型路由
+// 匹配的请求直接使用规则指定的难度等级，不调用评估器，也不参与会话粘滞
+// （例如 Claude Code 用 haiku 发起的标题、摘要等辅助请求）
+type ModelRoutingConfig struct {
+	// 路由规则，按顺序匹配第一条
+	Rules []ModelRoutingRule `json:"rules,omitempty" mapstructure:"rules"`
+}
+
+// ModelRoutingRule 单条模型路由规则
+type ModelRoutingRule struct {
+	// 匹配请求模型名的正则表达式（不区分大小写）
+	Pattern string `json:"pattern" mapstructure:"pattern"`
+
+	// 匹配后使用的难度等级
+	Level int `json:"level" mapstructure:"level"`
+}
+
+// CostConfig 费用统计配置
+type CostConfig struct {
+	// 货币单位，仅用于展示
+	Currency string `json:"currency" mapstructure:"currency" default:"USD"`
+
+	// 基准价格表：假设所有请求都直接发给官方模型时的价格，用于计算节省比例
+	Baseline Pricing `json:"baseline" mapstructure:"baseline"`
+}
+
+// BudgetsConfig 按用户、会话的预算与限流配置
+// 每分钟请求数/token 数和每日/每月费用的硬上限超出时返回 429；费用超过软上限时降级到 downgrade_level
+type BudgetsConfig struct {
+	// 是否启用
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"false"`
+
+	// 计数器持久化文件（bbolt），重启后继续累计
+	StatePath string `json:"state_path" mapstructure:"state_path" default:"./data/budgets.db"`
+
+	// 超过软上限后允许使用的最高难度等级
+	DowngradeLevel int `json:"downgrade_level" mapstructure:"downgrade_level" default:"1"`
+
+	// 每个用户的默认限制（用户取自访问令牌或 metadata.user_id）
+	User BudgetLimits `json:"user" mapstructure:"user"`
+
+	// 每个会话的限制
+	Session BudgetLimits `json:"session" mapstructure:"session"`
+
+	// 单独配置的用户，整体替换默认的 user 限制
+	Users []UserBudget `json:"users,omitempty" mapstructure:"users"`
+}
+
+// BudgetLimits 一组限制，0 表示不限制
+type BudgetLimits struct {
+	// 每分钟请求数
+	RPM int64 `json:"rpm,omitempty" mapstructure:"rpm"`
+
+	// 每分钟 token 数（输入、输出和缓存 token 合计，请求完成后计入）
+	TPM int64 `json:"tpm,omitempty" mapstructure:"tpm"`
+
+	// 每日费用软上限 / 硬上限（按本地时间的自然日）
+	DailySoftCost float64 `json:"daily_soft_cost,omitempty" mapstructure:"daily_soft_cost"`
+	DailyHardCost float64 `json:"daily_hard_cost,omitempty" mapstructure:"daily_hard_cost"`
+
+	// 每月费用软上限 / 硬上限（按本地时间的自然月）
+	MonthlySoftCost float64 `json:"monthly_soft_cost,omitempty" mapstructure:"monthly_soft_cost"`
+	MonthlyHardCost float64 `json:"monthly_hard_cost,omitempty" mapstructure:"monthly_hard_cost"`
+}
+
+// UserBudget 单独配置的用户限制
+type UserBudget struct {
+	User         string `json:"user" mapstructure:"user"`
+	BudgetLimits `mapstructure:",squash"`
+}
+
+// UserLimits 获取用户适用的限制
+func (c BudgetsConfig) UserLimits(userID string) BudgetLimits {
+	for _, u := range c.Users {
+		if u.User == userID {
+			return u.BudgetLimits
+		}
+	}
+	return c.User
+}
+
+// LedgerConfig 本地请求记录配置
+type LedgerConfig struct {
+	// 是否将每个请求写入本地记录库
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"false"`
+
+	// 记录库文件路径（bbolt）
+	Path string `json:"path" mapstructure:"path" default:"./data/ledger.db"`
+
+	// 记录保留天数，0 表示永久保留
+	RetentionDays int `json:"retention_days" mapstructure:"retention_days" default:"30"`
+}
+
+// TracingConfig OpenTelemetry 链路追踪配置
+type TracingConfig struct {
+	// 是否启用链路追踪
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"false"`
+
+	// 导出方式：otlp（OTLP/HTTP）或 stdout（打印到控制台，用于本地调试）
+	Exporter string `json:"exporter" mapstructure:"exporter" default:"otlp"`
+
+	// OTLP/HTTP 接收端地址（host:port）
+	Endpoint string `json:"endpoint" mapstructure:"endpoint" default:"localhost:4318"`
+
+	// 是否使用明文 HTTP 连接接收端
+	Insecure bool `json:"insecure" mapstructure:"insecure" default:"true"`
+
+	// 额外请求头（例如接收端鉴权）
+	Headers map[string]string `json:"headers" mapstructure:"headers"`
+
+	// 上报的服务名
+	ServiceName string `json:"service_name" mapstructure:"service_name" default:"claude-proxy"`
+
+	// 采样比例（0-1）
+	SampleRatio float64 `json:"sample_ratio" mapstructure:"sample_ratio" default:"1.0"`
+}
+
+// AdminConfig 管理接口配置
+type AdminConfig struct {
+	// 是否启用 /admin 管理接口
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"false"`
+
+	// 访问令牌，请求需携带 Authorization: Bearer <token>；可写为密钥引用（env:NAME、file:/path、cmd:...）
+	Token string `json:"token" mapstructure:"token"`
+
+	// 修改默认写回配置文件（单个请求可用 ?persist=false 覆盖）
+	WriteBack bool `json:"write_back" mapstructure:"write_back" default:"true"`
+
+	resolvedToken string // token 为密钥引用时解析后的值
+}
+
+// ResolvedToken 获取实际使用的访问令牌（密钥引用已解析）
+func (c *AdminConfig) ResolvedToken() string {
+	if c.resolvedToken != "" {
+		return c.resolvedToken
+	}
+	return c.Token
+}
+
+// SetResolvedToken 设置 token 解析后的值（由配置加载时调用）
+func (c *AdminConfig) SetResolvedToken(token string) {
+	c.resolvedToken = token
+}
+
+// AccessConfig 代理访问令牌配置
+// 启用后代理请求必须携带令牌（x-api-key、Authorization: Bearer 或 X-CCE-Token），令牌通过 tokens 子命令管理
+type AccessConfig struct {
+	// 是否启用
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"false"`
+
+	// 已签发的令牌（只保存哈希）
+	Tokens []AccessToken `json:"tokens,omitempty" mapstructure:"tokens"`
+}
+
+// AccessToken 访问令牌，对应一个用户及其可使用的难度等级和服务
+type AccessToken struct {
+	// 用户名，作为请求的 user_id（取代 metadata.user_id）
+	User string `json:"user" mapstructure:"user"`
+
+	// 令牌哈希（sha256:<hex>），令牌本身只在签发时显示一次
+	Hash string `json:"hash" mapstructure:"hash"`
+
+	// 允许的难度等级，为空表示全部
+	Levels []int `json:"levels,omitempty" mapstructure:"levels"`
+
+	// 允许的服务ID，为空表示全部
+	Services []string `json:"services,omitempty" mapstructure:"services"`
+
+	// 签发时间（RFC3339）
+	CreatedAt string `json:"created_at,omitempty" mapstructure:"created_at"`
+}
+
+// HashAccessToken 计算令牌哈希（令牌为随机生成的高熵字符串，直接使用 sha256）
+func HashAccessToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+// Matches 判断令牌是否与哈希匹配
+func (t AccessToken) Matches(token string) bool {
+	return subtle.ConstantTimeCompare([]byte(HashAccessToken(token)), []byte(strings.ToLower(t.Hash))) == 1
+}
+
+// ID 令牌的短标识（哈希前8位），用于列出和吊销
+func (t AccessToken) ID() string {
+	hash := strings.TrimPrefix(t.Hash, "sha256:")
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
+
+// AllowsLevel 判断是否允许使用该难度等级
+func (t AccessToken) AllowsLevel(level int) bool {
+	if len(t.Levels) == 0 {
+		return true
+	}
+	for _, allowed := range t.Levels {
+		if allowed == level {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsService 判断是否允许使用该服务
+func (t AccessToken) AllowsService(serviceID string) bool {
+	if len(t.Services) == 0 {
+		return true
+	}
+	for _, allowed := range t.Services {
+		if allowed == serviceID {
+			return true
+		}
+	}
+	return false
 }
 
 // EvaluatorConfig 决策者配置
@@ -59,6 +619,100 @@ type EvaluatorConfig struct {
 
 	// 最大Token数
 	MaxTokens int `json:"max_tokens" mapstructure:"max_tokens" default:"100"`
+
+	// 评估策略：llm（调用决策者服务）、rules、heuristic、classifier、fixed 或 chain
+	Strategy string `json:"strategy" mapstructure:"strategy" default:"llm"`
+
+	// strategy 为 chain 时依次尝试的策略，前一个无法判断时交给下一个
+	Chain []string `json:"chain,omitempty" mapstructure:"chain"`
+
+	// strategy 为 fixed 时直接使用的难度等级（1-5），用于测试
+	FixedLevel int `json:"fixed_level,omitempty" mapstructure:"fixed_level"`
+
+	// 规则评估配置
+	Rules RulesEvaluatorConfig `json:"rules" mapstructure:"rules"`
+
+	// 启发式评估配置
+	Heuristic HeuristicEvaluatorConfig `json:"heuristic" mapstructure:"heuristic"`
+
+	// 离线分类器配置
+	Classifier ClassifierEvaluatorConfig `json:"classifier" mapstructure:"classifier"`
+
+	// 评估结果缓存配置
+	Cache EvaluatorCacheConfig `json:"cache" mapstructure:"cache"`
+}
+
+// EvaluatorCacheConfig 评估结果缓存配置
+// 按会话 + 归一化后的用户意图缓存决策，同一任务内的工具调用轮次无需重复评估
+type EvaluatorCacheConfig struct {
+	// 是否启用缓存
+	Enabled bool `json:"enabled" mapstructure:"enabled" default:"true"`
+
+	// 最大缓存条数，超出时淘汰最久未使用的项
+	MaxEntries int `json:"max_entries" mapstructure:"max_entries" default:"10000"`
+
+	// 缓存有效期（秒）
+	TTLSeconds int `json:"ttl_seconds" mapstructure:"ttl_seconds" default:"600"`
+
+	// 最新的用户消息只包含 tool_result 时，直接复用该会话上一次的决策
+	ReuseOnToolResult bool `json:"reuse_on_tool_result" mapstructure:"reuse_on_tool_result" default:"false"`
+}
+
+// StrategyNames 返回实际使用的评估策略列表（chain 展开为其子策略）
+func (c EvaluatorConfig) StrategyNames() []string {
+	if c.Strategy == "chain" {
+		return c.Chain
+	}
+	return []string{c.Strategy}
+}
+
+// UsesStrategy 判断是否使用了指定的评估策略
+func (c EvaluatorConfig) UsesStrategy(name string) bool {
+	for _, strategy := range c.StrategyNames() {
+		if strategy == name {
+			return true
+		}
+	}
+	return false
+}
+
+// RulesEvaluatorConfig 规则评估配置：对提取出的用户意图做关键词/正则匹配并按等级累计得分
+type RulesEvaluatorConfig struct {
+	// 得分最高的等级达到该分数才判定，否则视为无法判断
+	MinScore float64 `json:"min_score" mapstructure:"min_score" default:"1"`
+
+	// 规则列表
+	Rules []DifficultyRule `json:"rules,omitempty" mapstructure:"rules"`
+}
+
+// DifficultyRule 单条难度规则，keywords 与 pattern 任一命中即为该等级计分
+type DifficultyRule struct {
+	Name     string   `json:"name,omitempty" mapstructure:"name"`
+	Level    int      `json:"level" mapstructure:"level"`                         // 命中时计分的难度等级（1-5）
+	Keywords []string `json:"keywords,omitempty" mapstructure:"keywords"`         // 关键词，不区分大小写
+	Pattern  string   `json:"pattern,omitempty" mapstructure:"pattern"`           // 正则表达式
+	Weight   float64  `json:"weight,omitempty" mapstructure:"weight"`             // 命中得分，未配置时视为1
+}
+
+// ClassifierEvaluatorConfig 离线分类器配置：本地朴素贝叶斯模型，无需网络
+// 模型由 train-classifier 子命令生成，重新训练后需重新加载配置才会生效
+type ClassifierEvaluatorConfig struct {
+	// 模型文件路径
+	ModelPath string `json:"model_path" mapstructure:"model_path" default:"./data/classifier.json"`
+
+	// 预测概率低于该值时视为无法判断
+	MinConfidence float64 `json:"min_confidence" mapstructure:"min_confidence" default:"0.6"`
+}
+
+// HeuristicEvaluatorConfig 启发式评估配置：按消息数、工具数和估算 token 数分级
+// 阈值为升序列表（最多4个），超过第 N 个阈值即至少为 N+1 级，最终取各项中的最高等级
+type HeuristicEvaluatorConfig struct {
+	MessageThresholds []int `json:"message_thresholds,omitempty" mapstructure:"message_thresholds"`
+	ToolThresholds    []int `json:"tool_thresholds,omitempty" mapstructure:"tool_thresholds"`
+	TokenThresholds   []int `json:"token_thresholds,omitempty" mapstructure:"token_thresholds"`
+
+	// 只对这些等级直接判定，其余视为无法判断（交给 chain 中的下一个策略），为空表示总是判定
+	DecideLevels []int `json:"decide_levels,omitempty" mapstructure:"decide_levels"`
 }
 
 // FeatureFlags 功能开关
@@ -71,6 +725,9 @@ type FeatureFlags struct {
 	
 	// 记录请求日志
 	RequestLogging bool `json:"request_logging" mapstructure:"request_logging" default:"true"`
+	
+	// 配置文件修改后自动热加载（SIGHUP 始终可用）
+	ConfigHotReload bool `json:"config_hot_reload" mapstructure:"config_hot_reload" default:"true"`
 }
 
 // LogConfig 日志配置
@@ -0,0 +1,35 @@
+package models
+
+import "net/http"
+
+// ErrorEnvelope Anthropic 错误响应体（{"type":"error","error":{"type":...,"message":...}}）
+func ErrorEnvelope(statusCode int, message string) map[string]interface{} {
+	return map[string]interface{}{
+		"type": "error",
+		"error": map[string]string{
+			"type":    ErrorType(statusCode),
+			"message": message,
+		},
+	}
+}
+
+// ErrorType 按状态码映射 Anthropic 错误类型
+func ErrorType(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest, http.StatusUnprocessableEntity:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable, 529:
+		return "overloaded_error"
+	}
+	return "api_error"
+}
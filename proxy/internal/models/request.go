@@ -106,6 +106,10 @@ type UserContext struct {
 	UserID         string
 	SessionID      string
 	RequestHistory []RequestSummary
+	
+	// 最近一次因上游失败升级到的难度等级，之后 EscalationTurns 次评估至少为该等级
+	EscalatedLevel  int
+	EscalationTurns int
 }
 
 // RequestSummary 请求摘要，用于维护历史记录
@@ -128,6 +132,7 @@ type EvaluatorRequest struct {
 type EvaluatorResponse struct {
 	DifficultyLevel int    `json:"difficulty_level"` // 1-5
 	Reasoning       string `json:"reasoning,omitempty"`
+	Strategy        string `json:"strategy,omitempty"` // 给出判定的评估策略
 }
 
 // ExtractUserInfo 从 metadata 中提取用户ID和会话ID
@@ -0,0 +1,339 @@
+package openai
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// anthropicRequest Anthropic Messages 请求中需要转换的字段
+type anthropicRequest struct {
+	Model         string             `json:"model"`
+	MaxTokens     int                `json:"max_tokens"`
+	System        json.RawMessage    `json:"system,omitempty"`
+	Messages      []anthropicMessage `json:"messages"`
+	Tools         []anthropicTool    `json:"tools,omitempty"`
+	ToolChoice    *anthropicChoice   `json:"tool_choice,omitempty"`
+	Temperature   *float64           `json:"temperature,omitempty"`
+	TopP          *float64           `json:"top_p,omitempty"`
+	StopSequences []string           `json:"stop_sequences,omitempty"`
+	Stream        bool               `json:"stream,omitempty"`
+}
+
+type anthropicMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"`
+}
+
+// anthropicBlock 内容块，只保留转换需要的字段
+type anthropicBlock struct {
+	Type      string          `json:"type"`
+	Text      string          `json:"text,omitempty"`
+	ID        string          `json:"id,omitempty"`
+	Name      string          `json:"name,omitempty"`
+	Input     json.RawMessage `json:"input,omitempty"`
+	ToolUseID string          `json:"tool_use_id,omitempty"`
+	Content   json.RawMessage `json:"content,omitempty"`
+	IsError   bool            `json:"is_error,omitempty"`
+	Source    *imageSource    `json:"source,omitempty"`
+}
+
+type imageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+}
+
+type anthropicTool struct {
+	Type        string          `json:"type,omitempty"`
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	InputSchema json.RawMessage `json:"input_schema,omitempty"`
+}
+
+type anthropicChoice struct {
+	Type                   string `json:"type"`
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+// chatRequest Chat Completions 请求
+type chatRequest struct {
+	Model             string         `json:"model"`
+	Messages          []chatMessage  `json:"messages"`
+	MaxTokens         int            `json:"max_tokens,omitempty"`
+	Temperature       *float64       `json:"temperature,omitempty"`
+	TopP              *float64       `json:"top_p,omitempty"`
+	Stop              []string       `json:"stop,omitempty"`
+	Tools             []chatTool     `json:"tools,omitempty"`
+	ToolChoice        interface{}    `json:"tool_choice,omitempty"`
+	ParallelToolCalls *bool          `json:"parallel_tool_calls,omitempty"`
+	Stream            bool           `json:"stream,omitempty"`
+	StreamOptions     *streamOptions `json:"stream_options,omitempty"`
+}
+
+type streamOptions struct {
+	IncludeUsage bool `json:"include_usage"`
+}
+
+type chatMessage struct {
+	Role       string      `json:"role"`
+	Content    interface{} `json:"content"` // 字符串、内容片段数组，或 nil（只有工具调用的 assistant 消息）
+	ToolCalls  []toolCall  `json:"tool_calls,omitempty"`
+	ToolCallID string      `json:"tool_call_id,omitempty"`
+}
+
+type contentPart struct {
+	Type     string    `json:"type"`
+	Text     string    `json:"text,omitempty"`
+	ImageURL *imageURL `json:"image_url,omitempty"`
+}
+
+type imageURL struct {
+	URL string `json:"url"`
+}
+
+type toolCall struct {
+	Index    *int         `json:"index,omitempty"` // 仅流式增量中出现
+	ID       string       `json:"id,omitempty"`
+	Type     string       `json:"type,omitempty"`
+	Function functionCall `json:"function"`
+}
+
+type functionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type chatTool struct {
+	Type     string       `json:"type"`
+	Function functionSpec `json:"function"`
+}
+
+type functionSpec struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description,omitempty"`
+	Parameters  json.RawMessage `json:"parameters,omitempty"`
+}
+
+// TranslateRequest 将 Anthropic Messages 请求体转换为 Chat Completions 请求体
+// thinking、metadata、服务端工具（web_search 等）等没有对应字段的内容会被丢弃
+func TranslateRequest(body []byte) ([]byte, error) {
+	var req anthropicRequest
+	if err := json.Unmarshal(body, &req); err != nil {
+		return nil, fmt.Errorf("解析请求体失败: %v", err)
+	}
+
+	out := chatRequest{
+		Model:       req.Model,
+		MaxTokens:   req.MaxTokens,
+		Temperature: req.Temperature,
+		TopP:        req.TopP,
+		Stop:        req.StopSequences,
+		Stream:      req.Stream,
+	}
+	if req.Stream {
+		// 流式响应默认不带 usage，需要显式要求在最后一个 chunk 中返回
+		out.StreamOptions = &streamOptions{IncludeUsage: true}
+	}
+
+	system, err := systemText(req.System)
+	if err != nil {
+		return nil, err
+	}
+	if system != "" {
+		out.Messages = append(out.Messages, chatMessage{Role: "system", Content: system})
+	}
+
+	for i, msg := range req.Messages {
+		converted, err := translateMessage(msg)
+		if err != nil {
+			return nil, fmt.Errorf("转换第 %d 条消息失败: %v", i+1, err)
+		}
+		out.Messages = append(out.Messages, converted...)
+	}
+
+	for _, tool := range req.Tools {
+		// 只转换自定义工具，服务端工具在 Chat Completions 中没有对应
+		if tool.Type != "" && tool.Type != "custom" {
+			continue
+		}
+		parameters := tool.InputSchema
+		if len(parameters) == 0 {
+			parameters = json.RawMessage(`{"type":"object","properties":{}}`)
+		}
+		out.Tools = append(out.Tools, chatTool{
+			Type:     "function",
+			Function: functionSpec{Name: tool.Name, Description: tool.Description, Parameters: parameters},
+		})
+	}
+
+	if req.ToolChoice != nil && len(out.Tools) > 0 {
+		switch req.ToolChoice.Type {
+		case "auto":
+			out.ToolChoice = "auto"
+		case "any":
+			out.ToolChoice = "required"
+		case "none":
+			out.ToolChoice = "none"
+		case "tool":
+			out.ToolChoice = map[string]interface{}{
+				"type":     "function",
+				"function": map[string]string{"name": req.ToolChoice.Name},
+			}
+		}
+		if req.ToolChoice.DisableParallelToolUse {
+			parallel := false
+			out.ParallelToolCalls = &parallel
+		}
+	}
+
+	translated, err := json.Marshal(out)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求体失败: %v", err)
+	}
+	return translated, nil
+}
+
+// systemText system 可以是字符串或文本块数组
+func systemText(raw json.RawMessage) (string, error) {
+	if len(raw) == 0 || string(raw) == "null" {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return "", fmt.Errorf("无法解析 system: %v", err)
+	}
+	return joinText(blocks), nil
+}
+
+// parseContent 消息内容可以是字符串或内容块数组，统一为内容块
+func parseContent(raw json.RawMessage) ([]anthropicBlock, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return []anthropicBlock{{Type: "text", Text: text}}, nil
+	}
+	var blocks []anthropicBlock
+	if err := json.Unmarshal(raw, &blocks); err != nil {
+		return nil, fmt.Errorf("无法解析消息内容: %v", err)
+	}
+	return blocks, nil
+}
+
+// joinText 拼接文本块
+func joinText(blocks []anthropicBlock) string {
+	var parts []string
+	for _, block := range blocks {
+		if block.Type == "text" && block.Text != "" {
+			parts = append(parts, block.Text)
+		}
+	}
+	return strings.Join(parts, "\n")
+}
+
+// translateMessage 转换单条消息
+// user 消息中的 tool_result 拆成独立的 tool 消息（放在同一轮的文本之前）；
+// assistant 消息中的 tool_use 转为 tool_calls，thinking 块丢弃
+func translateMessage(msg anthropicMessage) ([]chatMessage, error) {
+	blocks, err := parseContent(msg.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	if msg.Role == "assistant" {
+		out := chatMessage{Role: "assistant"}
+		if text := joinText(blocks); text != "" {
+			out.Content = text
+		}
+		for _, block := range blocks {
+			if block.Type != "tool_use" {
+				continue
+			}
+			arguments := string(block.Input)
+			if arguments == "" {
+				arguments = "{}"
+			}
+			out.ToolCalls = append(out.ToolCalls, toolCall{
+				ID:       block.ID,
+				Type:     "function",
+				Function: functionCall{Name: block.Name, Arguments: arguments},
+			})
+		}
+		if out.Content == nil && len(out.ToolCalls) == 0 {
+			out.Content = ""
+		}
+		return []chatMessage{out}, nil
+	}
+
+	var out []chatMessage
+	var parts []contentPart
+	hasImage := false
+	for _, block := range blocks {
+		switch block.Type {
+		case "tool_result":
+			content, err := toolResultText(block)
+			if err != nil {
+				return nil, err
+			}
+			out = append(out, chatMessage{Role: "tool", ToolCallID: block.ToolUseID, Content: content})
+		case "text":
+			if block.Text != "" {
+				parts = append(parts, contentPart{Type: "text", Text: block.Text})
+			}
+		case "image":
+			if url := imageDataURL(block.Source); url != "" {
+				parts = append(parts, contentPart{Type: "image_url", ImageURL: &imageURL{URL: url}})
+				hasImage = true
+			}
+		}
+	}
+
+	switch {
+	case len(parts) == 0:
+	case hasImage:
+		out = append(out, chatMessage{Role: msg.Role, Content: parts})
+	default:
+		// 纯文本时使用字符串内容，兼容性最好
+		texts := make([]string, 0, len(parts))
+		for _, part := range parts {
+			texts = append(texts, part.Text)
+		}
+		out = append(out, chatMessage{Role: msg.Role, Content: strings.Join(texts, "\n")})
+	}
+	return out, nil
+}
+
+// toolResultText tool_result 的内容可以是字符串或内容块数组，错误结果加上前缀
+func toolResultText(block anthropicBlock) (string, error) {
+	text := ""
+	if len(block.Content) > 0 && string(block.Content) != "null" {
+		blocks, err := parseContent(block.Content)
+		if err != nil {
+			return "", err
+		}
+		text = joinText(blocks)
+	}
+	if block.IsError {
+		text = "[error] " + text
+	}
+	return text, nil
+}
+
+// imageDataURL 将图片来源转换为 image_url
+func imageDataURL(source *imageSource) string {
+	if source == nil {
+		return ""
+	}
+	switch source.Type {
+	case "base64":
+		return fmt.Sprintf("data:%s;base64,%s", source.MediaType, source.Data)
+	case "url":
+		return source.URL
+	}
+	return ""
+}
@@ -0,0 +1,72 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// update 重新生成 testdata 中的期望输出：go test ./internal/openai -update
+var update = flag.Bool("update", false, "重新生成 golden 文件")
+
+// checkGolden 与 golden 文件比较，-update 时改为写入
+func checkGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.WriteFile(path, got, 0644); err != nil {
+			t.Fatalf("写入 golden 文件失败: %v", err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("读取 golden 文件失败（首次运行请加 -update）: %v", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("输出与 %s 不一致\n got:\n%s\nwant:\n%s", path, got, want)
+	}
+}
+
+func TestTranslateRequestGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "request", "*.json"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("没有找到测试输入: %v", err)
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".json")
+		t.Run(name, func(t *testing.T) {
+			body, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			translated, err := TranslateRequest(body)
+			if err != nil {
+				t.Fatalf("TranslateRequest 失败: %v", err)
+			}
+			var pretty bytes.Buffer
+			if err := json.Indent(&pretty, translated, "", "  "); err != nil {
+				t.Fatalf("输出不是合法的 JSON: %v\n%s", err, translated)
+			}
+			pretty.WriteByte('\n')
+			checkGolden(t, strings.TrimSuffix(input, ".json")+".golden", pretty.Bytes())
+		})
+	}
+}
+
+func TestTranslateRequestInvalid(t *testing.T) {
+	tests := map[string]string{
+		"请求体无效":     `{"model":`,
+		"system 无效": `{"model":"m","system":42,"messages":[]}`,
+		"消息内容无效":    `{"model":"m","messages":[{"role":"user","content":42}]}`,
+	}
+	for name, body := range tests {
+		if _, err := TranslateRequest([]byte(body)); err == nil {
+			t.Errorf("%s: 应返回错误", name)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package openai
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// chatResponse Chat Completions 非流式响应
+type chatResponse struct {
+	ID      string       `json:"id"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage"`
+}
+
+type chatChoice struct {
+	Message      chatResponseMessage `json:"message"`
+	Delta        chatResponseMessage `json:"delta"` // 仅流式
+	FinishReason string              `json:"finish_reason"`
+}
+
+type chatResponseMessage struct {
+	Content   string     `json:"content"`
+	ToolCalls []toolCall `json:"tool_calls"`
+}
+
+type chatUsage struct {
+	PromptTokens        int64 `json:"prompt_tokens"`
+	CompletionTokens    int64 `json:"completion_tokens"`
+	PromptTokensDetails *struct {
+		CachedTokens int64 `json:"cached_tokens"`
+	} `json:"prompt_tokens_details"`
+}
+
+// anthropicUsage 转换为 Anthropic 的 usage：input_tokens 不包含缓存命中的部分
+func (u *chatUsage) anthropicUsage() map[string]int64 {
+	if u == nil {
+		return map[string]int64{"input_tokens": 0, "output_tokens": 0}
+	}
+	cached := int64(0)
+	if u.PromptTokensDetails != nil {
+		cached = u.PromptTokensDetails.CachedTokens
+	}
+	usage := map[string]int64{
+		"input_tokens":  u.PromptTokens - cached,
+		"output_tokens": u.CompletionTokens,
+	}
+	if cached > 0 {
+		usage["cache_read_input_tokens"] = cached
+	}
+	return usage
+}
+
+// chatError Chat Completions 错误响应
+type chatError struct {
+	Error *struct {
+		Message string      `json:"message"`
+		Type    string      `json:"type"`
+		Code    interface{} `json:"code"`
+	} `json:"error"`
+}
+
+// TranslateResponse 将 Chat Completions 响应原地替换为 Anthropic 格式
+// 流式响应（text/event-stream）转换为 Anthropic SSE 事件，其余按 JSON 响应或错误响应转换
+func TranslateResponse(resp *http.Response) error {
+	if strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") && resp.StatusCode == http.StatusOK {
+		resp.Body = newStreamTranslator(resp.Body)
+		resp.ContentLength = -1
+		resp.Header.Del("Content-Length")
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return fmt.Errorf("读取响应体失败: %v", err)
+	}
+
+	var translated []byte
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		translated, err = translateCompletion(body)
+	} else {
+		translated, err = translateError(resp.StatusCode, body)
+	}
+	if err != nil {
+		return err
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(translated))
+	resp.ContentLength = int64(len(translated))
+	resp.Header.Set("Content-Length", strconv.Itoa(len(translated)))
+	resp.Header.Set("Content-Type", "application/json")
+	return nil
+}
+
+// translateCompletion 转换非流式的成功响应
+func translateCompletion(body []byte) ([]byte, error) {
+	var resp chatResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("解析 Chat Completions 响应失败: %v", err)
+	}
+	if len(resp.Choices) == 0 {
+		// 部分服务在 200 响应中返回错误对象
+		return translateError(http.StatusBadGateway, body)
+	}
+
+	choice := resp.Choices[0]
+	content := []map[string]interface{}{}
+	if choice.Message.Content != "" {
+		content = append(content, map[string]interface{}{"type": "text", "text": choice.Message.Content})
+	}
+	for _, call := range choice.Message.ToolCalls {
+		content = append(content, map[string]interface{}{
+			"type":  "tool_use",
+			"id":    call.ID,
+			"name":  call.Function.Name,
+			"input": toolInput(call.Function.Arguments),
+		})
+	}
+
+	return json.Marshal(map[string]interface{}{
+		"id":            messageID(resp.ID),
+		"type":          "message",
+		"role":          "assistant",
+		"model":         resp.Model,
+		"content":       content,
+		"stop_reason":   stopReason(choice.FinishReason),
+		"stop_sequence": nil,
+		"usage":         resp.Usage.anthropicUsage(),
+	})
+}
+
+// translateError 将错误响应转换为 Anthropic 错误格式
+func translateError(statusCode int, body []byte) ([]byte, error) {
+	message := strings.TrimSpace(string(body))
+	var parsed chatError
+	if json.Unmarshal(body, &parsed) == nil && parsed.Error != nil && parsed.Error.Message != "" {
+		message = parsed.Error.Message
+	}
+	if message == "" {
+		message = http.StatusText(statusCode)
+	}
+	return json.Marshal(models.ErrorEnvelope(statusCode, message))
+}
+
+// stopReason 映射结束原因
+func stopReason(finishReason string) interface{} {
+	switch finishReason {
+	case "":
+		return nil
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	}
+	return "end_turn"
+}
+
+// toolInput 解析工具参数；模型输出的参数不是合法 JSON 对象时返回空对象
+func toolInput(arguments string) json.RawMessage {
+	var input map[string]interface{}
+	if json.Unmarshal([]byte(arguments), &input) != nil || input == nil {
+		return json.RawMessage(`{}`)
+	}
+	return json.RawMessage(arguments)
+}
+
+// messageID 使用上游的 ID，缺失时生成占位 ID
+func messageID(id string) string {
+	if id == "" {
+		return "msg_openai"
+	}
+	return id
+}
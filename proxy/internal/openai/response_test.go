@@ -0,0 +1,113 @@
+package openai
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTranslateResponse(t *testing.T) {
+	tests := []struct {
+		name   string
+		status int
+		body   string
+		want   string
+	}{
+		{
+			name:   "文本和工具调用",
+			status: http.StatusOK,
+			body: `{"id":"chatcmpl-1","model":"glm-4.5","choices":[{"index":0,"message":{"role":"assistant","content":"Let me check.",` +
+				`"tool_calls":[{"id":"call_1","type":"function","function":{"name":"Bash","arguments":"{\"command\":\"ls\"}"}},` +
+				`{"id":"call_2","type":"function","function":{"name":"Read","arguments":"not json"}}]},"finish_reason":"tool_calls"}],` +
+				`"usage":{"prompt_tokens":120,"completion_tokens":30,"prompt_tokens_details":{"cached_tokens":100}}}`,
+			want: `{"id":"chatcmpl-1","type":"message","role":"assistant","model":"glm-4.5","content":[` +
+				`{"type":"text","text":"Let me check."},` +
+				`{"type":"tool_use","id":"call_1","name":"Bash","input":{"command":"ls"}},` +
+				`{"type":"tool_use","id":"call_2","name":"Read","input":{}}],` +
+				`"stop_reason":"tool_use","stop_sequence":null,` +
+				`"usage":{"input_tokens":20,"output_tokens":30,"cache_read_input_tokens":100}}`,
+		},
+		{
+			name:   "达到长度上限",
+			status: http.StatusOK,
+			body:   `{"model":"kimi-k2","choices":[{"message":{"content":"partial"},"finish_reason":"length"}]}`,
+			want: `{"id":"msg_openai","type":"message","role":"assistant","model":"kimi-k2","content":[{"type":"text","text":"partial"}],` +
+				`"stop_reason":"max_tokens","stop_sequence":null,"usage":{"input_tokens":0,"output_tokens":0}}`,
+		},
+		{
+			name:   "200 响应中的错误对象",
+			status: http.StatusOK,
+			body:   `{"error":{"message":"model not found","type":"invalid_request_error"}}`,
+			want:   `{"type":"error","error":{"type":"api_error","message":"model not found"}}`,
+		},
+		{
+			name:   "429 错误",
+			status: http.StatusTooManyRequests,
+			body:   `{"error":{"message":"quota exceeded","type":"rate_limit","code":"1302"}}`,
+			want:   `{"type":"error","error":{"type":"rate_limit_error","message":"quota exceeded"}}`,
+		},
+		{
+			name:   "非 JSON 错误",
+			status: http.StatusBadGateway,
+			body:   `<html>502 Bad Gateway</html>`,
+			want:   `{"type":"error","error":{"type":"api_error","message":"<html>502 Bad Gateway</html>"}}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{
+				StatusCode: tt.status,
+				Header:     http.Header{"Content-Type": {"application/json"}},
+				Body:       io.NopCloser(strings.NewReader(tt.body)),
+			}
+			if err := TranslateResponse(resp); err != nil {
+				t.Fatalf("TranslateResponse 失败: %v", err)
+			}
+			body, err := io.ReadAll(resp.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.ContentLength != int64(len(body)) {
+				t.Errorf("ContentLength = %d，实际 %d", resp.ContentLength, len(body))
+			}
+
+			var got, want interface{}
+			if err := json.Unmarshal(body, &got); err != nil {
+				t.Fatalf("输出不是合法的 JSON: %v\n%s", err, body)
+			}
+			if err := json.Unmarshal([]byte(tt.want), &want); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("结果不符\n got: %s\nwant: %s", body, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslateResponseStream(t *testing.T) {
+	resp := &http.Response{
+		StatusCode:    http.StatusOK,
+		Header:        http.Header{"Content-Type": {"text/event-stream"}, "Content-Length": {"100"}},
+		Body:          io.NopCloser(strings.NewReader("data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n")),
+		ContentLength: 100,
+	}
+	if err := TranslateResponse(resp); err != nil {
+		t.Fatalf("TranslateResponse 失败: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.ContentLength != -1 || resp.Header.Get("Content-Length") != "" {
+		t.Errorf("流式响应不应保留 Content-Length")
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(body), `"text":"hi"`) || !strings.HasSuffix(string(body), "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n") {
+		t.Errorf("流式响应没有转换: %s", body)
+	}
+}
@@ -0,0 +1,250 @@
+package openai
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// streamChunk Chat Completions 流式 chunk
+type streamChunk struct {
+	ID      string       `json:"id"`
+	Model   string       `json:"model"`
+	Choices []chatChoice `json:"choices"`
+	Usage   *chatUsage   `json:"usage"`
+	Error   *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// streamTranslator 将 Chat Completions 流转换为 Anthropic SSE 事件流
+// 转换在后台 goroutine 中进行，通过管道输出；Close 会同时关闭上游响应体
+type streamTranslator struct {
+	upstream io.ReadCloser
+	reader   *io.PipeReader
+	writer   *io.PipeWriter
+
+	started    bool
+	blockIndex int    // 当前内容块序号，-1 表示没有打开的块
+	blockType  string // 当前内容块类型：text 或 tool_use
+	toolIndex  int    // 当前 tool_use 块对应的 tool_calls 序号
+	toolID     string // 当前 tool_use 块的 id
+	nextIndex  int
+	stopReason interface{}
+	usage      *chatUsage
+}
+
+// newStreamTranslator 创建流式转换器
+func newStreamTranslator(upstream io.ReadCloser) io.ReadCloser {
+	reader, writer := io.Pipe()
+	t := &streamTranslator{
+		upstream:   upstream,
+		reader:     reader,
+		writer:     writer,
+		blockIndex: -1,
+		toolIndex:  -1,
+	}
+	go t.run()
+	return t
+}
+
+// Read 读取转换后的 SSE 数据
+func (t *streamTranslator) Read(p []byte) (int, error) {
+	return t.reader.Read(p)
+}
+
+// Close 关闭转换器和上游响应体
+func (t *streamTranslator) Close() error {
+	t.reader.Close()
+	return t.upstream.Close()
+}
+
+// run 逐个读取上游 chunk 并输出对应的 Anthropic 事件
+func (t *streamTranslator) run() {
+	err := t.translate()
+	t.writer.CloseWithError(err)
+}
+
+func (t *streamTranslator) translate() error {
+	scanner := bufio.NewScanner(t.upstream)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "data:") {
+			continue
+		}
+		data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		if data == "[DONE]" {
+			return t.finish()
+		}
+
+		var chunk streamChunk
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			return t.emitError(http.StatusBadGateway, fmt.Sprintf("无法解析上游流式数据: %v", err))
+		}
+		if chunk.Error != nil {
+			return t.emitError(http.StatusBadGateway, chunk.Error.Message)
+		}
+		if err := t.handleChunk(&chunk); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	// 上游未发送 [DONE] 直接结束：有输出时正常收尾，没有任何输出时视为错误（便于切换服务）
+	if !t.started {
+		return t.emitError(http.StatusBadGateway, "上游流式响应为空")
+	}
+	return t.finish()
+}
+
+// handleChunk 处理一个 chunk
+func (t *streamTranslator) handleChunk(chunk *streamChunk) error {
+	if err := t.start(chunk); err != nil {
+		return err
+	}
+	if chunk.Usage != nil {
+		t.usage = chunk.Usage
+	}
+
+	for _, choice := range chunk.Choices {
+		if choice.Delta.Content != "" {
+			if t.blockType != "text" {
+				if err := t.openBlock("text", map[string]interface{}{"type": "text", "text": ""}); err != nil {
+					return err
+				}
+			}
+			if err := t.emit("content_block_delta", map[string]interface{}{
+				"type":  "content_block_delta",
+				"index": t.blockIndex,
+				"delta": map[string]string{"type": "text_delta", "text": choice.Delta.Content},
+			}); err != nil {
+				return err
+			}
+		}
+
+		for _, call := range choice.Delta.ToolCalls {
+			index := 0
+			if call.Index != nil {
+				index = *call.Index
+			}
+			// 序号或 id 变化表示新的工具调用（部分服务所有调用的 index 都是0）
+			if t.blockType != "tool_use" || index != t.toolIndex || (call.ID != "" && call.ID != t.toolID) {
+				t.toolIndex, t.toolID = index, call.ID
+				if err := t.openBlock("tool_use", map[string]interface{}{
+					"type":  "tool_use",
+					"id":    call.ID,
+					"name":  call.Function.Name,
+					"input": map[string]interface{}{},
+				}); err != nil {
+					return err
+				}
+			}
+			if call.Function.Arguments != "" {
+				if err := t.emit("content_block_delta", map[string]interface{}{
+					"type":  "content_block_delta",
+					"index": t.blockIndex,
+					"delta": map[string]string{"type": "input_json_delta", "partial_json": call.Function.Arguments},
+				}); err != nil {
+					return err
+				}
+			}
+		}
+
+		if choice.FinishReason != "" {
+			t.stopReason = stopReason(choice.FinishReason)
+		}
+	}
+	return nil
+}
+
+// start 首个 chunk 到达时输出 message_start
+func (t *streamTranslator) start(chunk *streamChunk) error {
+	if t.started {
+		return nil
+	}
+	t.started = true
+	return t.emit("message_start", map[string]interface{}{
+		"type": "message_start",
+		"message": map[string]interface{}{
+			"id":            messageID(chunk.ID),
+			"type":          "message",
+			"role":          "assistant",
+			"model":         chunk.Model,
+			"content":       []interface{}{},
+			"stop_reason":   nil,
+			"stop_sequence": nil,
+			"usage":         map[string]int64{"input_tokens": 0, "output_tokens": 0},
+		},
+	})
+}
+
+// openBlock 关闭当前内容块并打开新的内容块
+func (t *streamTranslator) openBlock(blockType string, contentBlock map[string]interface{}) error {
+	if err := t.closeBlock(); err != nil {
+		return err
+	}
+	t.blockIndex = t.nextIndex
+	t.nextIndex++
+	t.blockType = blockType
+	return t.emit("content_block_start", map[string]interface{}{
+		"type":          "content_block_start",
+		"index":         t.blockIndex,
+		"content_block": contentBlock,
+	})
+}
+
+// closeBlock 关闭当前内容块
+func (t *streamTranslator) closeBlock() error {
+	if t.blockIndex < 0 {
+		return nil
+	}
+	index := t.blockIndex
+	t.blockIndex, t.blockType = -1, ""
+	return t.emit("content_block_stop", map[string]interface{}{"type": "content_block_stop", "index": index})
+}
+
+// finish 输出 message_delta（结束原因和用量）和 message_stop
+func (t *streamTranslator) finish() error {
+	if err := t.start(&streamChunk{}); err != nil {
+		return err
+	}
+	if err := t.closeBlock(); err != nil {
+		return err
+	}
+	stop := t.stopReason
+	if stop == nil {
+		stop = "end_turn"
+	}
+	if err := t.emit("message_delta", map[string]interface{}{
+		"type":  "message_delta",
+		"delta": map[string]interface{}{"stop_reason": stop, "stop_sequence": nil},
+		"usage": t.usage.anthropicUsage(),
+	}); err != nil {
+		return err
+	}
+	return t.emit("message_stop", map[string]string{"type": "message_stop"})
+}
+
+// emitError 输出 Anthropic 错误事件并结束流
+func (t *streamTranslator) emitError(statusCode int, message string) error {
+	return t.emit("error", models.ErrorEnvelope(statusCode, message))
+}
+
+// emit 输出一个 SSE 事件
+func (t *streamTranslator) emit(event string, data interface{}) error {
+	payload, err := json.Marshal(data)
+	if err != nil {
+		return fmt.Errorf("序列化流式事件失败: %v", err)
+	}
+	_, err = fmt.Fprintf(t.writer, "event: %s\ndata: %s\n\n", event, payload)
+	return err
+}
@@ -0,0 +1,46 @@
+package openai
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStreamTranslatorGolden(t *testing.T) {
+	inputs, err := filepath.Glob(filepath.Join("testdata", "stream", "*.sse"))
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("没有找到测试输入: %v", err)
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(filepath.Base(input), ".sse")
+		t.Run(name, func(t *testing.T) {
+			upstream, err := os.Open(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			translator := newStreamTranslator(upstream)
+			defer translator.Close()
+
+			got, err := io.ReadAll(translator)
+			if err != nil {
+				t.Fatalf("读取转换结果失败: %v", err)
+			}
+			checkGolden(t, strings.TrimSuffix(input, ".sse")+".golden", got)
+		})
+	}
+}
+
+// TestStreamTranslatorClose 客户端提前关闭时同时关闭上游响应体
+func TestStreamTranslatorClose(t *testing.T) {
+	reader, writer := io.Pipe()
+	translator := newStreamTranslator(reader)
+	if err := translator.Close(); err != nil {
+		t.Fatalf("Close 失败: %v", err)
+	}
+	if _, err := writer.Write([]byte("data: [DONE]\n\n")); err == nil {
+		t.Error("关闭后上游仍可写入")
+	}
+}
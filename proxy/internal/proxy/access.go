@@ -0,0 +1,168 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+const (
+	// accessTokenHeader 专用的访问令牌请求头（也接受 x-api-key 和 Authorization: Bearer）
+	accessTokenHeader = "X-CCE-Token"
+	// accessTokenKey 通过校验的令牌在 gin.Context 中的键
+	accessTokenKey = "cce.access_token"
+)
+
+// statusError 带 HTTP 状态码的错误，ProxyMiddleware 按该状态码返回
+type statusError struct {
+	status     int
+	message    string
+	retryAfter time.Duration // 大于0时设置 Retry-After 响应头
+}
+
+func (e *statusError) Error() string {
+	return e.message
+}
+
+// accessMiddleware 校验代理访问令牌（access.enabled 时），通过后将令牌记入请求上下文
+// 健康检查（客户端用于判断服务状态）、/admin 和 /ledger（使用管理令牌）不需要访问令牌；
+// /status 和 /metrics 包含所有用户的用量，启用访问令牌后改用管理令牌（Prometheus 抓取时需配置）；
+// 每次请求读取当前配置，签发或吊销后热加载即可生效
+func (s *Server) accessMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		current := config.Get()
+		cfg := current.Access
+		path := c.Request.URL.Path
+		if !cfg.Enabled || path == "/health" || path == "/services/health" || strings.HasPrefix(path, "/admin/") || strings.HasPrefix(path, "/ledger/") {
+			c.Next()
+			return
+		}
+		if path == "/status" || path == "/metrics" {
+			if !validAdminToken(c, current.Admin) {
+				c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "启用访问令牌后 " + path + " 需要携带管理令牌（Authorization: Bearer）"})
+				return
+			}
+			c.Next()
+			return
+		}
+
+		presented, header := presentedAccessToken(c.Request.Header)
+		var token *models.AccessToken
+		if presented != "" {
+			for i := range cfg.Tokens {
+				if cfg.Tokens[i].Matches(presented) {
+					token = &cfg.Tokens[i]
+					break
+				}
+			}
+		}
+		if token == nil {
+			logger.LogWarn("访问令牌无效，拒绝请求",
+				"path", path,
+				"client_ip", c.ClientIP(),
+			)
+			writeError(c, http.StatusUnauthorized, "访问令牌无效")
+			c.Abort()
+			return
+		}
+
+		// 访问令牌不转发给上游（包括 passthrough 的服务）
+		c.Request.Header.Del(header)
+		c.Set(accessTokenKey, token)
+		c.Next()
+	}
+}
+
+// presentedAccessToken 取出请求携带的访问令牌及其所在的请求头
+func presentedAccessToken(header http.Header) (string, string) {
+	if token := header.Get(accessTokenHeader); token != "" {
+		return token, accessTokenHeader
+	}
+	if token := header.Get("X-Api-Key"); token != "" {
+		return token, "X-Api-Key"
+	}
+	if auth := header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer "), "Authorization"
+	}
+	return "", ""
+}
+
+// accessToken 获取请求对应的访问令牌，未启用访问令牌时返回 nil
+func accessToken(c *gin.Context) *models.AccessToken {
+	if value, ok := c.Get(accessTokenKey); ok {
+		return value.(*models.AccessToken)
+	}
+	return nil
+}
+
+// permittedLevel 令牌不允许该难度等级（或该等级没有允许的服务）时改用最接近的可用等级（优先较低的等级）
+func permittedLevel(cfg *models.Config, token *models.AccessToken, level int) int {
+	if token == nil || levelPermitted(cfg, token, level) {
+		return level
+	}
+	for l := level - 1; l >= 1; l-- {
+		if levelPermitted(cfg, token, l) {
+			return l
+		}
+	}
+	for l := level + 1; l <= 5; l++ {
+		if levelPermitted(cfg, token, l) {
+			return l
+		}
+	}
+	return level
+}
+
+// levelPermitted 判断令牌是否允许该难度等级，且该等级映射了令牌允许的服务
+func levelPermitted(cfg *models.Config, token *models.AccessToken, level int) bool {
+	if !token.AllowsLevel(level) {
+		return false
+	}
+	if len(token.Services) == 0 {
+		return true
+	}
+	for _, target := range cfg.DifficultyMapping[strconv.Itoa(level)] {
+		if token.AllowsService(target.ID) {
+			return true
+		}
+	}
+	return false
+}
+
+// permittedServices 过滤出令牌允许使用的服务
+// levels 为候选服务对应的难度等级（自动切换的候选链包含其他等级的服务），不允许的等级同样过滤
+func permittedServices(token *models.AccessToken, services []*models.Service, levels map[string]int) []*models.Service {
+	if token == nil {
+		return services
+	}
+	permitted := make([]*models.Service, 0, len(services))
+	for _, svc := range services {
+		if level, ok := levels[svc.ID]; ok && !token.AllowsLevel(level) {
+			continue
+		}
+		if token.AllowsService(svc.ID) {
+			permitted = append(permitted, svc)
+		}
+	}
+	return permitted
+}
+
+// checkOverrideAccess 手动指定的等级或服务不在令牌允许范围内时拒绝请求
+func checkOverrideAccess(token *models.AccessToken, override *routingOverride) error {
+	if token == nil {
+		return nil
+	}
+	if override.ServiceID != "" && !token.AllowsService(override.ServiceID) {
+		return &statusError{status: http.StatusForbidden, message: "访问令牌不允许使用服务: " + override.ServiceID}
+	}
+	if override.ServiceID == "" && !token.AllowsLevel(override.Level) {
+		return &statusError{status: http.StatusForbidden, message: "访问令牌不允许使用该难度等级"}
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// maskedAPIKey 返回给管理接口的 API Key 掩码，提交掩码值表示保持原值不变
+const maskedAPIKey = "********"
+
+// setupAdminRoutes 注册 /admin 管理接口
+// 路由始终注册，是否启用由中间件按当前配置判断，便于通过热加载开启或关闭
+func (s *Server) setupAdminRoutes() {
+	admin := s.router.Group("/admin", s.adminAuthMiddleware())
+	admin.GET("/config", s.adminGetConfig)
+	admin.POST("/reload", s.adminReload)
+
+	admin.GET("/services", s.adminListServices)
+	admin.POST("/services", s.adminAddService)
+	admin.PUT("/services/:id", s.adminUpdateService)
+	admin.DELETE("/services/:id", s.adminDeleteService)
+
+	admin.GET("/difficulty_mapping", s.adminGetMapping)
+	admin.PUT("/difficulty_mapping", s.adminSetMapping)
+	admin.PUT("/difficulty_mapping/:level", s.adminSetLevel)
+
+	admin.GET("/features", s.adminGetFeatures)
+	admin.PATCH("/features", s.adminPatchFeatures)
+
+	admin.GET("/evaluator/prompt", s.adminGetPrompt)
+	admin.PUT("/evaluator/prompt", s.adminSetPrompt)
+}
+
+// adminAuthMiddleware 校验管理令牌（Authorization: Bearer <token>）
+// 每次请求读取当前配置，令牌修改后热加载即可生效
+func (s *Server) adminAuthMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		cfg := config.Get().Admin
+		if !cfg.Enabled {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "管理接口未启用（admin.enabled=false）"})
+			return
+		}
+
+		if !validAdminToken(c, cfg) {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "管理令牌无效"})
+			return
+		}
+		c.Next()
+	}
+}
+
+// validAdminToken 请求是否携带了正确的管理令牌（未配置令牌时总是返回 false）
+func validAdminToken(c *gin.Context, cfg models.AdminConfig) bool {
+	token := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+	return cfg.ResolvedToken() != "" && subtle.ConstantTimeCompare([]byte(token), []byte(cfg.ResolvedToken())) == 1
+}
+
+// adminUpdate 执行一次配置修改并返回结果
+// persistPaths 仅在需要写回配置文件时使用，?persist=true/false 可覆盖 admin.write_back。
+// 不写回的修改只保存在内存中，下次重新加载配置文件（文件变化、SIGHUP、/admin/reload）时丢失：
+// 启用了配置文件监听时拒绝这类修改，否则通过 Warning 响应头提示
+func (s *Server) adminUpdate(c *gin.Context, mutate func(cfg *models.Config) error, persistPaths ...string) (*models.Config, bool) {
+	persist := config.Get().Admin.WriteBack
+	if value := c.Query("persist"); value != "" {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "persist 参数无效: " + value})
+			return nil, false
+		}
+		persist = parsed
+	}
+	if !persist {
+		if config.Watching() {
+			c.JSON(http.StatusConflict, gin.H{"error": "已启用配置文件热加载（features.config_hot_reload），不写回文件的修改会在配置文件下次变化时丢失；请写回配置文件（persist=true）或关闭热加载"})
+			return nil, false
+		}
+		persistPaths = nil
+	}
+
+	cfg, err := config.Update(mutate, persistPaths...)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return nil, false
+	}
+
+	c.Header("X-Config-Persisted", strconv.FormatBool(persist))
+	if !persist {
+		c.Header("Warning", `299 claude-proxy "not persisted: discarded on the next config reload (SIGHUP or /admin/reload)"`)
+	}
+	return cfg, true
+}
+
+// adminGetConfig 当前生效的完整配置（API Key 已隐藏）
+func (s *Server) adminGetConfig(c *gin.Context) {
+	cfg, err := config.Clone(config.Get())
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+	cfg.Services = maskServices(cfg.Services)
+	if !config.IsSecretRef(cfg.Admin.Token) {
+		cfg.Admin.Token = maskedAPIKey
+	}
+	cfg.Tracing.Headers = maskHeaders(cfg.Tracing.Headers)
+	c.JSON(http.StatusOK, cfg)
+}
+
+// adminReload 从配置文件重新加载，校验失败时返回错误并保留旧配置
+func (s *Server) adminReload(c *gin.Context) {
+	if err := config.Reload(); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"status": "reloaded"})
+}
+
+// adminListServices 服务列表
+func (s *Server) adminListServices(c *gin.Context) {
+	c.JSON(http.StatusOK, maskServices(config.Get().Services))
+}
+
+// adminAddService 新增服务
+func (s *Server) adminAddService(c *gin.Context) {
+	var svc models.Service
+	if !bindService(c, &svc) {
+		return
+	}
+
+	cfg, ok := s.adminUpdate(c, func(cfg *models.Config) error {
+		for _, existing := range cfg.Services {
+			if existing.ID == svc.ID {
+				return fmt.Errorf("服务ID已存在: %s", svc.ID)
+			}
+		}
+		if svc.APIKey == maskedAPIKey {
+			return fmt.Errorf("新服务必须提供 api_key")
+		}
+		cfg.Services = append(cfg.Services, svc)
+		return nil
+	}, "services")
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusCreated, maskServices(cfg.Services))
+}
+
+// adminUpdateService 修改服务（api_key 为空或为掩码时保持原值）
+func (s *Server) adminUpdateService(c *gin.Context) {
+	id := c.Param("id")
+	var svc models.Service
+	if !bindService(c, &svc) {
+		return
+	}
+	if svc.ID != "" && svc.ID != id {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "不支持修改服务ID"})
+		return
+	}
+	svc.ID = id
+
+	cfg, ok := s.adminUpdate(c, func(cfg *models.Config) error {
+		for i := range cfg.Services {
+			if cfg.Services[i].ID != id {
+				continue
+			}
+			if svc.APIKey == "" || svc.APIKey == maskedAPIKey {
+				svc.APIKey = cfg.Services[i].APIKey
+			}
+			cfg.Services[i] = svc
+			return nil
+		}
+		return fmt.Errorf("服务ID不存在: %s", id)
+	}, "services")
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, maskServices(cfg.Services))
+}
+
+// adminDeleteService 删除服务（仍被难度映射引用时校验失败）
+func (s *Server) adminDeleteService(c *gin.Context) {
+	id := c.Param("id")
+	cfg, ok := s.adminUpdate(c, func(cfg *models.Config) error {
+		for i := range cfg.Services {
+			if cfg.Services[i].ID == id {
+				cfg.Services = append(cfg.Services[:i], cfg.Services[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("服务ID不存在: %s", id)
+	}, "services")
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, maskServices(cfg.Services))
+}
+
+// adminGetMapping 难度映射
+func (s *Server) adminGetMapping(c *gin.Context) {
+	c.JSON(http.StatusOK, config.Get().DifficultyMapping)
+}
+
+// adminSetMapping 替换整个难度映射
+func (s *Server) adminSetMapping(c *gin.Context) {
+	var mapping map[string]models.LevelTargets
+	if err := c.ShouldBindJSON(&mapping); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "解析难度映射失败: " + err.Error()})
+		return
+	}
+
+	cfg, ok := s.adminUpdate(c, func(cfg *models.Config) error {
+		cfg.DifficultyMapping = mapping
+		return nil
+	}, "difficulty_mapping")
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, cfg.DifficultyMapping)
+}
+
+// adminSetLevel 修改单个难度等级的映射
+func (s *Server) adminSetLevel(c *gin.Context) {
+	level := c.Param("level")
+	if n, err := strconv.Atoi(level); err != nil || n < 1 || n > 5 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "难度等级必须为 1-5: " + level})
+		return
+	}
+	var targets models.LevelTargets
+	if err := c.ShouldBindJSON(&targets); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "解析难度映射失败: " + err.Error()})
+		return
+	}
+
+	cfg, ok := s.adminUpdate(c, func(cfg *models.Config) error {
+		if cfg.DifficultyMapping == nil {
+			cfg.DifficultyMapping = make(map[string]models.LevelTargets)
+		}
+		cfg.DifficultyMapping[level] = targets
+		return nil
+	}, "difficulty_mapping."+level)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, cfg.DifficultyMapping)
+}
+
+// adminGetFeatures 功能开关
+func (s *Server) adminGetFeatures(c *gin.Context) {
+	c.JSON(http.StatusOK, config.Get().Features)
+}
+
+// adminPatchFeatures 修改部分功能开关，例如 {"service_auto_switch": true}
+func (s *Server) adminPatchFeatures(c *gin.Context) {
+	var changes map[string]bool
+	if err := c.ShouldBindJSON(&changes); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "解析功能开关失败: " + err.Error()})
+		return
+	}
+
+	paths := make([]string, 0, len(changes))
+	for name := range changes {
+		paths = append(paths, "features."+name)
+	}
+
+	cfg, ok := s.adminUpdate(c, func(cfg *models.Config) error {
+		return applyFeatureChanges(&cfg.Features, changes)
+	}, paths...)
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, cfg.Features)
+}
+
+// adminGetPrompt 决策者 Prompt 模板
+func (s *Server) adminGetPrompt(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"prompt_template": config.Get().Evaluator.PromptTemplate})
+}
+
+// adminSetPrompt 修改决策者 Prompt 模板，空字符串表示使用内置模板
+func (s *Server) adminSetPrompt(c *gin.Context) {
+	var body struct {
+		PromptTemplate *string `json:"prompt_template"`
+	}
+	if err := c.ShouldBindJSON(&body); err != nil || body.PromptTemplate == nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "请求体需要包含 prompt_template"})
+		return
+	}
+
+	cfg, ok := s.adminUpdate(c, func(cfg *models.Config) error {
+		cfg.Evaluator.PromptTemplate = *body.PromptTemplate
+		return nil
+	}, "evaluator.prompt_template")
+	if !ok {
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"prompt_template": cfg.Evaluator.PromptTemplate})
+}
+
+// bindService 解析服务配置请求体
+// 与配置文件一致，未指定 supports_thinking 时默认为 true
+func bindService(c *gin.Context, svc *models.Service) bool {
+	svc.SupportsThinking = true
+	if err := c.ShouldBindJSON(svc); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "解析服务配置失败: " + err.Error()})
+		return false
+	}
+	if c.Request.Method == http.MethodPost && svc.ID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "服务ID不能为空"})
+		return false
+	}
+	if err := config.CheckSubmittedSecret(svc.APIKey); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return false
+	}
+	return true
+}
+
+// applyFeatureChanges 按 JSON 字段名修改功能开关，未知字段返回错误
+func applyFeatureChanges(features *models.FeatureFlags, changes map[string]bool) error {
+	data, err := json.Marshal(features)
+	if err != nil {
+		return err
+	}
+	var values map[string]interface{}
+	if err := json.Unmarshal(data, &values); err != nil {
+		return err
+	}
+	for name, enabled := range changes {
+		if _, ok := values[name]; !ok {
+			return fmt.Errorf("未知的功能开关: %s", name)
+		}
+		values[name] = enabled
+	}
+	data, err = json.Marshal(values)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, features)
+}
+
+// maskServices 返回隐藏 API Key 的服务列表副本（密钥引用不是密钥本身，原样返回）
+func maskServices(services []models.Service) []models.Service {
+	masked := make([]models.Service, len(services))
+	copy(masked, services)
+	for i := range masked {
+		if masked[i].APIKey != "" && !config.IsSecretRef(masked[i].APIKey) {
+			masked[i].APIKey = maskedAPIKey
+		}
+	}
+	return masked
+}
+
+// maskHeaders 隐藏请求头的值（如 tracing.headers 中 OTLP 接收端的认证令牌），只保留名称
+func maskHeaders(headers map[string]string) map[string]string {
+	if len(headers) == 0 {
+		return headers
+	}
+	masked := make(map[string]string, len(headers))
+	for key := range headers {
+		masked[key] = maskedAPIKey
+	}
+	return masked
+}
@@ -0,0 +1,83 @@
+package proxy
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/ethan/claude-proxy/internal/budget"
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// levelCeilingKey 请求允许的最高难度等级在 gin.Context 中的键（预算降级时设置）
+const levelCeilingKey = "cce.level_ceiling"
+
+// withinLevelCeiling 预算降级时去掉候选链中高于降级等级的服务（自动切换的候选链包含更高等级的服务）
+// levels 为候选服务对应的难度等级，没有等级的服务（手动指定的服务）保留
+func withinLevelCeiling(c *gin.Context, services []*models.Service, levels map[string]int) []*models.Service {
+	ceiling := c.GetInt(levelCeilingKey)
+	if ceiling <= 0 {
+		return services
+	}
+	within := make([]*models.Service, 0, len(services))
+	for _, svc := range services {
+		if level, ok := levels[svc.ID]; ok && level > ceiling {
+			continue
+		}
+		within = append(within, svc)
+	}
+	return within
+}
+
+// openBudgets 按配置打开预算计数文件
+func (s *Server) openBudgets() error {
+	cfg := config.Get().Budgets
+	if !cfg.Enabled {
+		return nil
+	}
+
+	tracker, err := budget.Open(cfg.StatePath)
+	if err != nil {
+		return err
+	}
+	s.handler.budgets.Store(tracker)
+
+	logger.LogInfo("预算与限流已启用",
+		"state_path", cfg.StatePath,
+		"downgrade_level", cfg.DowngradeLevel,
+	)
+	return nil
+}
+
+// closeBudgets 保存并关闭预算计数文件
+func (s *Server) closeBudgets() {
+	tracker := s.handler.budgets.Swap(nil)
+	if tracker == nil {
+		return
+	}
+	if err := tracker.Close(); err != nil {
+		logger.LogError("关闭预算计数失败", err)
+	}
+}
+
+// reloadBudgets 热加载后 budgets.enabled 或 state_path 有变化时重新打开预算计数文件
+func (s *Server) reloadBudgets(oldCfg, newCfg *models.Config) {
+	if oldCfg.Budgets.Enabled == newCfg.Budgets.Enabled && oldCfg.Budgets.StatePath == newCfg.Budgets.StatePath {
+		return
+	}
+	s.closeBudgets()
+	if err := s.openBudgets(); err != nil {
+		logger.LogError("打开预算计数失败，预算与限流未生效", err, "state_path", newCfg.Budgets.StatePath)
+	}
+	if !newCfg.Budgets.Enabled {
+		logger.LogInfo("预算与限流已停用")
+	}
+}
+
+// budgetsSnapshot 各用户当日和当月的用量（用于 /status），未启用时返回 nil
+func (s *Server) budgetsSnapshot() interface{} {
+	tracker := s.handler.budgets.Load()
+	if tracker == nil {
+		return nil
+	}
+	return tracker.Snapshot()
+}
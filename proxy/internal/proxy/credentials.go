@@ -0,0 +1,35 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// clientCredentialHeaders 客户端自带的凭据头（规范化名称），只有 passthrough 的服务才会收到
+var clientCredentialHeaders = map[string]bool{
+	"Authorization": true,
+	"X-Api-Key":     true,
+}
+
+// isCredentialHeader 判断请求头是否为凭据：客户端凭据头，或任一服务自定义的认证头
+func isCredentialHeader(cfg *models.Config, key string) bool {
+	if clientCredentialHeaders[key] {
+		return true
+	}
+	for _, svc := range cfg.Services {
+		if svc.AuthScheme == models.AuthSchemeHeader && key == http.CanonicalHeaderKey(svc.AuthHeader) {
+			return true
+		}
+	}
+	return false
+}
+
+// setCredential 设置发送给服务的认证头；passthrough 时保留已转发的客户端凭据
+func setCredential(header http.Header, service *models.Service) {
+	name, value := service.Credential()
+	if name == "" {
+		return
+	}
+	header.Set(name, value)
+}
@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// writeError 返回 Anthropic 格式的错误响应，客户端（如 Claude Code）可以按错误类型处理和重试
+func writeError(c *gin.Context, status int, message string) {
+	c.JSON(status, models.ErrorEnvelope(status, message))
+}
+
+// respondError 返回错误响应；响应已开始输出时不能再修改状态码，流式响应补发 SSE error 事件，客户端据此重试
+func respondError(c *gin.Context, status int, message string) {
+	if c.Writer.Written() {
+		if strings.HasPrefix(c.Writer.Header().Get("Content-Type"), "text/event-stream") {
+			writeStreamError(c, status, message)
+		}
+		return
+	}
+	writeError(c, status, message)
+}
+
+// writeStreamError 流式响应已开始输出时，以 SSE error 事件结束流（与 Anthropic 流式接口中途出错的格式一致）
+func writeStreamError(c *gin.Context, status int, message string) {
+	payload, err := json.Marshal(models.ErrorEnvelope(status, message))
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(c.Writer, "event: error\ndata: %s\n\n", payload)
+	c.Writer.Flush()
+}
+
+// upstreamErrorBody 上游的错误响应不是 Anthropic 错误格式时（如网关返回的 HTML 页面）转换为 Anthropic 格式
+// 返回的 bool 表示是否做了转换
+func upstreamErrorBody(status int, body []byte) ([]byte, bool) {
+	var parsed struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	valid := json.Unmarshal(body, &parsed) == nil
+	if valid && parsed.Type == "error" && parsed.Error.Type != "" {
+		return body, false
+	}
+
+	// 其他格式的错误（如 OpenAI 格式的 {"error":{"message":...}}）取其中的错误信息，否则使用响应体文本
+	message := strings.TrimSpace(string(body))
+	if valid && parsed.Error.Message != "" {
+		message = parsed.Error.Message
+	}
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	translated, err := json.Marshal(models.ErrorEnvelope(status, message))
+	if err != nil {
+		return body, false
+	}
+	return translated, true
+}
+
+// normalizeErrorResponse 转换上游错误响应的响应体，并修正已复制的 Content-Type、Content-Length
+// 压缩过的响应体无法解析，原样返回
+func normalizeErrorResponse(c *gin.Context, resp *http.Response, body []byte) []byte {
+	if resp.Header.Get("Content-Encoding") != "" {
+		return body
+	}
+	translated, ok := upstreamErrorBody(resp.StatusCode, body)
+	if !ok {
+		return body
+	}
+	c.Header("Content-Type", "application/json")
+	c.Header("Content-Length", strconv.Itoa(len(translated)))
+	return translated
+}
+
+// errorStatus 错误对应的状态码和返回给客户端的错误信息，未标明状态码的错误视为代理内部错误
+func errorStatus(err error) (int, string) {
+	var statusErr *statusError
+	if errors.As(err, &statusErr) {
+		return statusErr.status, statusErr.message
+	}
+	return http.StatusInternalServerError, err.Error()
+}
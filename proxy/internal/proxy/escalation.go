@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/ledger"
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/metrics"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// forwardWithEscalation 转发请求，低等级服务失败时升级到更高一级的服务重试
+// 非流式请求读取完整响应体后判断（返回的 body 即响应体）；流式请求只在首个事件写给客户端之前判断，
+// 因此对客户端完全透明。升级后更新请求记录的难度等级，并记入会话上下文，使后续评估偏高
+// 启用 service_auto_switch 时故障转移链已包含更高等级的服务，升级时跳过已尝试过的服务
+func (h *Handler) forwardWithEscalation(c *gin.Context, candidates []*models.Service, requestBody []byte, stream bool, rec *ledger.Record) (*upstreamResult, []byte, error) {
+	cfg := config.FromContext(c.Request.Context())
+	level := rec.DifficultyLevel
+	attempted := make(map[string]bool)
+
+	for steps := 0; ; steps++ {
+		result, err := h.forwardWithFailover(c, candidates, requestBody, stream, level, rec.UserID, rec.SessionID, attempted)
+		var body []byte
+		if err == nil && !stream {
+			body, err = io.ReadAll(result.resp.Body)
+			result.resp.Body.Close()
+			if err != nil {
+				result.release()
+				result, err = nil, &statusError{status: http.StatusBadGateway, message: fmt.Sprintf("读取响应体失败: %v", err)}
+			}
+		}
+
+		reason := escalationReason(result, body, err)
+		// 手动指定的服务不在难度映射中时没有等级（level 为0），不升级
+		if reason == "" || !cfg.Escalation.Enabled || level < 1 || level > cfg.Escalation.MaxFromLevel || level >= 5 || steps >= cfg.Escalation.MaxSteps {
+			return result, body, err
+		}
+		// 不升级到访问令牌不允许的等级或服务，也不超过预算降级后的等级
+		token := accessToken(c)
+		if token != nil && !token.AllowsLevel(level+1) {
+			return result, body, err
+		}
+		if ceiling := c.GetInt(levelCeilingKey); ceiling > 0 && level+1 > ceiling {
+			return result, body, err
+		}
+		next, nextLevels, selectErr := h.selectCandidates(cfg, level+1)
+		next = unattempted(withinLevelCeiling(c, permittedServices(token, next, nextLevels), nextLevels), attempted)
+		if selectErr != nil || len(next) == 0 {
+			return result, body, err
+		}
+
+		// 放弃当前结果，在更高一级的服务上重试
+		fromService := "none"
+		if result != nil {
+			fromService = result.service.ID
+			if stream {
+				result.resp.Body.Close()
+			}
+			result.release()
+		}
+		logger.LogWarn("上游失败，升级难度等级重试",
+			"user_id", rec.UserID,
+			"session_id", rec.SessionID,
+			"from_level", level,
+			"to_level", level+1,
+			"from_service", fromService,
+			"reason", reason,
+		)
+		metrics.Escalations.WithLabelValues(strconv.Itoa(level), strconv.Itoa(level+1)).Inc()
+		h.evaluatorClient.GetContextManager().RecordEscalation(rec.UserID, rec.SessionID, level+1, cfg.Escalation.BiasTurns)
+
+		if rec.EscalatedFrom == 0 {
+			rec.EscalatedFrom = level
+		}
+		level++
+		rec.DifficultyLevel = level
+		candidates = next
+	}
+}
+
+// unattempted 去掉本次请求已尝试过的服务，保持原顺序
+func unattempted(services []*models.Service, attempted map[string]bool) []*models.Service {
+	remaining := make([]*models.Service, 0, len(services))
+	for _, svc := range services {
+		if !attempted[svc.ID] {
+			remaining = append(remaining, svc)
+		}
+	}
+	return remaining
+}
+
+// escalationReason 判断上游结果是否应升级重试，返回原因；正常结果返回空字符串
+// 失败包括：请求错误或超时、5xx/429、overloaded_error、stop_reason 为 max_tokens 且没有任何内容
+func escalationReason(result *upstreamResult, body []byte, err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	if isRetryableStatus(result.resp.StatusCode) {
+		return fmt.Sprintf("status=%d", result.resp.StatusCode)
+	}
+	if result.firstEvent != nil {
+		if isSSEErrorEvent(result.firstEvent) {
+			return "stream error event"
+		}
+		// 部分上游对流式请求直接返回 JSON 错误体
+		if bytes.Contains(result.firstEvent, []byte(`"overloaded_error"`)) {
+			return "overloaded_error"
+		}
+	}
+	if body == nil || result.resp.StatusCode != http.StatusOK {
+		return ""
+	}
+
+	var resp struct {
+		Type  string `json:"type"`
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+		StopReason string `json:"stop_reason"`
+		Content    []struct {
+			Type string `json:"type"`
+			Text string `json:"text"`
+		} `json:"content"`
+	}
+	if json.Unmarshal(body, &resp) != nil {
+		return ""
+	}
+	if resp.Type == "error" && resp.Error.Type == "overloaded_error" {
+		return "overloaded_error"
+	}
+	if resp.StopReason == "max_tokens" {
+		// 只有 thinking 没有正文同样视为空响应
+		for _, block := range resp.Content {
+			if block.Type == "tool_use" || strings.TrimSpace(block.Text) != "" {
+				return ""
+			}
+		}
+		return "max_tokens with empty content"
+	}
+	return ""
+}
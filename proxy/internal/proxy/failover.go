@@ -0,0 +1,271 @@
+package proxy
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/metrics"
+	"github.com/ethan/claude-proxy/internal/models"
+	"github.com/ethan/claude-proxy/internal/tracing"
+)
+
+// SwitchEvent 一次服务切换记录
+type SwitchEvent struct {
+	Time            time.Time `json:"time"`
+	UserID          string    `json:"user_id"`
+	SessionID       string    `json:"session_id"`
+	DifficultyLevel int       `json:"difficulty_level"`
+	FromService     string    `json:"from_service"`
+	ToService       string    `json:"to_service"`
+	Reason          string    `json:"reason"`
+}
+
+// SwitchStats 服务切换统计
+type SwitchStats struct {
+	mu          sync.RWMutex
+	total       int64
+	fromService map[string]int64 // key: 切换前（失败）的服务ID
+	recent      []SwitchEvent
+	maxRecent   int
+}
+
+// NewSwitchStats 创建服务切换统计
+func NewSwitchStats() *SwitchStats {
+	return &SwitchStats{
+		fromService: make(map[string]int64),
+		maxRecent:   20, // 保留最近20次切换记录
+	}
+}
+
+// Record 记录一次服务切换
+func (s *SwitchStats) Record(event SwitchEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.total++
+	s.fromService[event.FromService]++
+	s.recent = append(s.recent, event)
+	if len(s.recent) > s.maxRecent {
+		s.recent = s.recent[len(s.recent)-s.maxRecent:]
+	}
+}
+
+// Snapshot 获取统计快照（用于 /status）
+func (s *SwitchStats) Snapshot() gin.H {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	fromService := make(map[string]int64, len(s.fromService))
+	for id, count := range s.fromService {
+		fromService[id] = count
+	}
+	recent := make([]SwitchEvent, len(s.recent))
+	copy(recent, s.recent)
+
+	return gin.H{
+		"total_switches": s.total,
+		"by_service":     fromService,
+		"recent":         recent,
+	}
+}
+
+// upstreamResult 上游请求结果
+type upstreamResult struct {
+	service    *models.Service
+	resp       *http.Response
+	reader     *bufio.Reader // 仅流式：包装 resp.Body 的读取器
+	firstEvent []byte        // 仅流式：已预读、尚未写给客户端的第一个 SSE 事件
+	release    func()        // 释放该服务的进行中计数，请求结束时调用
+}
+
+// forwardWithFailover 按候选顺序向上游转发请求
+// 连接错误、5xx、429 会切换到下一个候选服务；流式请求额外预读第一个 SSE 事件，
+// 只有在该事件写给客户端之前才允许切换，保证客户端不会收到两个服务的混合输出；
+// attempted 记录本次请求已尝试过的服务，失败升级时不再重试这些服务
+func (h *Handler) forwardWithFailover(c *gin.Context, candidates []*models.Service, requestBody []byte, stream bool, difficultyLevel int, userID, sessionID string, attempted map[string]bool) (*upstreamResult, error) {
+	client := &http.Client{Timeout: time.Duration(config.FromContext(c.Request.Context()).Proxy.RequestTimeout) * time.Second}
+
+	for i, svc := range candidates {
+		attempted[svc.ID] = true
+		var next *models.Service
+		if i < len(candidates)-1 {
+			next = candidates[i+1]
+		}
+
+		// 每次尝试一个 span，连接和首字节由 httptrace 挂载为子 span
+		attrs := append(tracing.UserAttributes(userID, sessionID),
+			attribute.String("cce.service_id", svc.ID),
+			attribute.Int("cce.attempt", i+1),
+			attribute.Bool("cce.stream", stream),
+		)
+		attemptCtx, attemptSpan := tracing.Start(c.Request.Context(), "upstream.attempt", attrs...)
+		httpCtx, finishHTTPTrace := tracing.WithHTTPTrace(attemptCtx, attrs...)
+		endAttempt := func(err error) {
+			finishHTTPTrace(err)
+			tracing.End(attemptSpan, err)
+		}
+
+		// 创建目标请求
+		req, err := h.createTargetRequest(c.Request, svc, requestBody)
+		if err != nil {
+			endAttempt(err)
+			if next != nil {
+				h.switchService(svc, next, err.Error(), difficultyLevel, userID, sessionID)
+				continue
+			}
+			return nil, err
+		}
+		req = req.WithContext(httpCtx)
+
+		// 熔断器拒绝（例如半开试探名额已被占用）时直接尝试下一个
+		// 在请求创建成功、即将发送时才占用试探名额，之后每条路径都会记录成功或失败
+		if !h.breakers.Allow(svc.ID) {
+			err := &statusError{status: http.StatusServiceUnavailable, message: fmt.Sprintf("目标服务 %s 处于熔断状态", svc.ID)}
+			endAttempt(err)
+			if next != nil {
+				h.switchService(svc, next, "circuit open", difficultyLevel, userID, sessionID)
+				continue
+			}
+			return nil, err
+		}
+
+		// 发送请求
+		release := h.balancer.Acquire(svc.ID)
+		sentAt := time.Now()
+		resp, err := client.Do(req)
+		if err == nil {
+			// 非 Anthropic 协议的服务先转换响应，之后统一按 Anthropic 格式判断
+			err = translateResponse(svc, resp)
+		}
+		if err != nil {
+			release()
+			h.breakers.RecordFailure(svc.ID, err.Error())
+			endAttempt(err)
+			if next != nil {
+				h.switchService(svc, next, err.Error(), difficultyLevel, userID, sessionID)
+				continue
+			}
+			return nil, &statusError{status: http.StatusBadGateway, message: fmt.Sprintf("请求目标服务失败: %v", err)}
+		}
+		attemptSpan.SetAttributes(attribute.Int("http.status_code", resp.StatusCode))
+
+		latency := time.Since(sentAt)
+		if !stream || resp.StatusCode != http.StatusOK {
+			metrics.ObserveTTFB(svc.ID, stream, latency)
+		}
+		if isRetryableStatus(resp.StatusCode) {
+			h.breakers.RecordFailure(svc.ID, fmt.Sprintf("status=%d", resp.StatusCode))
+			if next != nil {
+				resp.Body.Close()
+				release()
+				endAttempt(fmt.Errorf("status=%d", resp.StatusCode))
+				h.switchService(svc, next, fmt.Sprintf("status=%d", resp.StatusCode), difficultyLevel, userID, sessionID)
+				continue
+			}
+		}
+
+		result := &upstreamResult{service: svc, resp: resp, release: release}
+		if !stream || resp.StatusCode != http.StatusOK {
+			if !isRetryableStatus(resp.StatusCode) {
+				h.breakers.RecordSuccess(svc.ID, latency)
+				endAttempt(nil)
+			} else {
+				endAttempt(fmt.Errorf("status=%d", resp.StatusCode))
+			}
+			return result, nil
+		}
+
+		// 流式：预读第一个事件，在写给客户端之前确认上游确实开始正常输出
+		result.reader = bufio.NewReader(resp.Body)
+		firstEvent, err := readSSEEvent(result.reader)
+		metrics.ObserveTTFB(svc.ID, stream, time.Since(sentAt))
+		attemptSpan.AddEvent("first_event")
+		if err != nil || isSSEErrorEvent(firstEvent) {
+			reason := "stream error event"
+			if err != nil {
+				reason = fmt.Sprintf("读取首个流式事件失败: %v", err)
+			}
+			h.breakers.RecordFailure(svc.ID, reason)
+			endAttempt(fmt.Errorf("%s", reason))
+			if next != nil {
+				resp.Body.Close()
+				release()
+				h.switchService(svc, next, reason, difficultyLevel, userID, sessionID)
+				continue
+			}
+		} else {
+			h.breakers.RecordSuccess(svc.ID, latency)
+			endAttempt(nil)
+		}
+		result.firstEvent = firstEvent
+
+		return result, nil
+	}
+
+	return nil, &statusError{status: http.StatusServiceUnavailable, message: "没有可用的目标服务"}
+}
+
+// switchService 记录一次服务切换
+func (h *Handler) switchService(from, to *models.Service, reason string, difficultyLevel int, userID, sessionID string) {
+	logger.LogServiceSwitch(userID, sessionID, difficultyLevel, from.ID, to.ID, reason)
+
+	h.switchStats.Record(SwitchEvent{
+		Time:            time.Now(),
+		UserID:          userID,
+		SessionID:       sessionID,
+		DifficultyLevel: difficultyLevel,
+		FromService:     from.ID,
+		ToService:       to.ID,
+		Reason:          reason,
+	})
+}
+
+// isRetryableStatus 判断上游状态码是否应切换服务
+func isRetryableStatus(statusCode int) bool {
+	return statusCode >= 500 || statusCode == http.StatusTooManyRequests
+}
+
+// readSSEEvent 读取一个完整的 SSE 事件（直到空行），返回原始字节
+func readSSEEvent(r *bufio.Reader) ([]byte, error) {
+	var event bytes.Buffer
+	for {
+		line, err := r.ReadBytes('\n')
+		event.Write(line)
+		if err != nil {
+			return event.Bytes(), err
+		}
+		if len(bytes.TrimRight(line, "\r\n")) == 0 && event.Len() > len(line) {
+			return event.Bytes(), nil
+		}
+	}
+}
+
+// isSSEErrorEvent 判断 SSE 事件是否为错误事件
+func isSSEErrorEvent(event []byte) bool {
+	return bytes.HasPrefix(event, []byte("event: error")) ||
+		bytes.Contains(event, []byte("\nevent: error"))
+}
+
+// availableServices 过滤掉处于熔断状态或主动探测不健康的服务，保持原顺序
+func (h *Handler) availableServices(services []*models.Service) []*models.Service {
+	available := make([]*models.Service, 0, len(services))
+	for _, svc := range services {
+		switch {
+		case !h.breakers.IsAvailable(svc.ID):
+			logger.LogDebug("跳过熔断中的服务", "service_id", svc.ID)
+		case !h.prober.IsHealthy(svc.ID):
+			logger.LogDebug("跳过探测不健康的服务", "service_id", svc.ID)
+		default:
+			available = append(available, svc)
+		}
+	}
+	return available
+}
@@ -5,31 +5,66 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"math"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"github.com/ethan/claude-proxy/internal/balancer"
+	"github.com/ethan/claude-proxy/internal/budget"
 	"github.com/ethan/claude-proxy/internal/config"
 	"github.com/ethan/claude-proxy/internal/evaluator"
+	"github.com/ethan/claude-proxy/internal/health"
+	"github.com/ethan/claude-proxy/internal/ledger"
 	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/metrics"
 	"github.com/ethan/claude-proxy/internal/models"
+	"github.com/ethan/claude-proxy/internal/tracing"
+	"github.com/ethan/claude-proxy/internal/transform"
+	"github.com/ethan/claude-proxy/internal/usage"
 )
 
 // Handler 代理处理器
 type Handler struct {
 	evaluatorClient *evaluator.Client
+	switchStats     *SwitchStats
+	balancer        *balancer.Balancer
+	breakers        *health.Breakers
+	prober          *health.Prober
+	usageStats      *usage.Stats
+	ledger          *ledger.Store                  // 本地请求记录，未启用时为 nil
+	budgets         atomic.Pointer[budget.Tracker] // 预算计数，未启用时为 nil（热加载时替换）
+	sticky          *StickySessions
 }
 
 // NewHandler 创建代理处理器
 func NewHandler() *Handler {
-	return &Handler{
+	h := &Handler{
 		evaluatorClient: evaluator.NewClient(),
+		switchStats:     NewSwitchStats(),
+		balancer:        balancer.New(),
+		breakers:        health.NewBreakers(),
+		prober:          health.NewProber(),
+		usageStats:      usage.NewStats(),
+		sticky:          NewStickySessions(),
 	}
+
+	// 评估调用本身也产生费用，计入统计（难度等级记为0，基准费用为0）
+	h.evaluatorClient.SetUsageRecorder(func(service *models.Service, u usage.Usage) {
+		h.usageStats.Record(service.ID, 0, u, u.Cost(service.Pricing), 0)
+	})
+
+	return h
 }
 
 // ProxyMiddleware 代理中间件
@@ -45,15 +80,26 @@ func (h *Handler) ProxyMiddleware() gin.HandlerFunc {
 		
 		// 处理代理请求
 		if err := h.handleProxyRequest(c, startTime); err != nil {
-			logger.LogError("代理请求失败", err,
-				"path", c.Request.URL.Path,
-				"method", c.Request.Method,
-			)
+			status, message := errorStatus(err)
+			if status < http.StatusInternalServerError {
+				logger.LogWarn("代理请求被拒绝",
+					"path", c.Request.URL.Path,
+					"status", status,
+					"reason", message,
+				)
+			} else {
+				logger.LogError("代理请求失败", err,
+					"path", c.Request.URL.Path,
+					"method", c.Request.Method,
+					"status", status,
+				)
+			}
 			
-			c.JSON(http.StatusInternalServerError, gin.H{
-				"error": "代理请求失败",
-				"details": err.Error(),
-			})
+			var statusErr *statusError
+			if errors.As(err, &statusErr) && statusErr.retryAfter > 0 {
+				c.Header("Retry-After", strconv.Itoa(int(math.Ceil(statusErr.retryAfter.Seconds()))))
+			}
+			respondError(c, status, message)
 		}
 	}
 }
@@ -82,27 +128,67 @@ func (h *Handler) shouldProxy(req *http.Request) bool {
 }
 
 // handleProxyRequest 处理代理请求
-func (h *Handler) handleProxyRequest(c *gin.Context, startTime time.Time) error {
-	// 读取请求体
-	var requestBody []byte
-	if c.Request.Body != nil {
-		body, err := io.ReadAll(c.Request.Body)
-		if err != nil {
-			return fmt.Errorf("读取请求体失败: %v", err)
-		}
-		requestBody = body
-		// 恢复请求体以便后续使用
-		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
-	}
+func (h *Handler) handleProxyRequest(c *gin.Context, startTime time.Time) (err error) {
+	// 链路追踪根 span，后续各阶段通过 c.Request.Context() 挂在其下
+	reqCtx, span := tracing.Start(tracing.Extract(c.Request.Context(), c.Request.Header), "proxy.request",
+		attribute.String("http.method", c.Request.Method),
+		attribute.String("http.path", c.Request.URL.Path),
+	)
+	defer func() {
+		tracing.End(span, err)
+	}()
 	
-	// 解析Claude请求
-	var claudeReq models.ClaudeRequest
-	if err := json.Unmarshal(requestBody, &claudeReq); err != nil {
-		return fmt.Errorf("解析请求体失败: %v", err)
+	// 整个请求使用同一份配置快照，热加载不影响处理中的请求
+	cfg := config.Get()
+	reqCtx = config.WithContext(reqCtx, cfg)
+	c.Request = c.Request.WithContext(reqCtx)
+	
+	// 读取并解析请求体
+	_, parseSpan := tracing.Start(reqCtx, "proxy.parse")
+	requestBody, claudeReq, err := parseClaudeRequest(c)
+	if err != nil {
+		tracing.End(parseSpan, err)
+		return err
 	}
 
-	// 提取用户信息
+	// 提取用户信息（使用访问令牌时以令牌对应的用户名为准）
 	userID, sessionID := models.ExtractUserInfo(claudeReq.Metadata)
+	token := accessToken(c)
+	if token != nil {
+		userID = token.User
+	}
+	userAttrs := tracing.UserAttributes(userID, sessionID)
+	parseSpan.SetAttributes(append(userAttrs,
+		attribute.String("cce.model", claudeReq.Model),
+		attribute.Int("cce.message_count", len(claudeReq.Messages)),
+	)...)
+	tracing.End(parseSpan, nil)
+	span.SetAttributes(append(userAttrs,
+		attribute.String("cce.model", claudeReq.Model),
+		attribute.Bool("cce.stream", claudeReq.Stream),
+	)...)
+
+	// 请求记录，处理结束时写入本地记录库
+	rec := &ledger.Record{
+		Timestamp: startTime,
+		UserID:    userID,
+		SessionID: sessionID,
+		Model:     claudeReq.Model,
+		Stream:    claudeReq.Stream,
+	}
+	defer func() {
+		h.saveRecord(rec, err)
+	}()
+
+	// 预算与限流：超出硬上限或每分钟限制时返回 429，超过费用软上限时在评估后降级
+	var softLimited string
+	if budgets := h.budgets.Load(); budgets != nil && cfg.Budgets.Enabled {
+		decision := budgets.Admit(&cfg.Budgets, userID, sessionID)
+		if decision.Rejected != "" {
+			return &statusError{status: http.StatusTooManyRequests, message: decision.Rejected, retryAfter: decision.RetryAfter}
+		}
+		softLimited = decision.SoftLimited
+	}
 
 	// 检测是否为 Warmup 请求
 	if models.IsWarmupRequest(&claudeReq) {
@@ -110,59 +196,272 @@ func (h *Handler) handleProxyRequest(c *gin.Context, startTime time.Time) error
 			"user_id", userID,
 			"session_id", sessionID,
 		)
+		rec.Warmup = true
 		// 调用专门的 Warmup 处理函数
-		return h.handleWarmupRequest(c, &claudeReq, requestBody, userID, sessionID, startTime)
+		return h.handleWarmupRequest(c, &claudeReq, requestBody, rec)
 	}
 	
-	// 调用决策者服务评估难度
-	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(config.Cfg.Proxy.EvaluatorTimeout)*time.Second)
-	defer cancel()
-	
-	evalResponse, err := h.evaluatorClient.EvaluateDifficulty(ctx, &claudeReq)
+	// 手动路由覆盖：请求头或提示标记指定了等级/服务时跳过评估（标记会从请求体中移除）
+	override, requestBody, err := h.resolveOverride(c, cfg, &claudeReq, requestBody, userID)
 	if err != nil {
-		logger.LogError("决策者服务评估失败", err,
+		return err
+	}
+	if override != nil {
+		if err := checkOverrideAccess(token, override); err != nil {
+			return err
+		}
+	}
+	
+	// 按请求的模型路由（如 haiku 发起的辅助请求），匹配时不评估，也不参与会话粘滞
+	var modelRule *models.ModelRoutingRule
+	if override == nil {
+		modelRule = matchModelRule(cfg, claudeReq.Model)
+	}
+	
+	// 会话粘滞：任务进行中（没有新的用户输入）沿用固定的服务，不重新评估
+	var pin *stickyPin
+	if override == nil && modelRule == nil && cfg.StickyRouting.Enabled {
+		pin = h.sticky.Lookup(userID, sessionID, &claudeReq, cfg.StickyRouting)
+		// 令牌权限变化后不再沿用不允许的服务；预算降级时不再沿用高等级的服务
+		if pin != nil && token != nil && (!token.AllowsLevel(pin.Level) || !token.AllowsService(pin.ServiceID)) {
+			pin = nil
+		}
+		if pin != nil && softLimited != "" && pin.Level > cfg.Budgets.DowngradeLevel {
+			pin = nil
+		}
+	}
+	
+	var evalResponse *models.EvaluatorResponse
+	if override != nil {
+		evalResponse = &models.EvaluatorResponse{
+			DifficultyLevel: override.Level,
+			Reasoning:       "手动指定: " + override.Source,
+			Strategy:        "override",
+		}
+	} else if modelRule != nil {
+		evalResponse = &models.EvaluatorResponse{
+			DifficultyLevel: modelRule.Level,
+			Reasoning:       fmt.Sprintf("模型路由: %s 匹配 %s", claudeReq.Model, modelRule.Pattern),
+			Strategy:        "model",
+		}
+	} else if pin != nil {
+		evalResponse = &models.EvaluatorResponse{
+			DifficultyLevel: pin.Level,
+			Reasoning:       fmt.Sprintf("会话粘滞: 沿用 %s（第 %d 轮）", pin.ServiceID, pin.Turns+1),
+			Strategy:        "sticky",
+		}
+	} else {
+		// 调用决策者服务评估难度（不随客户端断开而取消，但保留 trace 上下文）
+		ctx, cancel := context.WithTimeout(context.WithoutCancel(reqCtx), time.Duration(cfg.Proxy.EvaluatorTimeout)*time.Second)
+		defer cancel()
+		
+		evalStart := time.Now()
+		evalResponse, err = h.evaluatorClient.EvaluateDifficulty(ctx, &claudeReq)
+		metrics.ObserveEvaluator(time.Since(evalStart), err)
+		if err != nil {
+			logger.LogError("决策者服务评估失败", err,
+				"user_id", userID,
+				"session_id", sessionID,
+			)
+			return &statusError{status: http.StatusBadGateway, message: fmt.Sprintf("决策者服务评估失败: %v", err)}
+		}
+	}
+	
+	// 访问令牌限制了难度等级或服务时改用允许的最接近等级（评估结果可能来自缓存，不直接修改）
+	// 手动覆盖已在前面校验过权限
+	if level := permittedLevel(cfg, token, evalResponse.DifficultyLevel); override == nil && level != evalResponse.DifficultyLevel {
+		logger.LogInfo("难度等级受访问令牌限制",
 			"user_id", userID,
 			"session_id", sessionID,
+			"from_level", evalResponse.DifficultyLevel,
+			"to_level", level,
 		)
-		return fmt.Errorf("决策者服务评估失败: %v", err)
+		// 改用单独的策略名，受限后的等级不是评估结果，不能作为分类器的训练标注
+		limited := *evalResponse
+		limited.DifficultyLevel = level
+		limited.Strategy = "access"
+		limited.Reasoning = fmt.Sprintf("%s（受访问令牌限制，等级 %d -> %d）", evalResponse.Reasoning, evalResponse.DifficultyLevel, level)
+		evalResponse = &limited
 	}
 	
-	// 记录决策结果
-	if config.Cfg.Features.RequestLogging {
-		logger.LogEvaluatorRequest(userID, sessionID, evalResponse.DifficultyLevel, evalResponse.Reasoning, time.Since(startTime))
+	// 超过费用软上限时降级（手动指定服务时不降级），失败升级也不超过降级后的等级
+	if softLimited != "" && (override == nil || override.ServiceID == "") {
+		c.Set(levelCeilingKey, cfg.Budgets.DowngradeLevel)
+		if evalResponse.DifficultyLevel > cfg.Budgets.DowngradeLevel {
+			logger.LogInfo("超过预算软上限，降级难度等级",
+				"user_id", userID,
+				"session_id", sessionID,
+				"from_level", evalResponse.DifficultyLevel,
+				"to_level", cfg.Budgets.DowngradeLevel,
+				"reason", softLimited,
+			)
+			limited := *evalResponse
+			limited.DifficultyLevel = cfg.Budgets.DowngradeLevel
+			limited.Strategy = "budget"
+			limited.Reasoning = fmt.Sprintf("%s（预算降级: %s）", evalResponse.Reasoning, softLimited)
+			evalResponse = &limited
+		}
 	}
 	
-	// 根据难度等级获取目标服务
-	targetServiceID, ok := config.Cfg.DifficultyMapping[fmt.Sprintf("%d", evalResponse.DifficultyLevel)]
-	if !ok {
-		return fmt.Errorf("未配置难度等级 %d 的服务映射", evalResponse.DifficultyLevel)
+	rec.DifficultyLevel = evalResponse.DifficultyLevel
+	rec.Reasoning = evalResponse.Reasoning
+	rec.Strategy = evalResponse.Strategy
+	span.SetAttributes(attribute.Int("cce.difficulty_level", evalResponse.DifficultyLevel))
+	rec.Intent = truncateText(evaluator.ExtractUserIntent(claudeReq.Messages), 500)
+	
+	// 记录决策结果
+	if cfg.Features.RequestLogging {
+		logger.LogEvaluatorRequest(userID, sessionID, evalResponse.DifficultyLevel, evalResponse.Reasoning, evalResponse.Strategy, rec.Intent, time.Since(startTime))
 	}
 	
-	targetService, err := config.GetServiceByID(targetServiceID)
+	// 根据难度等级选择候选服务
+	_, selectSpan := tracing.Start(reqCtx, "proxy.select_service", append(userAttrs,
+		attribute.Int("cce.difficulty_level", evalResponse.DifficultyLevel),
+	)...)
+	var candidates []*models.Service
+	var levels map[string]int
+	if override != nil && override.ServiceID != "" {
+		candidates, err = h.overrideCandidates(cfg, override)
+	} else if pin != nil {
+		candidates, levels, err = h.stickyCandidates(cfg, pin)
+	} else {
+		candidates, levels, err = h.selectCandidates(cfg, evalResponse.DifficultyLevel)
+	}
+	if err == nil && token != nil {
+		if candidates = permittedServices(token, candidates, levels); len(candidates) == 0 {
+			err = &statusError{status: http.StatusForbidden, message: fmt.Sprintf("难度等级 %d 没有访问令牌允许使用的服务", evalResponse.DifficultyLevel)}
+		}
+	}
+	if err == nil {
+		if candidates = withinLevelCeiling(c, candidates, levels); len(candidates) == 0 {
+			err = &statusError{status: http.StatusServiceUnavailable, message: fmt.Sprintf("预算降级后难度等级 %d 以内没有可用的服务", cfg.Budgets.DowngradeLevel)}
+		}
+	}
+	if err == nil {
+		selectSpan.SetAttributes(attribute.StringSlice("cce.candidates", serviceIDs(candidates)))
+	}
+	tracing.End(selectSpan, err)
 	if err != nil {
-		return fmt.Errorf("获取目标服务失败: %v", err)
+		return err
 	}
 	
 	// 转发请求到目标服务
 	if claudeReq.Stream {
 		// 处理流式响应
-		return h.handleStreamingProxy(c, targetService, requestBody, userID, sessionID, startTime)
+		err = h.handleStreamingProxy(c, candidates, requestBody, rec)
 	} else {
 		// 处理普通响应
-		return h.handleNormalProxy(c, targetService, requestBody, userID, sessionID, startTime)
+		err = h.handleNormalProxy(c, candidates, requestBody, rec)
+	}
+	
+	// 请求成功后固定会话使用的服务（沿用固定时若升级了服务，则按升级后的等级固定）
+	// 手动覆盖只作用于当前请求，不固定
+	if err == nil && cfg.StickyRouting.Enabled && override == nil && modelRule == nil && rec.ServiceID != "" && rec.StatusCode < 400 {
+		level := rec.DifficultyLevel
+		if l, ok := levels[rec.ServiceID]; ok && l > level {
+			level = l
+		}
+		if pin != nil && rec.ServiceID != pin.ServiceID {
+			logger.LogWarn("固定的服务失败，已升级难度等级",
+				"user_id", userID,
+				"session_id", sessionID,
+				"from_service", pin.ServiceID,
+				"to_service", rec.ServiceID,
+				"level", level,
+			)
+		}
+		h.sticky.Pin(userID, sessionID, level, rec.ServiceID, pin != nil, cfg.StickyRouting)
 	}
+	return err
+}
+
+// parseClaudeRequest 读取并解析 Claude 请求体
+func parseClaudeRequest(c *gin.Context) ([]byte, models.ClaudeRequest, error) {
+	var claudeReq models.ClaudeRequest
+
+	// 读取请求体
+	var requestBody []byte
+	if c.Request.Body != nil {
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			return nil, claudeReq, &statusError{status: http.StatusBadRequest, message: fmt.Sprintf("读取请求体失败: %v", err)}
+		}
+		requestBody = body
+		// 恢复请求体以便后续使用
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(body))
+	}
+
+	// 解析Claude请求
+	if err := json.Unmarshal(requestBody, &claudeReq); err != nil {
+		return nil, claudeReq, &statusError{status: http.StatusBadRequest, message: fmt.Sprintf("解析请求体失败: %v", err)}
+	}
+
+	return requestBody, claudeReq, nil
+}
+
+// selectCandidates 根据难度等级获取服务池，按负载均衡策略排序后生成候选服务链
+// levels 为每个候选服务对应的难度等级（候选链包含其他等级的服务）
+func (h *Handler) selectCandidates(cfg *models.Config, difficultyLevel int) ([]*models.Service, map[string]int, error) {
+	pool, err := config.GetLevelTargets(cfg, difficultyLevel)
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取目标服务失败: %v", err)
+	}
+	levelKey := fmt.Sprintf("%d", difficultyLevel)
+	pool = h.balancer.Order(levelKey, cfg.LoadBalancing.StrategyFor(levelKey), pool)
+
+	candidates, levels, err := config.GetServiceChain(cfg, difficultyLevel, pool)
+	if err != nil {
+		return nil, nil, fmt.Errorf("获取目标服务失败: %v", err)
+	}
+
+	// 跳过处于熔断状态或探测不健康的服务
+	candidates = h.availableServices(candidates)
+	if len(candidates) == 0 {
+		return nil, nil, &statusError{status: http.StatusServiceUnavailable, message: fmt.Sprintf("难度等级 %d 的候选服务均不可用（熔断或探测失败）", difficultyLevel)}
+	}
+
+	// 未开启自动切换时只使用映射的服务
+	if !cfg.Features.ServiceAutoSwitch {
+		candidates = candidates[:1]
+	}
+
+	return candidates, levels, nil
+}
+
+// serviceIDs 提取服务ID列表
+func serviceIDs(services []*models.Service) []string {
+	ids := make([]string, 0, len(services))
+	for _, svc := range services {
+		ids = append(ids, svc.ID)
+	}
+	return ids
 }
 
 // handleWarmupRequest 处理 Warmup 预热请求（广播式）
 // 将 Warmup 请求并发发送到所有 executor 服务，确保所有服务都完成预热
-func (h *Handler) handleWarmupRequest(c *gin.Context, claudeReq *models.ClaudeRequest, requestBody []byte, userID, sessionID string, startTime time.Time) error {
+func (h *Handler) handleWarmupRequest(c *gin.Context, claudeReq *models.ClaudeRequest, requestBody []byte, rec *ledger.Record) error {
+	userID, sessionID, startTime := rec.UserID, rec.SessionID, rec.Timestamp
+	cfg := config.FromContext(c.Request.Context())
+
 	// 获取所有 executor 服务
-	executors, err := config.GetAllExecutorServices()
+	executors, err := config.GetAllExecutorServices(cfg)
 	if err != nil {
 		logger.LogError("获取执行者服务列表失败", err)
 		return fmt.Errorf("获取执行者服务列表失败: %v", err)
 	}
 
+	// 只预热访问令牌允许的服务
+	executors = permittedServices(accessToken(c), executors, nil)
+	if len(executors) == 0 {
+		return &statusError{status: http.StatusForbidden, message: "没有访问令牌允许使用的执行者服务"}
+	}
+
+	// 跳过处于熔断状态或探测不健康的服务
+	executors = h.availableServices(executors)
+	if len(executors) == 0 {
+		return &statusError{status: http.StatusServiceUnavailable, message: "所有执行者服务均不可用（熔断或探测失败）"}
+	}
+
 	logger.LogInfo("开始广播式预热",
 		"service_count", len(executors),
 		"user_id", userID,
@@ -189,18 +488,31 @@ func (h *Handler) handleWarmupRequest(c *gin.Context, claudeReq *models.ClaudeRe
 			req, err := h.createTargetRequest(c.Request, svc, requestBody)
 			if err != nil {
 				logger.LogError("创建 Warmup 请求失败", err, "service", svc.Name)
+				metrics.WarmupServiceResults.WithLabelValues(svc.ID, "failed").Inc()
 				resultChan <- warmupResult{service: svc, err: err}
 				return
 			}
 
 			// 发送请求（设置10秒超时）
 			client := &http.Client{Timeout: 10 * time.Second}
+			sentAt := time.Now()
 			resp, err := client.Do(req)
+			if err == nil {
+				err = translateResponse(svc, resp)
+			}
 			if err != nil {
 				logger.LogWarn("Warmup 请求失败", "service", svc.Name, "error", err)
+				h.breakers.RecordFailure(svc.ID, err.Error())
+				metrics.WarmupServiceResults.WithLabelValues(svc.ID, "failed").Inc()
 				resultChan <- warmupResult{service: svc, err: err}
 				return
 			}
+			if isRetryableStatus(resp.StatusCode) {
+				h.breakers.RecordFailure(svc.ID, fmt.Sprintf("status=%d", resp.StatusCode))
+			} else {
+				h.breakers.RecordSuccess(svc.ID, time.Since(sentAt))
+			}
+			metrics.WarmupServiceResults.WithLabelValues(svc.ID, "success").Inc()
 
 			logger.LogInfo("Warmup 请求成功", "service", svc.Name, "status", resp.StatusCode)
 			resultChan <- warmupResult{service: svc, response: resp, err: nil}
@@ -236,6 +548,12 @@ func (h *Handler) handleWarmupRequest(c *gin.Context, claudeReq *models.ClaudeRe
 	}
 
 	// 记录统计信息
+	metrics.ObserveWarmup(successCount, failCount)
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(
+		attribute.Bool("cce.warmup", true),
+		attribute.Int("cce.warmup_success", successCount),
+		attribute.Int("cce.warmup_failed", failCount),
+	)
 	logger.LogInfo("Warmup 预热完成",
 		"total", len(executors),
 		"success", successCount,
@@ -245,10 +563,12 @@ func (h *Handler) handleWarmupRequest(c *gin.Context, claudeReq *models.ClaudeRe
 
 	// 如果没有任何服务成功，返回错误
 	if firstSuccessResponse == nil {
-		return fmt.Errorf("所有服务的 Warmup 请求都失败了")
+		return &statusError{status: http.StatusBadGateway, message: "所有服务的 Warmup 请求都失败了"}
 	}
 
 	defer firstSuccessResponse.Body.Close()
+	rec.ServiceID = firstSuccessService.ID
+	rec.StatusCode = firstSuccessResponse.StatusCode
 
 	// 根据请求类型返回响应
 	if claudeReq.Stream {
@@ -308,7 +628,7 @@ func (h *Handler) handleWarmupRequest(c *gin.Context, claudeReq *models.ClaudeRe
 	}
 
 	// 记录请求日志
-	if config.Cfg.Features.RequestLogging {
+	if cfg.Features.RequestLogging {
 		logger.LogRequest(userID, sessionID, "WARMUP", c.Request.URL.Path, string(requestBody), firstSuccessResponse.StatusCode, time.Since(startTime))
 	}
 
@@ -316,24 +636,18 @@ func (h *Handler) handleWarmupRequest(c *gin.Context, claudeReq *models.ClaudeRe
 }
 
 // handleNormalProxy 处理普通响应的代理
-func (h *Handler) handleNormalProxy(c *gin.Context, service *models.Service, requestBody []byte, userID, sessionID string, startTime time.Time) error {
-	// 创建目标请求
-	req, err := h.createTargetRequest(c.Request, service, requestBody)
-	if err != nil {
-		return err
-	}
+func (h *Handler) handleNormalProxy(c *gin.Context, candidates []*models.Service, requestBody []byte, rec *ledger.Record) error {
+	cfg := config.FromContext(c.Request.Context())
 	
-	// 发送请求
-	client := &http.Client{Timeout: time.Duration(config.Cfg.Proxy.RequestTimeout) * time.Second}
-	resp, err := client.Do(req)
+	// 发送请求（失败时按候选顺序切换服务，仍失败时按配置升级难度等级）
+	result, respBody, err := h.forwardWithEscalation(c, candidates, requestBody, false, rec)
 	if err != nil {
-		// 如果启用了服务自动切换，这里可以实现切换逻辑
-		if config.Cfg.Features.ServiceAutoSwitch {
-			logger.LogWarn("目标服务不可用，尝试切换", "service_id", service.ID, "error", err)
-			// TODO: 实现服务切换逻辑
-		}
-		return fmt.Errorf("请求目标服务失败: %v", err)
+		return err
 	}
+	rec.ServiceID = result.service.ID
+	rec.StatusCode = result.resp.StatusCode
+	defer result.release()
+	resp := result.resp
 	defer resp.Body.Close()
 	
 	// 复制响应头
@@ -346,39 +660,60 @@ func (h *Handler) handleNormalProxy(c *gin.Context, service *models.Service, req
 	// 设置状态码
 	c.Status(resp.StatusCode)
 	
-	// 复制响应体
-	_, err = io.Copy(c.Writer, resp.Body)
-	if err != nil {
+	// 上游的错误响应统一为 Anthropic 错误格式
+	if resp.StatusCode >= 400 {
+		respBody = normalizeErrorResponse(c, resp, respBody)
+	}
+	
+	// 复制响应体（已完整读取，用于判断是否升级和解析 usage）
+	if _, err := c.Writer.Write(respBody); err != nil {
 		return fmt.Errorf("复制响应体失败: %v", err)
 	}
 	
+	// 统计 token 用量和费用
+	if u, ok := usage.ParseResponse(respBody); ok {
+		h.recordUsage(cfg, rec, result.service, u)
+	}
+	
 	// 记录请求日志
-	if config.Cfg.Features.RequestLogging {
-		logger.LogRequest(userID, sessionID, c.Request.Method, c.Request.URL.Path, string(requestBody), resp.StatusCode, time.Since(startTime))
+	if cfg.Features.RequestLogging {
+		logger.LogRequest(rec.UserID, rec.SessionID, c.Request.Method, c.Request.URL.Path, string(requestBody), resp.StatusCode, time.Since(rec.Timestamp))
 	}
 	
 	return nil
 }
 
 // handleStreamingProxy 处理流式响应的代理
-func (h *Handler) handleStreamingProxy(c *gin.Context, service *models.Service, requestBody []byte, userID, sessionID string, startTime time.Time) error {
-	// 创建目标请求
-	req, err := h.createTargetRequest(c.Request, service, requestBody)
+func (h *Handler) handleStreamingProxy(c *gin.Context, candidates []*models.Service, requestBody []byte, rec *ledger.Record) (err error) {
+	cfg := config.FromContext(c.Request.Context())
+	
+	// 发送请求（仅在首个事件写给客户端之前切换服务或升级难度等级）
+	result, _, err := h.forwardWithEscalation(c, candidates, requestBody, true, rec)
 	if err != nil {
 		return err
 	}
+	rec.ServiceID = result.service.ID
+	rec.StatusCode = result.resp.StatusCode
+	defer result.release()
+	resp := result.resp
+	defer resp.Body.Close()
 	
-	// 发送请求
-	client := &http.Client{Timeout: time.Duration(config.Cfg.Proxy.RequestTimeout) * time.Second}
-	resp, err := client.Do(req)
-	if err != nil {
-		if config.Cfg.Features.ServiceAutoSwitch {
-			logger.LogWarn("目标服务不可用，尝试切换", "service_id", service.ID, "error", err)
-			// TODO: 实现服务切换逻辑
-		}
-		return fmt.Errorf("请求目标服务失败: %v", err)
+	// 上游返回错误状态（没有开始流式输出）时按普通响应返回错误，与 Anthropic 接口一致
+	if resp.StatusCode != http.StatusOK {
+		return h.writeUpstreamError(c, resp)
 	}
-	defer resp.Body.Close()
+	
+	metrics.InFlightStreams.Inc()
+	defer metrics.InFlightStreams.Dec()
+	
+	// 从首个事件写出到流结束
+	_, streamSpan := tracing.Start(c.Request.Context(), "proxy.stream", append(
+		tracing.UserAttributes(rec.UserID, rec.SessionID),
+		attribute.String("cce.service_id", result.service.ID),
+	)...)
+	defer func() {
+		tracing.End(streamSpan, err)
+	}()
 	
 	// 设置响应头
 	c.Header("Content-Type", "text/event-stream")
@@ -405,10 +740,28 @@ func (h *Handler) handleStreamingProxy(c *gin.Context, service *models.Service,
 		return fmt.Errorf("响应写入器不支持Flush")
 	}
 	
+	// 先写出切换阶段预读的首个事件
+	body := io.Reader(resp.Body)
+	if result.reader != nil {
+		body = result.reader
+	}
+	var tracker usage.StreamTracker
+	// 是否收到了 message_stop 或 error 事件（上游正常结束或自行报告了错误）
+	finished := isSSEErrorEvent(result.firstEvent)
+	if len(result.firstEvent) > 0 {
+		tracker.ObserveEvent(result.firstEvent)
+		w.Write(result.firstEvent)
+		flusher.Flush()
+	}
+	
 	// 实时转发流式数据
-	scanner := bufio.NewScanner(resp.Body)
+	scanner := bufio.NewScanner(body)
 	for scanner.Scan() {
 		line := scanner.Text()
+		tracker.ObserveLine(line)
+		if strings.HasPrefix(line, "event: message_stop") || strings.HasPrefix(line, "event: error") {
+			finished = true
+		}
 		
 		// 写入数据
 		fmt.Fprintf(w, "%s\n", line)
@@ -419,40 +772,102 @@ func (h *Handler) handleStreamingProxy(c *gin.Context, service *models.Service,
 		}
 	}
 	
+	// 中途失败时由 ProxyMiddleware 向客户端补发 SSE error 事件
 	if err := scanner.Err(); err != nil {
-		return fmt.Errorf("读取流式响应失败: %v", err)
+		return &statusError{status: http.StatusBadGateway, message: fmt.Sprintf("读取流式响应失败: %v", err)}
+	}
+	if !finished {
+		return &statusError{status: http.StatusBadGateway, message: "上游流式响应意外结束"}
 	}
 	
 	// 最后刷新
 	flusher.Flush()
 	
+	// 统计 token 用量和费用
+	if u, ok := tracker.Usage(); ok {
+		h.recordUsage(cfg, rec, result.service, u)
+		streamSpan.SetAttributes(
+			attribute.Int64("cce.input_tokens", u.InputTokens),
+			attribute.Int64("cce.output_tokens", u.OutputTokens),
+		)
+	}
+	
 	// 记录请求日志
-	if config.Cfg.Features.RequestLogging {
-		logger.LogRequest(userID, sessionID, c.Request.Method, c.Request.URL.Path, string(requestBody), resp.StatusCode, time.Since(startTime))
+	if cfg.Features.RequestLogging {
+		logger.LogRequest(rec.UserID, rec.SessionID, c.Request.Method, c.Request.URL.Path, string(requestBody), resp.StatusCode, time.Since(rec.Timestamp))
 	}
 	
 	return nil
 }
 
-// sanitizeRequestForExecutor 清理请求体，移除executor可能不支持的字段
-// 主要移除thinking相关字段，因为第三方Claude兼容API可能不支持
-func (h *Handler) sanitizeRequestForExecutor(body []byte) ([]byte, error) {
-	// 解析为map以便修改
-	var reqMap map[string]interface{}
-	if err := json.Unmarshal(body, &reqMap); err != nil {
-		return nil, fmt.Errorf("解析请求体失败: %v", err)
+// writeUpstreamError 返回上游的错误响应（流式请求在开始输出之前失败）
+func (h *Handler) writeUpstreamError(c *gin.Context, resp *http.Response) error {
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &statusError{status: http.StatusBadGateway, message: fmt.Sprintf("读取响应体失败: %v", err)}
+	}
+	
+	for key, values := range resp.Header {
+		for _, value := range values {
+			c.Header(key, value)
+		}
+	}
+	body = normalizeErrorResponse(c, resp, body)
+	c.Status(resp.StatusCode)
+	if _, err := c.Writer.Write(body); err != nil {
+		return fmt.Errorf("复制响应体失败: %v", err)
+	}
+	return nil
+}
+
+// recordUsage 计算费用并记录 token 用量
+func (h *Handler) recordUsage(cfg *models.Config, rec *ledger.Record, service *models.Service, u usage.Usage) {
+	cost := u.Cost(service.Pricing)
+	baselineCost := u.Cost(cfg.Cost.Baseline)
+
+	rec.Usage = u
+	rec.Cost = cost
+	h.usageStats.Record(service.ID, rec.DifficultyLevel, u, cost, baselineCost)
+	if budgets := h.budgets.Load(); budgets != nil {
+		budgets.Record(rec.UserID, rec.SessionID, u.Total(), cost)
 	}
 
-	// 移除thinking字段（Claude Opus的特性，第三方API可能不支持）
-	delete(reqMap, "thinking")
+	if cfg.Features.RequestLogging {
+		logger.LogUsage(rec.UserID, rec.SessionID, service.ID, rec.DifficultyLevel,
+			u.InputTokens, u.OutputTokens, u.CacheReadInputTokens, u.CacheCreationInputTokens, cost)
+	}
+}
 
-	// 重新序列化
-	sanitizedBody, err := json.Marshal(reqMap)
+// saveRecord 补全请求记录并写入本地记录库（未启用时忽略）
+func (h *Handler) saveRecord(rec *ledger.Record, err error) {
+	rec.LatencyMs = time.Since(rec.Timestamp).Milliseconds()
 	if err != nil {
-		return nil, fmt.Errorf("序列化清理后的请求体失败: %v", err)
+		rec.Error = err.Error()
+		var statusErr *statusError
+		if errors.As(err, &statusErr) {
+			rec.StatusCode = statusErr.status
+		} else if rec.StatusCode == 0 {
+			rec.StatusCode = http.StatusInternalServerError
+		}
+	}
+
+	metrics.ObserveRequest(rec.DifficultyLevel, rec.ServiceID, rec.StatusCode)
+
+	if h.ledger == nil {
+		return
+	}
+	if err := h.ledger.Append(rec); err != nil {
+		logger.LogError("写入请求记录失败", err, "user_id", rec.UserID, "session_id", rec.SessionID)
 	}
+}
 
-	return sanitizedBody, nil
+// truncateText 按字符截断文本
+func truncateText(text string, maxRunes int) string {
+	runes := []rune(text)
+	if len(runes) <= maxRunes {
+		return text
+	}
+	return string(runes[:maxRunes]) + "..."
 }
 
 // createTargetRequest 创建目标服务的请求
@@ -466,19 +881,24 @@ func (h *Handler) createTargetRequest(originalReq *http.Request, service *models
 	// 保持原始请求的查询参数
 	targetURL.RawQuery = originalReq.URL.RawQuery
 
-	// 根据服务配置决定是否清理请求体
-	// 如果服务不支持thinking模式，则移除相关字段
+	// 按服务配置转换请求体：不支持thinking时移除相关字段、按 model_map 改写模型名、执行 transforms
 	sanitizedBody := body
-	if !service.SupportsThinking {
-		cleaned, err := h.sanitizeRequestForExecutor(body)
+	if steps := transform.ForService(service); len(steps) > 0 {
+		transformed, err := transform.Apply(body, steps)
 		if err != nil {
-			logger.LogWarn("清理请求体失败，使用原始请求体", "error", err, "service", service.Name)
+			logger.LogWarn("转换请求体失败，使用原始请求体", "error", err, "service", service.Name)
 		} else {
-			sanitizedBody = cleaned
-			logger.LogDebug("已清理请求体，移除了thinking字段", "service", service.Name)
+			sanitizedBody = transformed
+			logger.LogDebug("已转换请求体", "service", service.Name, "steps", len(steps))
 		}
 	}
 
+	// 按服务协议转换请求体（如 openai 协议转为 Chat Completions）
+	sanitizedBody, err = translateRequestBody(service, sanitizedBody)
+	if err != nil {
+		return nil, fmt.Errorf("转换请求协议失败: %v", err)
+	}
+
 	// 创建新请求
 	req, err := http.NewRequest(originalReq.Method, targetURL.String(), bytes.NewReader(sanitizedBody))
 	if err != nil {
@@ -486,9 +906,14 @@ func (h *Handler) createTargetRequest(originalReq *http.Request, service *models
 	}
 
 	// 复制原始请求头
+	cfg := config.FromContext(originalReq.Context())
 	for key, values := range originalReq.Header {
-		// 跳过Host和Authorization头
-		if key == "Host" || key == "Authorization" {
+		// 跳过Host头和手动路由覆盖头
+		if key == "Host" || strings.HasPrefix(key, overrideHeaderPrefix) || skipForwardHeader(service, key) {
+			continue
+		}
+		// 客户端凭据只透传给 passthrough 的服务，其余服务只会收到自己配置的 api_key
+		if isCredentialHeader(cfg, key) && service.AuthScheme != models.AuthSchemePassthrough {
 			continue
 		}
 		for _, value := range values {
@@ -499,8 +924,8 @@ func (h *Handler) createTargetRequest(originalReq *http.Request, service *models
 	// 设置正确的Host头
 	req.Host = targetURL.Host
 
-	// 设置目标服务的认证头
-	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", service.APIKey))
+	// 按 auth_scheme 设置目标服务的认证头
+	setCredential(req.Header, service)
 
 	return req, nil
 }
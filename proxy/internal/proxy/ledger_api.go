@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/ledger"
+	"github.com/ethan/claude-proxy/internal/logger"
+)
+
+// openLedger 按配置打开本地请求记录库
+func (s *Server) openLedger() error {
+	cfg := config.Get().Ledger
+	if !cfg.Enabled {
+		return nil
+	}
+
+	store, err := ledger.Open(cfg.Path)
+	if err != nil {
+		return err
+	}
+	store.StartRetention(cfg.RetentionDays)
+	s.handler.ledger = store
+
+	logger.LogInfo("请求记录已启用",
+		"path", cfg.Path,
+		"retention_days", cfg.RetentionDays,
+	)
+	return nil
+}
+
+// closeLedger 关闭本地请求记录库
+func (s *Server) closeLedger() {
+	if s.handler.ledger == nil {
+		return
+	}
+	if err := s.handler.ledger.Close(); err != nil {
+		logger.LogError("关闭请求记录失败", err)
+	}
+}
+
+// requireLedger 检查请求记录是否启用，未启用时直接返回 503
+func (s *Server) requireLedger(c *gin.Context) bool {
+	if s.handler.ledger != nil {
+		return true
+	}
+	c.JSON(http.StatusServiceUnavailable, gin.H{
+		"error": "请求记录未启用（ledger.enabled=false）",
+	})
+	return false
+}
+
+// listLedgerRequests 按时间、用户、会话、服务查询请求记录（新记录在前）
+func (s *Server) listLedgerRequests(c *gin.Context) {
+	if !s.requireLedger(c) {
+		return
+	}
+
+	filter, err := ledger.ParseFilter(c.Query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	records, err := s.handler.ledger.Query(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"count":    len(records),
+		"requests": records,
+	})
+}
+
+// getLedgerRequest 获取单条请求记录
+func (s *Server) getLedgerRequest(c *gin.Context) {
+	if !s.requireLedger(c) {
+		return
+	}
+
+	rec, err := s.handler.ledger.Get(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, rec)
+}
+
+// summarizeLedger 汇总请求数、错误数、token 用量和费用
+func (s *Server) summarizeLedger(c *gin.Context) {
+	if !s.requireLedger(c) {
+		return
+	}
+
+	filter, err := ledger.ParseFilter(c.Query)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	summary, err := s.handler.ledger.Summarize(filter)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"currency": config.Get().Cost.Currency,
+		"summary":  summary,
+	})
+}
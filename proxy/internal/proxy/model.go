@@ -0,0 +1,33 @@
+package proxy
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// modelPatterns 已编译的模型路由正则（配置校验时已确认可编译）
+var modelPatterns sync.Map
+
+// matchModelRule 按请求的模型名查找第一条匹配的路由规则，未匹配时返回 nil
+func matchModelRule(cfg *models.Config, model string) *models.ModelRoutingRule {
+	if model == "" {
+		return nil
+	}
+	for i := range cfg.ModelRouting.Rules {
+		rule := &cfg.ModelRouting.Rules[i]
+		re, ok := modelPatterns.Load(rule.Pattern)
+		if !ok {
+			compiled, err := regexp.Compile("(?i)" + rule.Pattern)
+			if err != nil {
+				continue
+			}
+			re, _ = modelPatterns.LoadOrStore(rule.Pattern, compiled)
+		}
+		if re.(*regexp.Regexp).MatchString(model) {
+			return rule
+		}
+	}
+	return nil
+}
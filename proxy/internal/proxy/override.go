@@ -0,0 +1,204 @@
+package proxy
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// 手动路由覆盖的请求头，转发时不会带给上游（见 createTargetRequest）
+const (
+	overrideLevelHeader   = "X-CCE-Level"
+	overrideServiceHeader = "X-CCE-Service"
+	overrideHeaderPrefix  = "X-Cce-" // http.CanonicalHeaderKey 之后的前缀
+)
+
+// 提示中的覆盖标记：#cce:5 / #cce:service-id，或单独一行的 /model-hint 5
+var overrideMarkers = []*regexp.Regexp{
+	regexp.MustCompile(`#cce:([A-Za-z0-9._-]+)`),
+	regexp.MustCompile(`(?m)^[ \t]*/model-hint[ \t]+([A-Za-z0-9._-]+)[ \t]*$`),
+}
+
+// routingOverride 手动指定的路由
+type routingOverride struct {
+	Level     int
+	ServiceID string
+	Source    string // 来源，用于日志和请求记录
+}
+
+// resolveOverride 解析请求头和提示标记中的手动路由覆盖
+// 标记会从请求体（及解析后的 claudeReq）中移除，返回移除后的请求体；
+// 用户无权限或取值无效时忽略覆盖，仍按正常流程评估
+func (h *Handler) resolveOverride(c *gin.Context, cfg *models.Config, claudeReq *models.ClaudeRequest, requestBody []byte, userID string) (*routingOverride, []byte, error) {
+	if !cfg.Overrides.Enabled {
+		return nil, requestBody, nil
+	}
+
+	value, source := c.GetHeader(overrideServiceHeader), "header "+overrideServiceHeader
+	if value == "" {
+		value, source = c.GetHeader(overrideLevelHeader), "header "+overrideLevelHeader
+	}
+
+	// 标记取自当前任务的用户输入（与 extractUserIntent 一致），历史消息中的标记同样移除
+	if marker := findMarker(claudeReq.Messages); marker != "" {
+		if value == "" {
+			value, source = marker, "marker "+marker
+		}
+		body, err := stripMarkers(requestBody)
+		if err != nil {
+			return nil, nil, err
+		}
+		requestBody = body
+		stripMessageMarkers(claudeReq.Messages)
+	}
+
+	if value == "" {
+		return nil, requestBody, nil
+	}
+	if !cfg.Overrides.Allows(userID) {
+		logger.LogWarn("用户无权使用手动路由覆盖，已忽略", "user_id", userID, "source", source)
+		return nil, requestBody, nil
+	}
+
+	override := &routingOverride{Source: source}
+	if level, err := strconv.Atoi(value); err == nil {
+		if level < 1 || level > 5 {
+			logger.LogWarn("手动指定的难度等级无效，已忽略", "user_id", userID, "level", value)
+			return nil, requestBody, nil
+		}
+		override.Level = level
+		return override, requestBody, nil
+	}
+
+	if !cfg.Overrides.AllowService {
+		logger.LogWarn("未允许手动指定服务，已忽略", "user_id", userID, "service", value)
+		return nil, requestBody, nil
+	}
+	if _, err := config.GetServiceByID(cfg, value); err != nil {
+		logger.LogWarn("手动指定的服务不存在，已忽略", "user_id", userID, "service", value)
+		return nil, requestBody, nil
+	}
+	override.ServiceID = value
+	override.Level = serviceLevel(cfg, value)
+	return override, requestBody, nil
+}
+
+// overrideCandidates 手动指定服务时的候选链：只使用该服务
+func (h *Handler) overrideCandidates(cfg *models.Config, override *routingOverride) ([]*models.Service, error) {
+	svc, err := config.GetServiceByID(cfg, override.ServiceID)
+	if err != nil {
+		return nil, err
+	}
+	candidates := h.availableServices([]*models.Service{svc})
+	if len(candidates) == 0 {
+		return nil, &statusError{status: http.StatusServiceUnavailable, message: fmt.Sprintf("手动指定的服务 %s 不可用（熔断或探测失败）", override.ServiceID)}
+	}
+	return candidates, nil
+}
+
+// serviceLevel 服务在难度映射中出现的最低等级，未出现时返回0
+func serviceLevel(cfg *models.Config, serviceID string) int {
+	for level := 1; level <= 5; level++ {
+		for _, target := range cfg.DifficultyMapping[strconv.Itoa(level)] {
+			if target.ID == serviceID {
+				return level
+			}
+		}
+	}
+	return 0
+}
+
+// findMarker 在最近一条包含真实输入的用户消息中查找覆盖标记
+func findMarker(messages []models.Message) string {
+	for i := len(messages) - 1; i >= 0; i-- {
+		if messages[i].Role != "user" {
+			continue
+		}
+		hasText := false
+		for _, content := range messages[i].Content {
+			if content.Type != "text" {
+				continue
+			}
+			for _, re := range overrideMarkers {
+				if m := re.FindStringSubmatch(content.Text); m != nil {
+					return m[1]
+				}
+			}
+			if strings.TrimSpace(content.Text) != "" && !strings.Contains(content.Text, "<system-reminder>") {
+				hasText = true
+			}
+		}
+		if hasText {
+			return ""
+		}
+	}
+	return ""
+}
+
+// removeMarkers 移除文本中的覆盖标记
+func removeMarkers(text string) string {
+	stripped := text
+	for _, re := range overrideMarkers {
+		stripped = re.ReplaceAllString(stripped, "")
+	}
+	if stripped == text {
+		return text
+	}
+	return strings.TrimSpace(stripped)
+}
+
+// stripMessageMarkers 移除已解析消息中的覆盖标记
+func stripMessageMarkers(messages []models.Message) {
+	for i := range messages {
+		if messages[i].Role != "user" {
+			continue
+		}
+		for j := range messages[i].Content {
+			messages[i].Content[j].Text = removeMarkers(messages[i].Content[j].Text)
+		}
+	}
+}
+
+// stripMarkers 移除请求体中所有用户消息里的覆盖标记（content 可为字符串或内容块数组）
+func stripMarkers(body []byte) ([]byte, error) {
+	var reqMap map[string]interface{}
+	if err := json.Unmarshal(body, &reqMap); err != nil {
+		return nil, fmt.Errorf("解析请求体失败: %v", err)
+	}
+
+	messages, _ := reqMap["messages"].([]interface{})
+	for _, item := range messages {
+		message, ok := item.(map[string]interface{})
+		if !ok || message["role"] != "user" {
+			continue
+		}
+		switch content := message["content"].(type) {
+		case string:
+			message["content"] = removeMarkers(content)
+		case []interface{}:
+			for _, blockItem := range content {
+				block, ok := blockItem.(map[string]interface{})
+				if !ok || block["type"] != "text" {
+					continue
+				}
+				if text, ok := block["text"].(string); ok {
+					block["text"] = removeMarkers(text)
+				}
+			}
+		}
+	}
+
+	stripped, err := json.Marshal(reqMap)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求体失败: %v", err)
+	}
+	return stripped, nil
+}
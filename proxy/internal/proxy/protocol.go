@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/ethan/claude-proxy/internal/models"
+	"github.com/ethan/claude-proxy/internal/openai"
+)
+
+// translateRequestBody 按服务协议转换请求体（请求体已完成 transforms）
+func translateRequestBody(service *models.Service, body []byte) ([]byte, error) {
+	if service.Protocol != models.ProtocolOpenAI {
+		return body, nil
+	}
+	return openai.TranslateRequest(body)
+}
+
+// translateResponse 按服务协议将上游响应原地转换为 Anthropic 格式，之后的切换、升级和用量统计都按 Anthropic 格式处理
+// 转换失败时响应体已关闭
+func translateResponse(service *models.Service, resp *http.Response) error {
+	if service.Protocol != models.ProtocolOpenAI {
+		return nil
+	}
+	return openai.TranslateResponse(resp)
+}
+
+// skipForwardHeader 判断客户端请求头是否不转发给该服务
+// 所有服务都不转发 Accept-Encoding，由 http.Client 协商并解压，保证响应可以解析（usage、升级判断、协议转换）；
+// openai 协议的服务也不转发 Anthropic 专用头
+func skipForwardHeader(service *models.Service, key string) bool {
+	if key == "Accept-Encoding" {
+		return true
+	}
+	return service.Protocol == models.ProtocolOpenAI && strings.HasPrefix(key, "Anthropic-")
+}
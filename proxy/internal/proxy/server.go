@@ -3,28 +3,36 @@ package proxy
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
 	"syscall"
 	"time"
 	
 	"github.com/gin-gonic/gin"
 	"github.com/ethan/claude-proxy/internal/config"
 	"github.com/ethan/claude-proxy/internal/logger"
+	"github.com/ethan/claude-proxy/internal/metrics"
+	"github.com/ethan/claude-proxy/internal/models"
 )
 
 // Server 代理服务器
 type Server struct {
-	router  *gin.Engine
-	handler *Handler
-	srv     *http.Server
+	router    *gin.Engine
+	handler   *Handler
+	srv       *http.Server
+	stopWatch chan struct{} // 关闭时停止配置文件监听
+	stopOnce  sync.Once
 }
 
 // NewServer 创建代理服务器
 func NewServer() *Server {
 	// 设置Gin模式
-	if config.Cfg.Logging.Level == "debug" {
+	if config.Get().Logging.Level == "debug" {
 		gin.SetMode(gin.DebugMode)
 	} else {
 		gin.SetMode(gin.ReleaseMode)
@@ -34,8 +42,9 @@ func NewServer() *Server {
 	handler := NewHandler()
 	
 	return &Server{
-		router:  router,
-		handler: handler,
+		router:    router,
+		handler:   handler,
+		stopWatch: make(chan struct{}),
 	}
 }
 
@@ -44,12 +53,18 @@ func (s *Server) setupRoutes() {
 	// 添加日志中间件
 	s.router.Use(s.loggerMiddleware())
 	
-	// 添加恢复中间件
-	s.router.Use(gin.Recovery())
+	// 添加恢复中间件（panic 时同样返回 Anthropic 格式的错误）
+	s.router.Use(gin.CustomRecovery(func(c *gin.Context, recovered interface{}) {
+		respondError(c, http.StatusInternalServerError, "代理内部错误")
+		c.Abort()
+	}))
 	
 	// 添加CORS中间件
 	s.router.Use(s.corsMiddleware())
 	
+	// 添加访问令牌中间件（必须在代理中间件之前）
+	s.router.Use(s.accessMiddleware())
+	
 	// 添加代理中间件
 	s.router.Use(s.handler.ProxyMiddleware())
 	
@@ -58,12 +73,27 @@ func (s *Server) setupRoutes() {
 	
 	// 状态端点
 	s.router.GET("/status", s.statusCheck)
+	
+	// 服务健康探测结果
+	s.router.GET("/services/health", s.servicesHealth)
+	
+	// Prometheus 指标端点
+	s.router.GET("/metrics", gin.WrapH(metrics.Handler()))
+	
+	// 管理接口
+	s.setupAdminRoutes()
+	
+	// 请求记录查询端点（包含所有用户的请求内容摘要，使用管理令牌）
+	ledgerGroup := s.router.Group("/ledger", s.adminAuthMiddleware())
+	ledgerGroup.GET("/requests", s.listLedgerRequests)
+	ledgerGroup.GET("/requests/:id", s.getLedgerRequest)
+	ledgerGroup.GET("/summary", s.summarizeLedger)
 }
 
 // loggerMiddleware 自定义日志中间件
 func (s *Server) loggerMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		if !config.Cfg.Features.RequestLogging {
+		if !config.Get().Features.RequestLogging {
 			c.Next()
 			return
 		}
@@ -90,12 +120,20 @@ func (s *Server) loggerMiddleware() gin.HandlerFunc {
 }
 
 // corsMiddleware CORS中间件
+// 只对 proxy.cors_allowed_origins 中的来源返回 CORS 响应头（原样返回该来源，不使用 *），默认不允许跨域访问；
+// 每次请求读取当前配置，修改后热加载即可生效
 func (s *Server) corsMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, accept, origin, Cache-Control, X-Requested-With")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, DELETE")
+		origin := c.GetHeader("Origin")
+		if origin == "" || !originAllowed(config.Get().Proxy.CORSAllowedOrigins, origin) {
+			c.Next()
+			return
+		}
+
+		c.Writer.Header().Set("Access-Control-Allow-Origin", origin)
+		c.Writer.Header().Add("Vary", "Origin")
+		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Authorization, X-Api-Key, X-CCE-Token, anthropic-version, anthropic-beta, accept, origin, Cache-Control, X-Requested-With")
+		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS, GET, PUT, PATCH, DELETE")
 		
 		if c.Request.Method == "OPTIONS" {
 			c.AbortWithStatus(204)
@@ -106,6 +144,16 @@ func (s *Server) corsMiddleware() gin.HandlerFunc {
 	}
 }
 
+// originAllowed 判断跨域来源是否在允许列表中
+func originAllowed(allowed []string, origin string) bool {
+	for _, item := range allowed {
+		if strings.EqualFold(strings.TrimSuffix(item, "/"), origin) {
+			return true
+		}
+	}
+	return false
+}
+
 // healthCheck 健康检查
 func (s *Server) healthCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
@@ -114,11 +162,22 @@ func (s *Server) healthCheck(c *gin.Context) {
 	})
 }
 
+// servicesHealth 各服务的主动探测结果
+func (s *Server) servicesHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"enabled":  config.Get().HealthCheck.Enabled,
+		"services": s.handler.prober.Snapshot(),
+		"time":     time.Now().Format(time.RFC3339),
+	})
+}
+
 // statusCheck 状态检查
 func (s *Server) statusCheck(c *gin.Context) {
+	cfg := config.Get()
+	
 	// 收集服务状态
-	services := make([]gin.H, 0, len(config.Cfg.Services))
-	for _, svc := range config.Cfg.Services {
+	services := make([]gin.H, 0, len(cfg.Services))
+	for _, svc := range cfg.Services {
 		services = append(services, gin.H{
 			"id":   svc.ID,
 			"name": svc.Name,
@@ -130,42 +189,88 @@ func (s *Server) statusCheck(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"status": "running",
 		"config": gin.H{
-			"proxy_port":          config.Cfg.Proxy.Port,
-			"evaluator_fallback":  config.Cfg.Features.EvaluatorFallback,
-			"service_auto_switch": config.Cfg.Features.ServiceAutoSwitch,
-			"request_logging":     config.Cfg.Features.RequestLogging,
+			"proxy_port":          cfg.Proxy.Port,
+			"listen_address":      cfg.Proxy.ListenAddress,
+			"access_enabled":      cfg.Access.Enabled,
+			"evaluator_fallback":  cfg.Features.EvaluatorFallback,
+			"service_auto_switch": cfg.Features.ServiceAutoSwitch,
+			"request_logging":     cfg.Features.RequestLogging,
 		},
 		"services":           services,
-		"difficulty_mapping": config.Cfg.DifficultyMapping,
+		"difficulty_mapping": cfg.DifficultyMapping,
+		"failover":           s.handler.switchStats.Snapshot(),
+		"load_balancing": gin.H{
+			"strategy":  cfg.LoadBalancing.Strategy,
+			"levels":    cfg.LoadBalancing.Levels,
+			"in_flight": s.handler.balancer.InFlight(),
+		},
+		"circuit_breakers": s.handler.breakers.Snapshot(),
+		"evaluator_cache":  s.handler.evaluatorClient.CacheStats(),
+		"sticky_routing":   s.handler.sticky.Snapshot(),
+		"usage": gin.H{
+			"currency": cfg.Cost.Currency,
+			"stats":    s.handler.usageStats.Snapshot(),
+		},
+		"budgets":            s.budgetsSnapshot(),
 		"time":              time.Now().Format(time.RFC3339),
 	})
 }
 
 // Start 启动服务器
 func (s *Server) Start() error {
+	cfg := config.Get()
+	
 	// 设置路由
 	s.setupRoutes()
 	
 	// 创建HTTP服务器，使用配置的超时值
 	s.srv = &http.Server{
-		Addr:         fmt.Sprintf(":%d", config.Cfg.Proxy.Port),
+		Addr:         net.JoinHostPort(cfg.Proxy.ListenAddress, strconv.Itoa(cfg.Proxy.Port)),
 		Handler:      s.router,
-		ReadTimeout:  time.Duration(config.Cfg.Proxy.ReadTimeout) * time.Second,
-		WriteTimeout: time.Duration(config.Cfg.Proxy.WriteTimeout) * time.Second,
-		IdleTimeout:  time.Duration(config.Cfg.Proxy.IdleTimeout) * time.Second,
+		ReadTimeout:  time.Duration(cfg.Proxy.ReadTimeout) * time.Second,
+		WriteTimeout: time.Duration(cfg.Proxy.WriteTimeout) * time.Second,
+		IdleTimeout:  time.Duration(cfg.Proxy.IdleTimeout) * time.Second,
 	}
 
 	// 记录超时配置
 	logger.LogInfo("服务器超时配置",
-		"read_timeout", config.Cfg.Proxy.ReadTimeout,
-		"write_timeout", config.Cfg.Proxy.WriteTimeout,
-		"idle_timeout", config.Cfg.Proxy.IdleTimeout,
-		"request_timeout", config.Cfg.Proxy.RequestTimeout,
+		"read_timeout", cfg.Proxy.ReadTimeout,
+		"write_timeout", cfg.Proxy.WriteTimeout,
+		"idle_timeout", cfg.Proxy.IdleTimeout,
+		"request_timeout", cfg.Proxy.RequestTimeout,
 	)
 	
+	// 打开本地请求记录
+	if err := s.openLedger(); err != nil {
+		return fmt.Errorf("打开请求记录失败: %v", err)
+	}
+	
+	// 打开预算计数
+	if err := s.openBudgets(); err != nil {
+		return fmt.Errorf("打开预算计数失败: %v", err)
+	}
+	
+	// 启动后台健康探测
+	s.handler.prober.Start()
+	
+	// 配置热加载：文件变化时自动重新加载（SIGHUP 见 waitForShutdown）
+	config.OnReload(s.onConfigReload)
+	if cfg.Features.ConfigHotReload {
+		if err := config.Watch(s.stopWatch); err != nil {
+			logger.LogError("启动配置文件监听失败", err)
+		}
+	}
+	
+	// 监听非本机地址但未启用访问令牌时，局域网内任何人都可以使用代理
+	if !isLoopbackAddress(cfg.Proxy.ListenAddress) && !cfg.Access.Enabled {
+		logger.LogWarn("代理监听在非本机地址且未启用访问令牌（access.enabled），任何能访问该地址的人都可以使用代理",
+			"listen_address", cfg.Proxy.ListenAddress,
+		)
+	}
+	
 	// 启动服务器
 	go func() {
-		logger.LogInfo("代理服务器启动", "port", config.Cfg.Proxy.Port)
+		logger.LogInfo("代理服务器启动", "address", s.srv.Addr)
 		if err := s.srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logger.LogError("服务器启动失败", err)
 			os.Exit(1)
@@ -181,10 +286,15 @@ func (s *Server) Start() error {
 // waitForShutdown 等待关闭信号
 func (s *Server) waitForShutdown() {
 	quit := make(chan os.Signal, 1)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
-	<-quit
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := <-quit; sig == syscall.SIGHUP; sig = <-quit {
+		// SIGHUP 重新加载配置，失败时保留旧配置
+		logger.LogInfo("收到 SIGHUP，重新加载配置")
+		_ = config.Reload()
+	}
 	
 	logger.LogInfo("正在关闭服务器...")
+	s.stopBackground()
 	
 	// 设置关闭超时
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
@@ -195,6 +305,8 @@ func (s *Server) waitForShutdown() {
 		logger.LogError("服务器关闭失败", err)
 		os.Exit(1)
 	}
+	s.closeLedger()
+	s.closeBudgets()
 	
 	logger.LogInfo("服务器已关闭")
 }
@@ -205,8 +317,38 @@ func (s *Server) Stop() error {
 		return nil
 	}
 	
+	s.stopBackground()
+	
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 	
-	return s.srv.Shutdown(ctx)
+	err := s.srv.Shutdown(ctx)
+	s.closeLedger()
+	s.closeBudgets()
+	return err
+}
+
+// stopBackground 停止后台探测和配置文件监听
+func (s *Server) stopBackground() {
+	s.handler.prober.Stop()
+	s.stopOnce.Do(func() {
+		close(s.stopWatch)
+	})
+}
+
+// isLoopbackAddress 判断监听地址是否只允许本机访问
+func isLoopbackAddress(address string) bool {
+	if address == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(address)
+	return ip != nil && ip.IsLoopback()
+}
+
+// onConfigReload 配置热加载后按新配置重启后台探测、重新打开预算计数
+func (s *Server) onConfigReload(oldCfg, newCfg *models.Config) {
+	if oldCfg.HealthCheck != newCfg.HealthCheck {
+		s.handler.prober.Restart()
+	}
+	s.reloadBudgets(oldCfg, newCfg)
 }
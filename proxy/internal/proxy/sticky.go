@@ -0,0 +1,142 @@
+package proxy
+
+import (
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/ethan/claude-proxy/internal/config"
+	"github.com/ethan/claude-proxy/internal/evaluator"
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// stickyPin 会话当前固定的服务
+type stickyPin struct {
+	Level     int
+	ServiceID string
+	Turns     int // 固定后已沿用的轮数
+	UpdatedAt time.Time
+}
+
+// StickySessions 会话粘滞路由状态
+type StickySessions struct {
+	mu          sync.Mutex
+	pins        map[string]*stickyPin
+	reuses      int64
+	escalations int64
+}
+
+// NewStickySessions 创建会话粘滞路由状态
+func NewStickySessions() *StickySessions {
+	return &StickySessions{
+		pins: make(map[string]*stickyPin),
+	}
+}
+
+// Lookup 获取可沿用的固定服务
+// 出现新的用户输入、超过最大轮数或空闲超时时返回 nil，需要重新评估；无法识别会话（用户ID或会话ID为空）时不沿用
+func (s *StickySessions) Lookup(userID, sessionID string, request *models.ClaudeRequest, cfg models.StickyRoutingConfig) *stickyPin {
+	if userID == "" || sessionID == "" || evaluator.IsNewUserPrompt(request.Messages) {
+		return nil
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pin, ok := s.pins[userID+"_"+sessionID]
+	if !ok {
+		return nil
+	}
+	if time.Since(pin.UpdatedAt) > time.Duration(cfg.IdleSeconds)*time.Second {
+		delete(s.pins, userID+"_"+sessionID)
+		return nil
+	}
+	if cfg.MaxTurns > 0 && pin.Turns >= cfg.MaxTurns {
+		return nil
+	}
+
+	s.reuses++
+	copied := *pin
+	return &copied
+}
+
+// Pin 请求成功后固定会话使用的服务；reused 为 true 表示沿用了已有的固定（轮数累加）
+// 用户ID或会话ID为空时不固定，避免不相关的客户端共用同一个固定
+func (s *StickySessions) Pin(userID, sessionID string, level int, serviceID string, reused bool, cfg models.StickyRoutingConfig) {
+	if userID == "" || sessionID == "" {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := userID + "_" + sessionID
+	pin, ok := s.pins[key]
+	if !ok || !reused {
+		s.prune(time.Duration(cfg.IdleSeconds) * time.Second)
+		pin = &stickyPin{}
+		s.pins[key] = pin
+	}
+	if reused && pin.ServiceID != "" && pin.ServiceID != serviceID {
+		s.escalations++
+	}
+	if reused {
+		pin.Turns++
+	} else {
+		pin.Turns = 0
+	}
+	pin.Level = level
+	pin.ServiceID = serviceID
+	pin.UpdatedAt = time.Now()
+}
+
+// prune 清理空闲超时的会话（调用方需持有锁）
+func (s *StickySessions) prune(idle time.Duration) {
+	for key, pin := range s.pins {
+		if time.Since(pin.UpdatedAt) > idle {
+			delete(s.pins, key)
+		}
+	}
+}
+
+// Snapshot 获取统计快照（用于 /status）
+func (s *StickySessions) Snapshot() gin.H {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return gin.H{
+		"pinned_sessions": len(s.pins),
+		"reuses":          s.reuses,
+		"escalations":     s.escalations,
+	}
+}
+
+// stickyCandidates 沿用固定服务时的候选链：固定的服务在前，失败时升级到上一个难度等级的服务
+// levels 记录每个候选服务对应的难度等级，用于请求成功后更新固定
+func (h *Handler) stickyCandidates(cfg *models.Config, pin *stickyPin) ([]*models.Service, map[string]int, error) {
+	levels := make(map[string]int)
+	var candidates []*models.Service
+
+	if svc, err := config.GetServiceByID(cfg, pin.ServiceID); err == nil {
+		if available := h.availableServices([]*models.Service{svc}); len(available) > 0 {
+			candidates = append(candidates, svc)
+			levels[svc.ID] = pin.Level
+		}
+	}
+
+	escalated := pin.Level
+	if escalated < 5 {
+		escalated++
+	}
+	next, nextLevels, err := h.selectCandidates(cfg, escalated)
+	if err != nil && len(candidates) == 0 {
+		return nil, nil, err
+	}
+	for _, svc := range next {
+		if _, ok := levels[svc.ID]; !ok {
+			candidates = append(candidates, svc)
+			levels[svc.ID] = nextLevels[svc.ID]
+		}
+	}
+	return candidates, levels, nil
+}
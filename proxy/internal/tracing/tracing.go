@@ -0,0 +1,151 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+const tracerName = "github.com/ethan/claude-proxy"
+
+// Init 按配置初始化全局 TracerProvider，返回的函数用于退出时刷新并关闭导出器
+// 未启用时保留 OpenTelemetry 默认的 noop 实现，所有埋点开销可以忽略
+func Init(cfg *models.TracingConfig, version string) (func(context.Context) error, error) {
+	if !cfg.Enabled {
+		return func(context.Context) error { return nil }, nil
+	}
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch cfg.Exporter {
+	case "stdout":
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout), stdouttrace.WithPrettyPrint())
+	case "otlp", "":
+		opts := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.Endpoint)}
+		if cfg.Insecure {
+			opts = append(opts, otlptracehttp.WithInsecure())
+		}
+		if len(cfg.Headers) > 0 {
+			opts = append(opts, otlptracehttp.WithHeaders(cfg.Headers))
+		}
+		exporter, err = otlptracehttp.New(context.Background(), opts...)
+	default:
+		return nil, fmt.Errorf("不支持的 tracing.exporter: %s", cfg.Exporter)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("创建 trace 导出器失败: %v", err)
+	}
+
+	res := resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(cfg.ServiceName),
+		semconv.ServiceVersion(version),
+	)
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return provider.Shutdown, nil
+}
+
+// Extract 从客户端请求头中提取上游调用方的 trace 上下文（traceparent）
+func Extract(ctx context.Context, header http.Header) context.Context {
+	return otel.GetTextMapPropagator().Extract(ctx, propagation.HeaderCarrier(header))
+}
+
+// Start 创建一个子 span
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// UserAttributes 用户和会话属性，附加到每个与请求相关的 span
+func UserAttributes(userID, sessionID string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("cce.user_id", userID),
+		attribute.String("cce.session_id", sessionID),
+	}
+}
+
+// End 结束 span，err 非空时标记为错误
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// WithHTTPTrace 为上游 HTTP 请求挂载连接和首字节两个子 span：
+// upstream.connect 从获取连接到拿到连接，upstream.first_byte 从请求写完到收到首字节。
+// 请求结束后必须调用返回的 finish，结束因连接失败等原因未正常结束的 span
+func WithHTTPTrace(ctx context.Context, attrs ...attribute.KeyValue) (context.Context, func(err error)) {
+	var mu sync.Mutex
+	var connectSpan, firstByteSpan trace.Span
+
+	clientTrace := &httptrace.ClientTrace{
+		GetConn: func(hostPort string) {
+			mu.Lock()
+			defer mu.Unlock()
+			_, connectSpan = Start(ctx, "upstream.connect", append(attrs, attribute.String("net.peer", hostPort))...)
+		},
+		GotConn: func(info httptrace.GotConnInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if connectSpan != nil {
+				connectSpan.SetAttributes(attribute.Bool("net.conn_reused", info.Reused))
+				connectSpan.End()
+				connectSpan = nil
+			}
+		},
+		WroteRequest: func(info httptrace.WroteRequestInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			_, firstByteSpan = Start(ctx, "upstream.first_byte", attrs...)
+			if info.Err != nil {
+				End(firstByteSpan, info.Err)
+				firstByteSpan = nil
+			}
+		},
+		GotFirstResponseByte: func() {
+			mu.Lock()
+			defer mu.Unlock()
+			if firstByteSpan != nil {
+				firstByteSpan.End()
+				firstByteSpan = nil
+			}
+		},
+	}
+	finish := func(err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if connectSpan != nil {
+			End(connectSpan, err)
+			connectSpan = nil
+		}
+		if firstByteSpan != nil {
+			End(firstByteSpan, err)
+			firstByteSpan = nil
+		}
+	}
+	return httptrace.WithClientTrace(ctx, clientTrace), finish
+}
@@ -0,0 +1,308 @@
+package transform
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// thinking 预算的最小值，低于该值的上游会拒绝请求
+const minThinkingBudget = 1024
+
+// ForService 获取服务的完整转换步骤：
+// supports_thinking=false 时删除 thinking，配置了 model_map 时改写模型名，最后是服务自己的 transforms
+func ForService(service *models.Service) []models.Transform {
+	var steps []models.Transform
+	if !service.SupportsThinking {
+		steps = append(steps, models.Transform{Type: models.TransformDropFields, Fields: []string{"thinking"}})
+	}
+	if len(service.ModelMap) > 0 {
+		steps = append(steps, models.Transform{Type: models.TransformRenameModel, Map: service.ModelMap})
+	}
+	return append(steps, service.Transforms...)
+}
+
+// Apply 按顺序对请求体执行转换，没有转换步骤时原样返回
+func Apply(body []byte, steps []models.Transform) ([]byte, error) {
+	if len(steps) == 0 {
+		return body, nil
+	}
+
+	// 使用 json.Number 保留数字原样，避免大整数被转成浮点
+	var req map[string]interface{}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(&req); err != nil {
+		return nil, fmt.Errorf("解析请求体失败: %v", err)
+	}
+
+	for i, step := range steps {
+		if err := ApplyStep(req, step); err != nil {
+			return nil, fmt.Errorf("执行第 %d 步转换 %s 失败: %v", i+1, step.Type, err)
+		}
+	}
+
+	transformed, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("序列化请求体失败: %v", err)
+	}
+	return transformed, nil
+}
+
+// ApplyStep 对已解析的请求执行单步转换
+func ApplyStep(req map[string]interface{}, step models.Transform) error {
+	switch step.Type {
+	case models.TransformDropFields:
+		for _, field := range step.Fields {
+			deletePath(req, field)
+		}
+	case models.TransformSetDefault:
+		if _, ok := lookupPath(req, step.Field); !ok {
+			setPath(req, step.Field, step.Value)
+		}
+	case models.TransformClampMaxTokens:
+		clampMaxTokens(req, int64(step.Max))
+	case models.TransformStripCacheControl:
+		for _, key := range []string{"system", "messages", "tools"} {
+			if value, ok := req[key]; ok {
+				stripCacheControl(value)
+			}
+		}
+	case models.TransformRemoveToolTypes:
+		removeToolTypes(req, step.ToolTypes)
+	case models.TransformRenameModel:
+		model, _ := req["model"].(string)
+		if step.To != "" {
+			req["model"] = step.To
+		} else if mapped := models.MapModelName(step.Map, model); mapped != model {
+			req["model"] = mapped
+		}
+	default:
+		return fmt.Errorf("未知的转换类型: %s", step.Type)
+	}
+	return nil
+}
+
+// Validate 检查转换步骤的配置（供配置校验使用）
+func Validate(steps []models.Transform) error {
+	for i, step := range steps {
+		var err error
+		switch step.Type {
+		case models.TransformDropFields:
+			if len(step.Fields) == 0 {
+				err = fmt.Errorf("必须配置 fields")
+			}
+		case models.TransformSetDefault:
+			if step.Field == "" || step.Value == nil {
+				err = fmt.Errorf("必须配置 field 和 value")
+			}
+		case models.TransformClampMaxTokens:
+			if step.Max <= 0 {
+				err = fmt.Errorf("max 必须大于0")
+			}
+		case models.TransformStripCacheControl:
+		case models.TransformRemoveToolTypes:
+			if len(step.ToolTypes) == 0 {
+				err = fmt.Errorf("必须配置 tool_types")
+			}
+		case models.TransformRenameModel:
+			if step.To == "" && len(step.Map) == 0 {
+				err = fmt.Errorf("必须配置 to 或 map")
+			}
+		default:
+			err = fmt.Errorf("未知的转换类型: %q", step.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("transforms[%d]: %v", i, err)
+		}
+	}
+	return nil
+}
+
+// lookupPath 按 a.b 路径查找字段
+func lookupPath(req map[string]interface{}, path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+	current := req
+	for i, part := range parts {
+		value, ok := current[part]
+		if !ok {
+			return nil, false
+		}
+		if i == len(parts)-1 {
+			return value, true
+		}
+		if current, ok = value.(map[string]interface{}); !ok {
+			return nil, false
+		}
+	}
+	return nil, false
+}
+
+// setPath 按 a.b 路径设置字段，中间层不存在时创建
+func setPath(req map[string]interface{}, path string, value interface{}) {
+	parts := strings.Split(path, ".")
+	current := req
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[part] = next
+		}
+		current = next
+	}
+	current[parts[len(parts)-1]] = normalizeValue(value)
+}
+
+// deletePath 按 a.b 路径删除字段
+func deletePath(req map[string]interface{}, path string) {
+	parts := strings.Split(path, ".")
+	current := req
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		current = next
+	}
+	delete(current, parts[len(parts)-1])
+}
+
+// normalizeValue 将 YAML 解析出的 map[interface{}]interface{} 转为可序列化为 JSON 的结构
+func normalizeValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized[fmt.Sprint(key)] = normalizeValue(item)
+		}
+		return normalized
+	case map[string]interface{}:
+		normalized := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			normalized[key] = normalizeValue(item)
+		}
+		return normalized
+	case []interface{}:
+		normalized := make([]interface{}, len(v))
+		for i, item := range v {
+			normalized[i] = normalizeValue(item)
+		}
+		return normalized
+	}
+	return value
+}
+
+// toInt64 读取 JSON 数字
+func toInt64(value interface{}) (int64, bool) {
+	switch v := value.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		if err != nil {
+			f, ferr := v.Float64()
+			return int64(f), ferr == nil
+		}
+		return n, true
+	case float64:
+		return int64(v), true
+	case int:
+		return int64(v), true
+	case int64:
+		return v, true
+	}
+	return 0, false
+}
+
+// clampMaxTokens 限制 max_tokens 上限
+// thinking.budget_tokens 必须小于 max_tokens，随之下调；下调后低于最小预算时移除 thinking
+func clampMaxTokens(req map[string]interface{}, max int64) {
+	current, ok := toInt64(req["max_tokens"])
+	if !ok || current <= max {
+		return
+	}
+	req["max_tokens"] = max
+
+	thinking, ok := req["thinking"].(map[string]interface{})
+	if !ok {
+		return
+	}
+	budget, ok := toInt64(thinking["budget_tokens"])
+	if !ok || budget < max {
+		return
+	}
+	if max-1 < minThinkingBudget {
+		delete(req, "thinking")
+		return
+	}
+	thinking["budget_tokens"] = max - 1
+}
+
+// stripCacheControl 递归移除 cache_control 字段
+func stripCacheControl(value interface{}) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		delete(v, "cache_control")
+		for _, item := range v {
+			stripCacheControl(item)
+		}
+	case []interface{}:
+		for _, item := range v {
+			stripCacheControl(item)
+		}
+	}
+}
+
+// matchToolType 判断工具类型是否匹配（支持以 * 结尾的前缀）
+func matchToolType(toolType string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if strings.HasSuffix(pattern, "*") {
+			if strings.HasPrefix(toolType, strings.TrimSuffix(pattern, "*")) {
+				return true
+			}
+		} else if toolType == pattern {
+			return true
+		}
+	}
+	return false
+}
+
+// removeToolTypes 移除指定类型的工具（服务端工具如 web_search_20250305、computer_*）
+// 移除后 tool_choice 指向的工具不存在或已没有工具时，一并移除 tool_choice
+func removeToolTypes(req map[string]interface{}, patterns []string) {
+	tools, ok := req["tools"].([]interface{})
+	if !ok {
+		return
+	}
+
+	kept := make([]interface{}, 0, len(tools))
+	names := make(map[string]bool)
+	for _, item := range tools {
+		tool, ok := item.(map[string]interface{})
+		if ok {
+			if toolType, _ := tool["type"].(string); toolType != "" && matchToolType(toolType, patterns) {
+				continue
+			}
+			if name, _ := tool["name"].(string); name != "" {
+				names[name] = true
+			}
+		}
+		kept = append(kept, item)
+	}
+	if len(kept) == len(tools) {
+		return
+	}
+
+	if len(kept) == 0 {
+		delete(req, "tools")
+		delete(req, "tool_choice")
+		return
+	}
+	req["tools"] = kept
+	if choice, ok := req["tool_choice"].(map[string]interface{}); ok {
+		if name, _ := choice["name"].(string); name != "" && !names[name] {
+			delete(req, "tool_choice")
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package transform
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// assertJSON 按 JSON 语义比较（忽略字段顺序）
+func assertJSON(t *testing.T, got []byte, want string) {
+	t.Helper()
+	var gotValue, wantValue interface{}
+	if err := json.Unmarshal(got, &gotValue); err != nil {
+		t.Fatalf("解析结果失败: %v\n%s", err, got)
+	}
+	if err := json.Unmarshal([]byte(want), &wantValue); err != nil {
+		t.Fatalf("解析期望值失败: %v\n%s", err, want)
+	}
+	if !reflect.DeepEqual(gotValue, wantValue) {
+		t.Errorf("结果不符\n got: %s\nwant: %s", got, want)
+	}
+}
+
+func TestApply(t *testing.T) {
+	tests := []struct {
+		name  string
+		body  string
+		steps []models.Transform
+		want  string
+	}{
+		{
+			name: "drop_fields 删除顶层和嵌套字段",
+			body: `{"model":"m","thinking":{"type":"enabled","budget_tokens":2000},"metadata":{"user_id":"u","x":1}}`,
+			steps: []models.Transform{
+				{Type: models.TransformDropFields, Fields: []string{"thinking", "metadata.user_id", "missing.path"}},
+			},
+			want: `{"model":"m","metadata":{"x":1}}`,
+		},
+		{
+			name: "drop_fields 中间层不是对象时不修改",
+			body: `{"model":"m","metadata":"text"}`,
+			steps: []models.Transform{
+				{Type: models.TransformDropFields, Fields: []string{"model.name", "metadata.user_id"}},
+			},
+			want: `{"model":"m","metadata":"text"}`,
+		},
+		{
+			name: "set_default 创建缺失的中间层",
+			body: `{"model":"m"}`,
+			steps: []models.Transform{
+				{Type: models.TransformSetDefault, Field: "metadata.user_id", Value: "proxy"},
+			},
+			want: `{"model":"m","metadata":{"user_id":"proxy"}}`,
+		},
+		{
+			name: "set_default 字段已存在时保持原值",
+			body: `{"model":"m","metadata":{"user_id":"u"}}`,
+			steps: []models.Transform{
+				{Type: models.TransformSetDefault, Field: "metadata.user_id", Value: "proxy"},
+			},
+			want: `{"model":"m","metadata":{"user_id":"u"}}`,
+		},
+		{
+			name: "set_default 支持 YAML 解析出的映射",
+			body: `{"model":"m"}`,
+			steps: []models.Transform{
+				{Type: models.TransformSetDefault, Field: "thinking", Value: map[interface{}]interface{}{"type": "disabled"}},
+			},
+			want: `{"model":"m","thinking":{"type":"disabled"}}`,
+		},
+		{
+			name: "clamp_max_tokens 下调 thinking 预算",
+			body: `{"max_tokens":32000,"thinking":{"type":"enabled","budget_tokens":16000}}`,
+			steps: []models.Transform{
+				{Type: models.TransformClampMaxTokens, Max: 8192},
+			},
+			want: `{"max_tokens":8192,"thinking":{"type":"enabled","budget_tokens":8191}}`,
+		},
+		{
+			name: "clamp_max_tokens 预算低于最小值时移除 thinking",
+			body: `{"max_tokens":32000,"thinking":{"type":"enabled","budget_tokens":16000}}`,
+			steps: []models.Transform{
+				{Type: models.TransformClampMaxTokens, Max: 1024},
+			},
+			want: `{"max_tokens":1024}`,
+		},
+		{
+			name: "clamp_max_tokens 预算已小于上限时不修改",
+			body: `{"max_tokens":32000,"thinking":{"type":"enabled","budget_tokens":4000}}`,
+			steps: []models.Transform{
+				{Type: models.TransformClampMaxTokens, Max: 8192},
+			},
+			want: `{"max_tokens":8192,"thinking":{"type":"enabled","budget_tokens":4000}}`,
+		},
+		{
+			name: "clamp_max_tokens 未超过上限时不修改",
+			body: `{"max_tokens":4096,"thinking":{"type":"enabled","budget_tokens":4000}}`,
+			steps: []models.Transform{
+				{Type: models.TransformClampMaxTokens, Max: 8192},
+			},
+			want: `{"max_tokens":4096,"thinking":{"type":"enabled","budget_tokens":4000}}`,
+		},
+		{
+			name: "strip_cache_control 递归移除",
+			body: `{"system":[{"type":"text","text":"s","cache_control":{"type":"ephemeral"}}],"messages":[{"role":"user","content":[{"type":"text","text":"hi","cache_control":{"type":"ephemeral"}}]}]}`,
+			steps: []models.Transform{
+				{Type: models.TransformStripCacheControl},
+			},
+			want: `{"system":[{"type":"text","text":"s"}],"messages":[{"role":"user","content":[{"type":"text","text":"hi"}]}]}`,
+		},
+		{
+			name: "remove_tool_types 移除指向已删除工具的 tool_choice",
+			body: `{"tools":[{"type":"web_search_20250305","name":"web_search"},{"name":"Read","input_schema":{}}],"tool_choice":{"type":"tool","name":"web_search"}}`,
+			steps: []models.Transform{
+				{Type: models.TransformRemoveToolTypes, ToolTypes: []string{"web_search_*"}},
+			},
+			want: `{"tools":[{"name":"Read","input_schema":{}}]}`,
+		},
+		{
+			name: "remove_tool_types 保留仍然有效的 tool_choice",
+			body: `{"tools":[{"type":"computer_20241022","name":"computer"},{"name":"Read","input_schema":{}}],"tool_choice":{"type":"tool","name":"Read"}}`,
+			steps: []models.Transform{
+				{Type: models.TransformRemoveToolTypes, ToolTypes: []string{"computer_*"}},
+			},
+			want: `{"tools":[{"name":"Read","input_schema":{}}],"tool_choice":{"type":"tool","name":"Read"}}`,
+		},
+		{
+			name: "remove_tool_types 工具全部移除时一并移除 tool_choice",
+			body: `{"tools":[{"type":"web_search_20250305","name":"web_search"}],"tool_choice":{"type":"any"}}`,
+			steps: []models.Transform{
+				{Type: models.TransformRemoveToolTypes, ToolTypes: []string{"web_search_20250305"}},
+			},
+			want: `{}`,
+		},
+		{
+			name: "rename_model 使用 to",
+			body: `{"model":"claude-sonnet-4-20250514"}`,
+			steps: []models.Transform{
+				{Type: models.TransformRenameModel, To: "glm-4.5"},
+			},
+			want: `{"model":"glm-4.5"}`,
+		},
+		{
+			name: "rename_model 同时配置时 to 优先",
+			body: `{"model":"claude-sonnet-4-20250514"}`,
+			steps: []models.Transform{
+				{Type: models.TransformRenameModel, To: "glm-4.5", Map: map[string]string{"*": "kimi"}},
+			},
+			want: `{"model":"glm-4.5"}`,
+		},
+		{
+			name: "rename_model 使用 map 时最长前缀优先",
+			body: `{"model":"claude-3-5-haiku-20241022"}`,
+			steps: []models.Transform{
+				{Type: models.TransformRenameModel, Map: map[string]string{"claude-*": "glm-4.5", "claude-3-5-*": "glm-4.5-air"}},
+			},
+			want: `{"model":"glm-4.5-air"}`,
+		},
+		{
+			name: "rename_model 的 map 未匹配时保持原值",
+			body: `{"model":"gpt-4o"}`,
+			steps: []models.Transform{
+				{Type: models.TransformRenameModel, Map: map[string]string{"claude-*": "glm-4.5"}},
+			},
+			want: `{"model":"gpt-4o"}`,
+		},
+		{
+			name: "多个步骤按顺序执行",
+			body: `{"model":"claude-opus-4","max_tokens":64000,"thinking":{"type":"enabled","budget_tokens":32000}}`,
+			steps: []models.Transform{
+				{Type: models.TransformDropFields, Fields: []string{"thinking"}},
+				{Type: models.TransformClampMaxTokens, Max: 8192},
+				{Type: models.TransformRenameModel, To: "kimi-k2"},
+			},
+			want: `{"model":"kimi-k2","max_tokens":8192}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Apply([]byte(tt.body), tt.steps)
+			if err != nil {
+				t.Fatalf("Apply 失败: %v", err)
+			}
+			assertJSON(t, got, tt.want)
+		})
+	}
+}
+
+func TestApplyPreservesNumbers(t *testing.T) {
+	body := `{"max_tokens":100,"seed":9007199254740993,"temperature":0.70,"metadata":{"n":12345678901234567890}}`
+	got, err := Apply([]byte(body), []models.Transform{{Type: models.TransformStripCacheControl}})
+	if err != nil {
+		t.Fatalf("Apply 失败: %v", err)
+	}
+	for _, want := range []string{`"seed":9007199254740993`, `"temperature":0.70`, `"n":12345678901234567890`, `"max_tokens":100`} {
+		if !strings.Contains(string(got), want) {
+			t.Errorf("数字没有原样保留: 缺少 %s\n%s", want, got)
+		}
+	}
+}
+
+func TestApplyWithoutSteps(t *testing.T) {
+	body := []byte(`{"model":"m",  "max_tokens":1}`)
+	got, err := Apply(body, nil)
+	if err != nil {
+		t.Fatalf("Apply 失败: %v", err)
+	}
+	if string(got) != string(body) {
+		t.Errorf("没有转换步骤时应原样返回: %s", got)
+	}
+}
+
+func TestApplyErrors(t *testing.T) {
+	if _, err := Apply([]byte(`{"model":`), []models.Transform{{Type: models.TransformStripCacheControl}}); err == nil {
+		t.Error("请求体无效时应返回错误")
+	}
+	if _, err := Apply([]byte(`{"model":"m"}`), []models.Transform{{Type: "unknown"}}); err == nil {
+		t.Error("未知的转换类型应返回错误")
+	}
+}
+
+func TestApplyStep(t *testing.T) {
+	tests := []struct {
+		name string
+		req  map[string]interface{}
+		step models.Transform
+		want map[string]interface{}
+	}{
+		{
+			name: "clamp_max_tokens 支持 json.Number",
+			req: map[string]interface{}{
+				"max_tokens": json.Number("20000"),
+				"thinking":   map[string]interface{}{"budget_tokens": json.Number("20000")},
+			},
+			step: models.Transform{Type: models.TransformClampMaxTokens, Max: 4096},
+			want: map[string]interface{}{
+				"max_tokens": int64(4096),
+				"thinking":   map[string]interface{}{"budget_tokens": int64(4095)},
+			},
+		},
+		{
+			name: "set_default 嵌套路径",
+			req:  map[string]interface{}{"metadata": map[string]interface{}{}},
+			step: models.Transform{Type: models.TransformSetDefault, Field: "metadata.user_id", Value: "proxy"},
+			want: map[string]interface{}{"metadata": map[string]interface{}{"user_id": "proxy"}},
+		},
+		{
+			name: "drop_fields 嵌套路径",
+			req:  map[string]interface{}{"metadata": map[string]interface{}{"user_id": "u"}},
+			step: models.Transform{Type: models.TransformDropFields, Fields: []string{"metadata.user_id"}},
+			want: map[string]interface{}{"metadata": map[string]interface{}{}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ApplyStep(tt.req, tt.step); err != nil {
+				t.Fatalf("ApplyStep 失败: %v", err)
+			}
+			if !reflect.DeepEqual(tt.req, tt.want) {
+				t.Errorf("结果不符\n got: %#v\nwant: %#v", tt.req, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package usage
+
+import (
+	"sync"
+)
+
+// Totals 累计用量与费用
+type Totals struct {
+	Requests     int64   `json:"requests"`
+	Usage        Usage   `json:"usage"`
+	Cost         float64 `json:"cost"`
+	BaselineCost float64 `json:"baseline_cost"` // 全部请求按基准价格表计算的费用
+}
+
+// Stats 进程内的用量统计（用于 /status）
+type Stats struct {
+	mu        sync.RWMutex
+	total     Totals
+	byService map[string]*Totals
+	byLevel   map[int]*Totals
+}
+
+// NewStats 创建用量统计
+func NewStats() *Stats {
+	return &Stats{
+		byService: make(map[string]*Totals),
+		byLevel:   make(map[int]*Totals),
+	}
+}
+
+// Record 记录一次请求的用量和费用
+func (s *Stats) Record(serviceID string, difficultyLevel int, u Usage, cost, baselineCost float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	svc, ok := s.byService[serviceID]
+	if !ok {
+		svc = &Totals{}
+		s.byService[serviceID] = svc
+	}
+	level, ok := s.byLevel[difficultyLevel]
+	if !ok {
+		level = &Totals{}
+		s.byLevel[difficultyLevel] = level
+	}
+
+	for _, t := range []*Totals{&s.total, svc, level} {
+		t.Requests++
+		t.Usage.Add(u)
+		t.Cost += cost
+		t.BaselineCost += baselineCost
+	}
+}
+
+// Snapshot 获取统计快照
+func (s *Stats) Snapshot() map[string]interface{} {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	byService := make(map[string]Totals, len(s.byService))
+	for id, t := range s.byService {
+		byService[id] = *t
+	}
+	byLevel := make(map[int]Totals, len(s.byLevel))
+	for level, t := range s.byLevel {
+		byLevel[level] = *t
+	}
+
+	// 与基准价格相比节省的比例
+	var savings float64
+	if s.total.BaselineCost > 0 {
+		savings = 1 - s.total.Cost/s.total.BaselineCost
+	}
+
+	return map[string]interface{}{
+		"total":         s.total,
+		"by_service":    byService,
+		"by_level":      byLevel,
+		"savings_ratio": savings,
+	}
+}
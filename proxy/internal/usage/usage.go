@@ -0,0 +1,114 @@
+package usage
+
+import (
+	"encoding/json"
+	"strings"
+
+	"github.com/ethan/claude-proxy/internal/models"
+)
+
+// Usage 单次请求的 token 用量（与 Anthropic Messages API 的 usage 字段一致）
+type Usage struct {
+	InputTokens              int64 `json:"input_tokens"`
+	OutputTokens             int64 `json:"output_tokens"`
+	CacheReadInputTokens     int64 `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int64 `json:"cache_creation_input_tokens"`
+}
+
+// Add 累加用量
+func (u *Usage) Add(other Usage) {
+	u.InputTokens += other.InputTokens
+	u.OutputTokens += other.OutputTokens
+	u.CacheReadInputTokens += other.CacheReadInputTokens
+	u.CacheCreationInputTokens += other.CacheCreationInputTokens
+}
+
+// Total 总 token 数
+func (u Usage) Total() int64 {
+	return u.InputTokens + u.OutputTokens + u.CacheReadInputTokens + u.CacheCreationInputTokens
+}
+
+// Cost 按价格表计算费用（价格单位：每百万 token）
+func (u Usage) Cost(pricing models.Pricing) float64 {
+	return (float64(u.InputTokens)*pricing.InputPerMTok +
+		float64(u.OutputTokens)*pricing.OutputPerMTok +
+		float64(u.CacheReadInputTokens)*pricing.CacheReadPerMTok +
+		float64(u.CacheCreationInputTokens)*pricing.CacheWritePerMTok) / 1e6
+}
+
+// ParseResponse 从非流式响应体中解析 usage
+func ParseResponse(body []byte) (Usage, bool) {
+	var resp struct {
+		Usage *Usage `json:"usage"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.Usage == nil {
+		return Usage{}, false
+	}
+	return *resp.Usage, true
+}
+
+// StreamTracker 从流式响应的 SSE 事件中累计 usage
+// message_start 携带输入相关的用量，message_delta 携带累计的输出 token 数
+type StreamTracker struct {
+	usage Usage
+	found bool
+}
+
+// ObserveLine 处理一行 SSE 数据，非 data 行直接忽略
+func (t *StreamTracker) ObserveLine(line string) {
+	if !strings.HasPrefix(line, "data:") {
+		return
+	}
+	data := strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+	if !strings.Contains(data, `"usage"`) {
+		return
+	}
+
+	var event struct {
+		Type    string `json:"type"`
+		Message struct {
+			Usage *Usage `json:"usage"`
+		} `json:"message"`
+		Usage *Usage `json:"usage"`
+	}
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		return
+	}
+
+	switch event.Type {
+	case "message_start":
+		if event.Message.Usage != nil {
+			t.usage = *event.Message.Usage
+			t.found = true
+		}
+	case "message_delta":
+		if event.Usage != nil {
+			// message_delta 中的数值是累计值，非零字段覆盖 message_start 的值
+			if event.Usage.OutputTokens > 0 {
+				t.usage.OutputTokens = event.Usage.OutputTokens
+			}
+			if event.Usage.InputTokens > 0 {
+				t.usage.InputTokens = event.Usage.InputTokens
+			}
+			if event.Usage.CacheReadInputTokens > 0 {
+				t.usage.CacheReadInputTokens = event.Usage.CacheReadInputTokens
+			}
+			if event.Usage.CacheCreationInputTokens > 0 {
+				t.usage.CacheCreationInputTokens = event.Usage.CacheCreationInputTokens
+			}
+			t.found = true
+		}
+	}
+}
+
+// ObserveEvent 处理一个完整的原始 SSE 事件（可能包含多行）
+func (t *StreamTracker) ObserveEvent(event []byte) {
+	for _, line := range strings.Split(string(event), "\n") {
+		t.ObserveLine(strings.TrimRight(line, "\r"))
+	}
+}
+
+// Usage 返回累计的用量，第二个返回值表示是否解析到了 usage
+func (t *StreamTracker) Usage() (Usage, bool) {
+	return t.usage, t.found
+}